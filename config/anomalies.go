@@ -0,0 +1,65 @@
+package config
+
+import (
+	"github.com/silverstagtech/teller/metricCreator"
+)
+
+// Anomaly is laid over the values of the events in a time slice so that a scenario can
+// reuse a normal event rather than copying it. Type is one of metricCreator.ValidAnomalyTypes.
+// Events are the metric names it applies to, all the influx, statsd and file events of the
+// time slice if it is empty. Fields are the fields it changes, all the numeric ones if it is
+// empty. It starts at fire StartFire of each pass of an event and lasts for Fires fires, or
+// to the end of the pass if Fires is 0. Factor is the multiplier of a spike. Amount is the
+// change of a step and the standard deviation of a noise_burst.
+type Anomaly struct {
+	Type      string   `json:"type"`
+	Events    []string `json:"events"`
+	Fields    []string `json:"fields"`
+	StartFire int      `json:"start_fire"`
+	Fires     int      `json:"fires"`
+	Factor    float64  `json:"factor"`
+	Amount    float64  `json:"amount"`
+}
+
+// Overlay returns the metricCreator.Anomaly that the anomaly describes.
+func (a *Anomaly) Overlay() (*metricCreator.Anomaly, error) {
+	return metricCreator.NewAnomaly(a.Type, a.Fields, a.StartFire, a.Fires, a.Factor, a.Amount)
+}
+
+// appliesTo is true if the anomaly changes the event.
+func (a *Anomaly) appliesTo(e *Event) bool {
+	if len(a.Events) == 0 {
+		for _, renderedType := range validRenderedEventTypes {
+			if e.Type == renderedType {
+				return true
+			}
+		}
+		return false
+	}
+	for _, name := range a.Events {
+		if name == e.MetricName {
+			return true
+		}
+	}
+	return false
+}
+
+// covers is true if the anomaly is laid over the fire of a pass given.
+func (a *Anomaly) covers(fire int) bool {
+	return fire >= a.StartFire && (a.Fires == 0 || fire < a.StartFire+a.Fires)
+}
+
+// GappedFires returns how many fires of each pass of the event send nothing because a
+// gap anomaly of the time slice covers them.
+func (ts *Timeslice) GappedFires(e *Event) int {
+	gapped := 0
+	for fire := 0; fire < e.Repeat; fire++ {
+		for _, anomaly := range ts.Anomalies {
+			if anomaly.Type == metricCreator.AnomalyGap && anomaly.appliesTo(e) && anomaly.covers(fire) {
+				gapped++
+				break
+			}
+		}
+	}
+	return gapped
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// coerce walks a decoded configuration next to the type it will be decoded into and
+// changes strings into numbers or bools where the type expects them, and numbers or
+// bools into strings where a string is expected. This allows values that came from
+// environment variables, which are always strings, to be used anywhere.
+// Values that can't be converted are left alone so that decoding gives the normal error.
+func coerce(value interface{}, t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(jsonUnmarshaler) {
+		return value
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return value
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			if item, ok := m[name]; ok {
+				m[name] = coerce(item, field.Type)
+			}
+		}
+		return m
+	case reflect.Map:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return value
+		}
+		for key, item := range m {
+			m[key] = coerce(item, t.Elem())
+		}
+		return m
+	case reflect.Slice:
+		l, ok := value.([]interface{})
+		if !ok {
+			return value
+		}
+		for index, item := range l {
+			l[index] = coerce(item, t.Elem())
+		}
+		return l
+	case reflect.String:
+		switch v := value.(type) {
+		case json.Number:
+			return v.String()
+		case bool, int, int64, float64:
+			return toString(v)
+		}
+	case reflect.Bool:
+		if s, ok := value.(string); ok {
+			if b, err := strconv.ParseBool(s); err == nil {
+				return b
+			}
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if s, ok := value.(string); ok {
+			if _, err := strconv.ParseFloat(s, 64); err == nil {
+				return json.Number(s)
+			}
+		}
+	}
+	return value
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"reflect"
 
 	"github.com/silverstagtech/loggos"
 )
@@ -11,23 +12,56 @@ import (
 // Config contains a logger and a story. Use the story to run the shippers.
 type Config struct {
 	configPath string
+	format     string
+	tree       map[string]interface{}
+	variables  *storyVariables
 	Story      *Story
 }
 
+// Options changes how a configuration file is read.
+type Options struct {
+	// Format is one of json, yaml or toml. If it is blank the extension of the
+	// configuration file is used to pick the format. Included files always use
+	// their extension.
+	Format string
+	// Variables override the defaults of the variables in the story.
+	Variables map[string]string
+}
+
 // New creates a configuration that contains the story of the configuration file define by
 // the user. It will return a *Config and a list of errors that it found along the way.
 // These could be failed to open file or configuration validation failures.
 func New(filepath string) (*Config, error) {
+	return NewWithOptions(filepath, Options{})
+}
+
+// NewWithOptions is the same as New but allows the options used to read the
+// configuration file to be changed.
+func NewWithOptions(filepath string, opts Options) (*Config, error) {
+	return NewFromFiles([]string{filepath}, opts)
+}
+
+// NewFromFiles creates a configuration from a story that is split over several files.
+// The connections, global tags, templates and timelines of every file, and the files they
+// include, are merged together. The story settings come from the first file.
+func NewFromFiles(paths []string, opts Options) (*Config, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no configuration files were given")
+	}
 	c := &Config{
-		configPath: filepath,
+		configPath: paths[0],
+		format:     opts.Format,
+		variables:  newStoryVariables(opts.Variables),
 	}
 
-	file, err := c.readConfigFile()
+	errorBucket := new(ValidationError)
+	files, err := c.loadFiles(paths, errorBucket)
 	if err != nil {
 		return nil, err
 	}
+	c.tree = files[0].tree
 
-	story, err := c.newStory(file)
+	story, err := c.composeStory(files, errorBucket)
 	if err != nil {
 		return nil, err
 	}
@@ -36,31 +70,51 @@ func New(filepath string) (*Config, error) {
 	loggos.JSONLoggerEnablePrettyPrint(true)
 	loggos.JSONLoggerEnableHumanTimestamps(true)
 
-	err = c.validate(story)
+	story.setEventContext()
+	err = c.validate(story, errorBucket)
 	if err != nil {
 		return nil, err
 	}
 
 	c.Story = story
 	c.shimGlobalTags()
+	c.shimStateTags()
 	return c, nil
 }
 
-func (cf *Config) readConfigFile() ([]byte, error) {
-	file, err := ioutil.ReadFile(cf.configPath)
+func (cf *Config) readConfigFile(path string) ([]byte, error) {
+	file, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to open the supplied configuration file. Error %s", err)
 	}
 	if len(file) == 0 {
-		return nil, fmt.Errorf("configuration file was empty")
+		return nil, fmt.Errorf("configuration file %s was empty", path)
 	}
 	return file, nil
 }
 
-// NewStory takes a new
-func (cf *Config) newStory(config []byte) (*Story, error) {
+// resolve returns a copy of the decoded configuration with the environment variables
+// and secret files filled in. The original is kept so that it can be converted to
+// other formats without writing out secrets. Secret files are relative to dir.
+func (cf *Config) resolve(tree map[string]interface{}, dir string) (map[string]interface{}, error) {
+	errorBucket := new(ValidationError)
+	resolved := copyTree(tree).(map[string]interface{})
+	interpolateEnv(resolved, errorBucket)
+	readSecretFiles(resolved, dir, errorBucket)
+	if errorBucket.hasErrors() {
+		return nil, errorBucket
+	}
+	return resolved, nil
+}
+
+// newStory takes the decoded configuration and turns it into a Story.
+func (cf *Config) newStory(tree map[string]interface{}) (*Story, error) {
+	config, err := json.Marshal(coerce(tree, reflect.TypeOf(Story{})))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create a story. Error %s", err)
+	}
 	story := new(Story)
-	err := json.Unmarshal(config, story)
+	err = json.Unmarshal(config, story)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create a story. Error %s", err)
 	}
@@ -87,8 +141,27 @@ func (cf *Config) shimGlobalTags() {
 	}
 }
 
-func (cf *Config) validate(story *Story) error {
-	errorBucket := new(ValidationError)
+// shimStateTags adds the name of the time slice to the events of timelines that have a state tag.
+func (cf *Config) shimStateTags() {
+	for _, timeline := range cf.Story.TimeLines {
+		if timeline.StateTag == "" {
+			continue
+		}
+		for _, timeslice := range timeline.Timeslices {
+			for _, event := range timeslice.Events {
+				if event.Type == "sleeper" {
+					continue
+				}
+				if event.Tags == nil {
+					event.Tags = make(map[string]string)
+				}
+				event.Tags[timeline.StateTag] = timeslice.Name
+			}
+		}
+	}
+}
+
+func (cf *Config) validate(story *Story, errorBucket *ValidationError) error {
 	validateStory(*story, errorBucket)
 
 	if len(errorBucket.errs) > 0 {
@@ -61,6 +61,22 @@ var (
           "repeat": 1,
           "single_use": true,
           "events": [
+            {
+              "metric_name": "annotations",
+              "type": "annotation",
+              "connection_id": "influx1",
+              "annotation": {
+                "title": "Story started",
+                "text": "The example story is starting",
+                "tags": ["teller", "example"]
+              },
+              "repeat": 1,
+              "time_between": {
+                "static": {
+                  "time": 10
+                }
+              }
+            },
             {
               "metric_name": "influx_test1",
               "type": "influx",
@@ -1,7 +1,15 @@
 package config
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/silverstagtech/teller/metricCreator"
 )
 
 func TestNewConfig(t *testing.T) {
@@ -12,3 +20,980 @@ func TestNewConfig(t *testing.T) {
 	}
 	t.Log(c)
 }
+
+func TestFormatFromPath(t *testing.T) {
+	tests := map[string]string{
+		"story.json": FormatJSON,
+		"story.yaml": FormatYAML,
+		"story.YML":  FormatYAML,
+		"story.toml": FormatTOML,
+		"story":      FormatJSON,
+	}
+	for path, expected := range tests {
+		if got := FormatFromPath(path); got != expected {
+			t.Logf("FormatFromPath(%q) expected %s. Got: %s", path, expected, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestConvertFormats(t *testing.T) {
+	original, err := New("./example.json")
+	if err != nil {
+		t.Logf("Failed to create a config. Errors %s", err)
+		t.FailNow()
+	}
+
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Logf("Failed to create a temp dir. Error: %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	for _, format := range []string{FormatYAML, FormatTOML, FormatJSON} {
+		converted, err := Convert("./example.json", "", format)
+		if err != nil {
+			t.Logf("Failed to convert to %s. Error: %s", format, err)
+			t.FailNow()
+		}
+		path := filepath.Join(dir, "story."+format)
+		if err := ioutil.WriteFile(path, converted, 0644); err != nil {
+			t.Logf("Failed to write %s. Error: %s", path, err)
+			t.FailNow()
+		}
+		c, err := New(path)
+		if err != nil {
+			t.Logf("Failed to read the %s story. Error: %s", format, err)
+			t.FailNow()
+		}
+		if c.String() != original.String() {
+			t.Logf("The %s story is not the same as the original.\nExpected:\n%s\nGot:\n%s", format, original, c)
+			t.Fail()
+		}
+	}
+}
+
+func TestFormatOverride(t *testing.T) {
+	if _, err := NewWithOptions("./example.json", Options{Format: FormatYAML}); err != nil {
+		t.Logf("JSON should be readable as YAML. Error: %s", err)
+		t.Fail()
+	}
+	if _, err := NewWithOptions("./example.json", Options{Format: FormatTOML}); err == nil {
+		t.Logf("Reading JSON as TOML should fail.")
+		t.Fail()
+	}
+	if _, err := NewWithOptions("./example.json", Options{Format: "xml"}); err == nil {
+		t.Logf("An invalid format should fail.")
+		t.Fail()
+	}
+}
+
+func TestInterpolateEnv(t *testing.T) {
+	os.Setenv("TELLER_TEST_SET", "value")
+	os.Setenv("TELLER_TEST_EMPTY", "")
+	os.Unsetenv("TELLER_TEST_UNSET")
+
+	tests := map[string]string{
+		"${TELLER_TEST_SET}":                     "value",
+		"a-${TELLER_TEST_SET}-b":                 "a-value-b",
+		"${TELLER_TEST_UNSET:-default}":          "default",
+		"${TELLER_TEST_EMPTY:-default}":          "default",
+		"${TELLER_TEST_EMPTY}":                   "",
+		"$${TELLER_TEST_SET}":                    "${TELLER_TEST_SET}",
+		"${TELLER_TEST_SET:-}${TELLER_TEST_SET}": "valuevalue",
+	}
+	for input, expected := range tests {
+		errorBucket := new(ValidationError)
+		got := interpolateEnv(input, errorBucket)
+		if got != expected || errorBucket.hasErrors() {
+			t.Logf("interpolateEnv(%q) expected %q. Got: %q, errors: %v", input, expected, got, errorBucket.errs)
+			t.Fail()
+		}
+	}
+
+	errorBucket := new(ValidationError)
+	interpolateEnv(map[string]interface{}{"password": "${TELLER_TEST_UNSET}"}, errorBucket)
+	if !errorBucket.hasErrors() || !strings.Contains(errorBucket.Error(), "TELLER_TEST_UNSET") {
+		t.Logf("Expected an error naming TELLER_TEST_UNSET. Got: %v", errorBucket.errs)
+		t.Fail()
+	}
+}
+
+func TestEnvAndSecretFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Logf("Failed to create a temp dir. Error: %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	story, err := ioutil.ReadFile("./example.json")
+	if err != nil {
+		t.Logf("Failed to read the example. Error: %s", err)
+		t.FailNow()
+	}
+	story = bytes.Replace(story, []byte(`"password": "password"`), []byte(`"password_file": "influx_password"`), -1)
+	story = bytes.Replace(story, []byte(`"port": 8125`), []byte(`"port": "${TELLER_TEST_PORT:-8126}"`), -1)
+	story = bytes.Replace(story, []byte(`"username": "user"`), []byte(`"username": "${TELLER_TEST_USER}"`), -1)
+	storyPath := filepath.Join(dir, "story.json")
+	ioutil.WriteFile(storyPath, story, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "influx_password"), []byte("s3cret\n"), 0600)
+	os.Setenv("TELLER_TEST_USER", "teller")
+
+	c, err := New(storyPath)
+	if err != nil {
+		t.Logf("Failed to create a config. Errors %s", err)
+		t.FailNow()
+	}
+	if c.Story.Influx[0].Password != "s3cret" || c.Story.Influx[0].Username != "teller" {
+		t.Logf("Influx credentials were not filled in. Got: %+v", c.Story.Influx[0])
+		t.Fail()
+	}
+	if c.Story.StatsD[0].Port != 8126 {
+		t.Logf("Expected the statsd port from the default to be 8126. Got: %d", c.Story.StatsD[0].Port)
+		t.Fail()
+	}
+
+	// Converting must not write out the secrets.
+	converted, err := Convert(storyPath, "", FormatJSON)
+	if err != nil {
+		t.Logf("Failed to convert. Error: %s", err)
+		t.FailNow()
+	}
+	if bytes.Contains(converted, []byte("s3cret")) || !bytes.Contains(converted, []byte("${TELLER_TEST_USER}")) {
+		t.Logf("The converted story should have the references and not the values.")
+		t.Fail()
+	}
+
+	os.Unsetenv("TELLER_TEST_USER")
+	_, err = New(storyPath)
+	if err == nil || !strings.Contains(err.Error(), "TELLER_TEST_USER") {
+		t.Logf("Expected an error naming the unset variable. Got: %v", err)
+		t.Fail()
+	}
+	if _, err := Convert(storyPath, "", FormatYAML); err != nil {
+		t.Logf("Converting should not need the environment variables to be set. Error: %s", err)
+		t.Fail()
+	}
+}
+
+func TestSecretFilesOnlyCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Logf("Failed to create a temp dir. Error: %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "token"), []byte("abc\n"), 0600)
+
+	tree := decodeTestTree(t, `{"http": [{"id": "hooks", "bearer_token_file": "token", "log_file": "missing"}],
+ "timelines": [{"time_slices": [{"time_slice_name": "slice", "events": [
+  {"metric_name": "logs", "tags": {"log_file": "/var/log/syslog"}}
+]}]}]}`)
+	errorBucket := new(ValidationError)
+	readSecretFiles(tree, dir, errorBucket)
+	if errorBucket.hasErrors() {
+		t.Logf("Only credential files should be read. Got: %v", errorBucket.errs)
+		t.FailNow()
+	}
+	connection := tree["http"].([]interface{})[0].(map[string]interface{})
+	if connection["bearer_token"] != "abc" || connection["bearer_token_file"] != nil || connection["log_file"] != "missing" {
+		t.Logf("Expected only the bearer token to be read. Got: %v", connection)
+		t.Fail()
+	}
+	event := tree["timelines"].([]interface{})[0].(map[string]interface{})["time_slices"].([]interface{})[0].(map[string]interface{})["events"].([]interface{})[0].(map[string]interface{})
+	if tags := event["tags"].(map[string]interface{}); tags["log_file"] != "/var/log/syslog" || tags["log"] != nil {
+		t.Logf("Event tags ending in _file should be left alone. Got: %v", tags)
+		t.Fail()
+	}
+}
+
+func decodeTestTree(t *testing.T, story string) map[string]interface{} {
+	tree, err := decode([]byte(story), FormatJSON)
+	if err != nil {
+		t.Logf("Failed to decode the test story. Error: %s", err)
+		t.FailNow()
+	}
+	return tree
+}
+
+func TestResolveTemplates(t *testing.T) {
+	tree := decodeTestTree(t, `{
+  "templates": {
+    "base": {
+      "type": "influx",
+      "connection_id": "influx1",
+      "tags": {"service": "api", "region": "eu"},
+      "fields": {"value": 1},
+      "repeat": 10,
+      "time_between": {"static": {"time": 100}}
+    },
+    "errors": {
+      "extends": "base",
+      "metric_name": "errors",
+      "tags": {"status": "500"}
+    }
+  },
+  "timelines": [{"time_slices": [{"events": [
+    {"extends": "errors", "tags": {"region": "us"}, "time_between": {"dynamic": {"minimum_time": 10, "vary": 5}}},
+    {"extends": "base", "metric_name": "requests", "repeat": 20}
+  ]}]}]
+}`)
+	errorBucket := new(ValidationError)
+	resolveTemplates(tree, errorBucket)
+	if errorBucket.hasErrors() {
+		t.Logf("Unexpected errors resolving templates: %v", errorBucket.errs)
+		t.FailNow()
+	}
+	story, err := (&Config{}).newStory(tree)
+	if err != nil {
+		t.Logf("Failed to create a story. Error: %s", err)
+		t.FailNow()
+	}
+
+	errorsEvent := story.TimeLines[0].Timeslices[0].Events[0]
+	expectedTags := map[string]string{"service": "api", "region": "us", "status": "500"}
+	for key, value := range expectedTags {
+		if errorsEvent.Tags[key] != value {
+			t.Logf("Expected tag %s=%s on the errors event. Got: %v", key, value, errorsEvent.Tags)
+			t.Fail()
+		}
+	}
+	if errorsEvent.MetricName != "errors" || errorsEvent.Repeat != 10 || errorsEvent.ConnectionID != "influx1" {
+		t.Logf("The errors event did not inherit from its templates. Got: %+v", errorsEvent)
+		t.Fail()
+	}
+	if errorsEvent.TimeBetween.Static.Time != 0 || errorsEvent.TimeBetween.Dynamic.MinimumTime != 10 {
+		t.Logf("The errors event should only have the dynamic timer. Got: %+v", errorsEvent.TimeBetween)
+		t.Fail()
+	}
+
+	requestsEvent := story.TimeLines[0].Timeslices[0].Events[1]
+	if requestsEvent.MetricName != "requests" || requestsEvent.Repeat != 20 || requestsEvent.TimeBetween.Static.Time != 100 {
+		t.Logf("The requests event did not merge with its template. Got: %+v", requestsEvent)
+		t.Fail()
+	}
+	if _, ok := requestsEvent.Tags["status"]; ok {
+		t.Logf("Changes from one event leaked into the template.")
+		t.Fail()
+	}
+}
+
+func TestBadTemplates(t *testing.T) {
+	tree := decodeTestTree(t, `{
+  "templates": {
+    "a": {"extends": "b"},
+    "b": {"extends": "a"}
+  },
+  "timelines": [{"time_slices": [{"events": [
+    {"metric_name": "loop", "extends": "a"},
+    {"metric_name": "missing", "extends": "nothing"}
+  ]}]}]
+}`)
+	errorBucket := new(ValidationError)
+	resolveTemplates(tree, errorBucket)
+	errs := errorBucket.Error()
+	if !strings.Contains(errs, "template a extends itself") || !strings.Contains(errs, "missing extends unknown template nothing") {
+		t.Logf("Expected errors for the loop and the missing template. Got: %s", errs)
+		t.Fail()
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Logf("Failed to create a temp dir. Error: %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	story, err := ioutil.ReadFile("./example.json")
+	if err != nil {
+		t.Logf("Failed to read the example. Error: %s", err)
+		t.FailNow()
+	}
+	story = bytes.Replace(story, []byte(`"story_name": "example",`), []byte(`"story_name": "example", "include": ["scenarios/extra.yaml"],`), 1)
+	storyPath := filepath.Join(dir, "story.json")
+	ioutil.WriteFile(storyPath, story, 0644)
+	os.Mkdir(filepath.Join(dir, "scenarios"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "scenarios", "extra.yaml"), []byte(`
+include: ["../story.json"]
+global_tags:
+  global_tag_one: GValue1
+  environment: test
+statsd:
+  - {id: statsd3, host: localhost, port: 8125, transport: udp, buffer_depth: 10}
+timelines:
+  - timeline_name: extra
+    time_slices:
+      - time_slice_name: scene 1
+        repeat: 1
+        events:
+          - {metric_name: extra, type: statsd, connection_id: statsd3, tags: {metric_type: gauge}, fields: {value: 1}, repeat: 1, time_between: {static: {time: 10}}}
+`), 0644)
+
+	c, err := New(storyPath)
+	if err != nil {
+		t.Logf("Failed to create a config from several files. Errors %s", err)
+		t.FailNow()
+	}
+	if len(c.Story.StatsD) != 3 || len(c.Story.TimeLines) != 3 {
+		t.Logf("Expected the included connections and timelines to be merged. Got %d statsd and %d timelines", len(c.Story.StatsD), len(c.Story.TimeLines))
+		t.Fail()
+	}
+	extraEvent := c.Story.TimeLines[2].Timeslices[0].Events[0]
+	if extraEvent.Tags["environment"] != "test" || extraEvent.Tags["global_tag_two"] != "GValue2" {
+		t.Logf("Expected the global tags of both files on the included event. Got: %v", extraEvent.Tags)
+		t.Fail()
+	}
+
+	conflictPath := filepath.Join(dir, "conflict.json")
+	ioutil.WriteFile(conflictPath, []byte(`{
+  "global_tags": {"global_tag_two": "other"},
+  "influx": [{"id": "influx1", "host": "http://localhost:8086", "username": "user", "password": "password", "database": "telegraf", "precision": "ns", "batch_size": 1, "http_timeout": 1, "number_of_writers": 1, "flush_interval": 1}],
+  "timelines": [{"timeline_name": "extra", "time_slices": [{"time_slice_name": "scene", "repeat": 1, "events": [{"metric_name": "sleep", "type": "sleeper", "repeat": 1, "time_between": {"static": {"time": 10}}}]}]}]
+}`), 0644)
+	_, err = NewFromFiles([]string{storyPath, conflictPath}, Options{})
+	if err == nil {
+		t.Logf("Expected conflicts between the files to be errors.")
+		t.FailNow()
+	}
+	expected := []string{
+		"global tag global_tag_two is set to GValue2 in " + storyPath + " and other in " + conflictPath,
+		"Influx ID influx1 is duplicated. It is in " + storyPath + " and " + conflictPath,
+		"Timeline extra is duplicated. It is in " + filepath.Join(dir, "scenarios", "extra.yaml") + " and " + conflictPath,
+	}
+	for _, e := range expected {
+		if !strings.Contains(err.Error(), e) {
+			t.Logf("Expected the error %q. Got: %s", e, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestVariables(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Logf("Failed to create a temp dir. Error: %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	story := `{
+  "story_name": "{{ .environment }} load",
+  "variables": {"environment": "dev", "rate": 100, "statsd_host": "localhost"},
+  "statsd": [{"id": "statsd1", "host": "{{ .statsd_host }}", "port": 8125, "transport": "udp", "buffer_depth": 10}],
+  "timelines": [{"timeline_name": "load", "time_slices": [{"time_slice_name": "scene", "repeat": 1, "events": [
+    {"metric_name": "requests", "type": "statsd", "connection_id": "statsd1", "tags": {"metric_type": "counter", "environment": "{{ .environment }}"}, "fields": {"count": 1}, "repeat": "{{ .rate }}", "time_between": {"static": {"time": 10}}}
+  ]}]}]
+}`
+	storyPath := filepath.Join(dir, "story.json")
+	ioutil.WriteFile(storyPath, []byte(story), 0644)
+
+	c, err := New(storyPath)
+	if err != nil {
+		t.Logf("Failed to create a config with variables. Errors %s", err)
+		t.FailNow()
+	}
+	event := c.Story.TimeLines[0].Timeslices[0].Events[0]
+	if c.Story.StoryName != "dev load" || event.Repeat != 100 || event.Tags["environment"] != "dev" {
+		t.Logf("The variable defaults were not used. Got story %s and event %+v", c.Story.StoryName, event)
+		t.Fail()
+	}
+
+	c, err = NewWithOptions(storyPath, Options{Variables: map[string]string{"environment": "staging", "rate": "5"}})
+	if err != nil {
+		t.Logf("Failed to create a config with variable overrides. Errors %s", err)
+		t.FailNow()
+	}
+	event = c.Story.TimeLines[0].Timeslices[0].Events[0]
+	if c.Story.StoryName != "staging load" || event.Repeat != 5 || c.Story.StatsD[0].Host != "localhost" {
+		t.Logf("The variable overrides were not used. Got story %s, event %+v", c.Story.StoryName, event)
+		t.Fail()
+	}
+
+	_, err = NewWithOptions(storyPath, Options{Variables: map[string]string{"enviroment": "staging"}})
+	if err == nil || !strings.Contains(err.Error(), "variable enviroment is set on the command line but is not defined") {
+		t.Logf("Expected an error for an unknown variable. Got: %v", err)
+		t.Fail()
+	}
+
+	ioutil.WriteFile(storyPath, []byte(strings.Replace(story, "{{ .rate }}", "{{ .missing }}", 1)), 0644)
+	_, err = New(storyPath)
+	if err == nil || !strings.Contains(err.Error(), "variable missing is used but not defined") {
+		t.Logf("Expected an error for an undefined variable. Got: %v", err)
+		t.Fail()
+	}
+}
+
+func TestVariablesLeaveRequestTemplates(t *testing.T) {
+	tree := map[string]interface{}{"path": "/{{ .MetricName }}/{{ .Time }}", "body": "{{ .Fields }}"}
+	errorBucket := new(ValidationError)
+	newStoryVariables(nil).substitute(tree, errorBucket)
+	if errorBucket.hasErrors() || tree["path"] != "/{{ .MetricName }}/{{ .Time }}" || tree["body"] != "{{ .Fields }}" {
+		t.Logf("Request template names should be left alone. Got: %v, Errors: %v", tree, errorBucket.errs)
+		t.Fail()
+	}
+}
+
+func TestRandomTagsConfig(t *testing.T) {
+	tree := decodeTestTree(t, `{"timelines": [{"time_slices": [{"events": [
+  {"metric_name": "requests", "type": "influx", "connection_id": "influx1", "fields": {"count": 1}, "repeat": 1, "time_between": {"static": {"time": 10}},
+   "random_tags": {"status_code": {"weighted": {"200": 95, "500": 3, "404": "2"}}, "user": {"zipf": {"values": "user-{1..100}", "s": 1.2}}}},
+  {"metric_name": "broken", "type": "influx", "connection_id": "influx1", "fields": {"count": 1}, "repeat": 1, "time_between": {"static": {"time": 10}},
+   "random_tags": {"status_code": {"weighted": {"200": 1}, "zipf": {"values": ["a"], "s": 2}}}}
+]}]}]}`)
+	story, err := (&Config{}).newStory(tree)
+	if err != nil {
+		t.Logf("Failed to create a story. Error: %s", err)
+		t.FailNow()
+	}
+	events := story.TimeLines[0].Timeslices[0].Events
+	errorBucket := new(ValidationError)
+	validateEvent(*events[0], errorBucket)
+	if errorBucket.hasErrors() {
+		t.Logf("Unexpected random tag errors: %v", errorBucket.errs)
+		t.Fail()
+	}
+	if events[0].RandomTags["status_code"].Weighted["404"] != 2 {
+		t.Logf("Expected the weight from a string to be read. Got: %v", events[0].RandomTags["status_code"].Weighted)
+		t.Fail()
+	}
+	errorBucket = new(ValidationError)
+	validateEvent(*events[1], errorBucket)
+	if !strings.Contains(errorBucket.Error(), "can not have both weighted and zipf") {
+		t.Logf("Expected an error for a random tag with two distributions. Got: %v", errorBucket.errs)
+		t.Fail()
+	}
+}
+
+func TestMarkovValidation(t *testing.T) {
+	timeline := TimeLine{
+		Name:        "incidents",
+		Selection:   "markov",
+		StateMetric: &StateMetric{MetricName: "state", Type: "http"},
+		Timeslices: []*Timeslice{
+			{Name: "healthy", Transitions: map[string]float64{"degraded": 60, "outage": 50}},
+			{Name: "degraded", SingleUse: true, Transitions: map[string]float64{"missing": 10}},
+			{Name: "outage"},
+		},
+	}
+	errorBucket := new(ValidationError)
+	validateSelection(timeline, errorBucket)
+	validateStateMetric(timeline, errorBucket)
+	expected := []string{
+		"healthy transitions add up to more than 100",
+		"degraded can not be single use",
+		"transition to missing which is not in timeline incidents",
+		"state_metric type is invalid",
+	}
+	for _, e := range expected {
+		if !strings.Contains(errorBucket.Error(), e) {
+			t.Logf("Expected the error %q. Got: %v", e, errorBucket.errs)
+			t.Fail()
+		}
+	}
+
+	timeline.Selection = ""
+	timeline.Timeslices[1].Transitions = nil
+	timeline.Timeslices[0].Transitions = map[string]float64{"degraded": 10}
+	errorBucket = new(ValidationError)
+	validateSelection(timeline, errorBucket)
+	if !strings.Contains(errorBucket.Error(), "does not use markov selection") {
+		t.Logf("Expected an error for transitions in a sequential timeline. Got: %v", errorBucket.errs)
+		t.Fail()
+	}
+}
+
+func TestTimesliceDurationValidation(t *testing.T) {
+	for _, duration := range []string{"five minutes", "-5m", "0s"} {
+		errorBucket := new(ValidationError)
+		validateTimeSlice(Timeslice{Name: "bounded", Duration: duration}, errorBucket)
+		if !strings.Contains(errorBucket.Error(), "duration "+duration+" is invalid") {
+			t.Logf("Expected an error for the duration %s. Got: %v", duration, errorBucket.errs)
+			t.Fail()
+		}
+	}
+
+	ts := Timeslice{Duration: "5m"}
+	if ts.ParsedDuration() != 5*time.Minute {
+		t.Logf("Expected a duration of 5m. Got: %s", ts.ParsedDuration())
+		t.Fail()
+	}
+}
+
+func TestDynamicTimerValidation(t *testing.T) {
+	timeBetween := TimeBetween{}
+	timeBetween.Dynamic.MinimumTime = 100
+	errorBucket := new(ValidationError)
+	validateTimeBetween(timeBetween, errorBucket)
+	if !strings.Contains(errorBucket.Error(), "event dynamic timer must have a vary.") {
+		t.Logf("Expected an error for a dynamic timer without a vary. Got: %v", errorBucket.errs)
+		t.Fail()
+	}
+	timeBetween.Dynamic.Vary = 50
+	errorBucket = new(ValidationError)
+	validateTimeBetween(timeBetween, errorBucket)
+	if errorBucket.hasErrors() {
+		t.Logf("Expected a valid dynamic timer. Got: %v", errorBucket.errs)
+		t.Fail()
+	}
+}
+
+func TestTimesliceScheduleValidation(t *testing.T) {
+	tests := map[string]Timeslice{
+		"can have a schedule or at but not both": {Name: "batch", Schedule: "0 0 2 * * *", At: "02:00"},
+		"must have 6 fields":                     {Name: "batch", Schedule: "0 2 *"},
+		"must be a time of day":                  {Name: "batch", At: "2am"},
+	}
+	for expected, ts := range tests {
+		errorBucket := new(ValidationError)
+		validateTimeSlice(ts, errorBucket)
+		if !strings.Contains(errorBucket.Error(), expected) {
+			t.Logf("Expected the error %q. Got: %v", expected, errorBucket.errs)
+			t.Fail()
+		}
+	}
+
+	ts := Timeslice{Schedule: "0 */15 * * * *"}
+	if schedule, err := ts.ParsedSchedule(); err != nil || schedule == nil {
+		t.Logf("Expected a schedule. Error: %v", err)
+		t.Fail()
+	}
+}
+
+func TestSignalValidation(t *testing.T) {
+	story := Story{
+		TimeLines: []*TimeLine{
+			{
+				Name:       "database",
+				StartAfter: "soon",
+				Timeslices: []*Timeslice{
+					{Name: "outage", Signals: []string{"db_outage_started"}},
+					{Name: "recovery", WaitFor: "recovery", Signals: []string{"recovery"}},
+				},
+			},
+			{
+				Name: "api",
+				Timeslices: []*Timeslice{
+					{Name: "latency", WaitFor: "db_outage_started"},
+					{Name: "errors", WaitFor: "db_outage_ended"},
+				},
+			},
+		},
+	}
+	errorBucket := new(ValidationError)
+	validateSignals(story, errorBucket)
+	validateTimeLine(*story.TimeLines[0], errorBucket)
+	expected := []string{
+		"recovery waits for signal recovery which only it signals",
+		"errors waits for signal db_outage_ended which no time slice signals",
+		"start_after soon is invalid",
+	}
+	for _, e := range expected {
+		if !strings.Contains(errorBucket.Error(), e) {
+			t.Logf("Expected the error %q. Got: %v", e, errorBucket.errs)
+			t.Fail()
+		}
+	}
+	if strings.Contains(errorBucket.Error(), "latency waits") {
+		t.Logf("Did not expect an error for a signal that is sent. Got: %v", errorBucket.errs)
+		t.Fail()
+	}
+}
+
+func TestTimelineLoops(t *testing.T) {
+	tests := []struct {
+		loop       interface{}
+		continuous bool
+		expected   int
+	}{
+		{nil, false, 1},
+		{nil, true, 0},
+		{float64(3), false, 3},
+		{"4", true, 4},
+		{LoopForever, false, 0},
+	}
+	for _, test := range tests {
+		tl := TimeLine{Loop: test.loop}
+		loops, err := tl.Loops(test.continuous)
+		if err != nil || loops != test.expected {
+			t.Logf("Expected loop %v to give %d. Got: %d, Error: %v", test.loop, test.expected, loops, err)
+			t.Fail()
+		}
+	}
+	for _, loop := range []interface{}{float64(0), float64(1.5), "sometimes", true} {
+		tl := TimeLine{Name: "bad", Loop: loop}
+		errorBucket := new(ValidationError)
+		validateTimeLine(tl, errorBucket)
+		if !strings.Contains(errorBucket.Error(), "must be a positive number or forever") {
+			t.Logf("Expected an error for loop %v. Got: %v", loop, errorBucket.errs)
+			t.Fail()
+		}
+	}
+}
+
+func TestStoryDurationValidation(t *testing.T) {
+	errorBucket := new(ValidationError)
+	validateStory(Story{StoryName: "limits", MaxDuration: "forever", DrainTimeout: "-1s"}, errorBucket)
+	for _, expected := range []string{"max_duration forever is invalid", "drain_timeout -1s is invalid"} {
+		if !strings.Contains(errorBucket.Error(), expected) {
+			t.Logf("Expected the error %q. Got: %v", expected, errorBucket.errs)
+			t.Fail()
+		}
+	}
+
+	story := Story{MaxDuration: "30m", DrainTimeout: "10s"}
+	if story.ParsedMaxDuration() != 30*time.Minute || story.ParsedDrainTimeout() != 10*time.Second {
+		t.Logf("Expected 30m and 10s. Got: %s and %s", story.ParsedMaxDuration(), story.ParsedDrainTimeout())
+		t.Fail()
+	}
+}
+
+func TestDerivedFieldsConfig(t *testing.T) {
+	tree := decodeTestTree(t, `{"timelines": [{"time_slices": [{"events": [
+  {"metric_name": "requests", "type": "influx", "connection_id": "influx1", "fields": {"requests": 100, "error_rate": 0.1}, "repeat": 1, "time_between": {"static": {"time": 10}},
+   "derived_fields": {"errors": "requests * error_rate"}},
+  {"metric_name": "broken", "type": "influx", "connection_id": "influx1", "fields": {"requests": 100}, "repeat": 1, "time_between": {"static": {"time": 10}},
+   "derived_fields": {"errors": "requests * error_rate"}},
+  {"metric_name": "request", "type": "http", "connection_id": "http1", "fields": {"requests": 100}, "repeat": 1, "time_between": {"static": {"time": 10}},
+   "derived_fields": {"errors": "requests / 10"}}
+]}]}]}`)
+	story, err := (&Config{}).newStory(tree)
+	if err != nil {
+		t.Logf("Failed to create a story. Error: %s", err)
+		t.FailNow()
+	}
+	events := story.TimeLines[0].Timeslices[0].Events
+	errorBucket := new(ValidationError)
+	validateEvent(*events[0], errorBucket)
+	if errorBucket.hasErrors() || !events[0].Rendered() {
+		t.Logf("Expected a valid rendered event. Got: %v", errorBucket.errs)
+		t.Fail()
+	}
+	expected := []string{
+		"derived field errors uses error_rate which is not a field",
+		"event request can not use derived_fields",
+	}
+	errorBucket = new(ValidationError)
+	validateEvent(*events[1], errorBucket)
+	validateEvent(*events[2], errorBucket)
+	for _, e := range expected {
+		if !strings.Contains(errorBucket.Error(), e) {
+			t.Logf("Expected the error %q. Got: %v", e, errorBucket.errs)
+			t.Fail()
+		}
+	}
+}
+
+func TestExpressionFieldsConfig(t *testing.T) {
+	tree := decodeTestTree(t, `{"timelines": [{"time_slices": [{"time_slice_name": "busy", "events": [
+  {"metric_name": "load", "type": "influx", "connection_id": "influx1", "fields": {"base": 100, "value": "=base + 20*sin(t/60)"}, "repeat": 1, "time_between": {"static": {"time": 10}}},
+  {"metric_name": "clash", "type": "influx", "connection_id": "influx1", "fields": {"value": "=1"}, "repeat": 1, "time_between": {"static": {"time": 10}},
+   "derived_fields": {"value": "2"}},
+  {"metric_name": "broken", "type": "influx", "connection_id": "influx1", "fields": {"value": "=base * 2"}, "repeat": 1, "time_between": {"static": {"time": 10}}}
+]}]}]}`)
+	c := &Config{}
+	story, err := c.newStory(tree)
+	if err != nil {
+		t.Logf("Failed to create a story. Error: %s", err)
+		t.FailNow()
+	}
+	story.setEventContext()
+	events := story.TimeLines[0].Timeslices[0].Events
+	errorBucket := new(ValidationError)
+	validateEvent(*events[0], errorBucket)
+	if errorBucket.hasErrors() || !events[0].Rendered() || events[0].timeslice != "busy" {
+		t.Logf("Expected a valid rendered event in the busy time slice. Got: %v", errorBucket.errs)
+		t.Fail()
+	}
+	template, err := events[0].MetricTemplate()
+	if err != nil {
+		t.Logf("Failed to create the metric template. Error: %s", err)
+		t.FailNow()
+	}
+	metric, err := template.Render()
+	if err != nil || !strings.Contains(metric.Influx(), "value=100") {
+		t.Logf("Expected value=100 at the start. Got: %v, Error: %v", metric, err)
+		t.Fail()
+	}
+
+	expected := []string{
+		"derived field value is also a field",
+		"derived field value uses base which is not a field",
+	}
+	errorBucket = new(ValidationError)
+	validateEvent(*events[1], errorBucket)
+	validateEvent(*events[2], errorBucket)
+	for _, e := range expected {
+		if !strings.Contains(errorBucket.Error(), e) {
+			t.Logf("Expected the error %q. Got: %v", e, errorBucket.errs)
+			t.Fail()
+		}
+	}
+}
+
+func TestCSVFieldsConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Logf("Failed to create a temp dir. Error: %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	story, err := ioutil.ReadFile("./example.json")
+	if err != nil {
+		t.Logf("Failed to read the example. Error: %s", err)
+		t.FailNow()
+	}
+	story = bytes.Replace(story, []byte(`"counter": 1`), []byte(`"counter": {"csv": "traces/cpu.csv", "column": "value", "loop": true}, "double": "=counter * 2"`), 1)
+	storyPath := filepath.Join(dir, "story.json")
+	ioutil.WriteFile(storyPath, story, 0644)
+	os.Mkdir(filepath.Join(dir, "traces"), 0755)
+	csvPath := filepath.Join(dir, "traces", "cpu.csv")
+	ioutil.WriteFile(csvPath, []byte("value\n5\n7\n"), 0644)
+
+	c, err := New(storyPath)
+	if err != nil {
+		t.Logf("Failed to create a config. Errors %s", err)
+		t.FailNow()
+	}
+	event := c.Story.TimeLines[0].Timeslices[0].Events[1]
+	if !event.Rendered() || event.Fields["counter"].(map[string]interface{})["csv"] != csvPath {
+		t.Logf("Expected a rendered event reading %s. Got: %v", csvPath, event.Fields)
+		t.FailNow()
+	}
+	template, err := event.MetricTemplate()
+	if err != nil {
+		t.Logf("Failed to create the metric template. Error: %s", err)
+		t.FailNow()
+	}
+	for _, expected := range []string{"counter=5", "counter=7", "counter=5"} {
+		metric, err := template.Render()
+		if err != nil || !strings.Contains(metric.Influx(), expected) {
+			t.Logf("Expected %s. Got: %v, Error: %v", expected, metric, err)
+			t.Fail()
+		}
+	}
+
+	os.Remove(csvPath)
+	_, err = New(storyPath)
+	if err == nil || !strings.Contains(err.Error(), "field counter is invalid") {
+		t.Logf("Expected an error about the missing CSV file. Got: %v", err)
+		t.Fail()
+	}
+}
+
+func TestAnomaliesConfig(t *testing.T) {
+	tree := decodeTestTree(t, `{"timelines": [{"time_slices": [{"time_slice_name": "incident", "events": [
+  {"metric_name": "api", "type": "influx", "connection_id": "influx1", "fields": {"latency": 100}, "repeat": 4, "time_between": {"static": {"time": 10}}},
+  {"metric_name": "web", "type": "statsd", "connection_id": "statsd1", "tags": {"metric_type": "gauge"}, "fields": {"value": 1}, "repeat": 4, "time_between": {"static": {"time": 10}}},
+  {"metric_name": "rest", "type": "sleeper", "repeat": 1, "time_between": {"static": {"time": 10}}}
+], "anomalies": [
+  {"type": "spike", "events": ["api"], "fields": ["latency"], "factor": 5, "start_fire": 1, "fires": 2},
+  {"type": "gap", "start_fire": 3}
+]}]}]}`)
+	c := &Config{}
+	story, err := c.newStory(tree)
+	if err != nil {
+		t.Logf("Failed to create a story. Error: %s", err)
+		t.FailNow()
+	}
+	timeslice := story.TimeLines[0].Timeslices[0]
+	errorBucket := new(ValidationError)
+	validateTimeSlice(*timeslice, errorBucket)
+	if errorBucket.hasErrors() {
+		t.Logf("Expected the anomalies to be valid. Got: %v", errorBucket.errs)
+		t.Fail()
+	}
+	story.setEventContext()
+	api, web, rest := timeslice.Events[0], timeslice.Events[1], timeslice.Events[2]
+	if len(api.anomalies) != 2 || len(web.anomalies) != 1 || len(rest.anomalies) != 0 || !api.Rendered() {
+		t.Logf("Expected 2 anomalies on api, 1 on web and none on the sleeper. Got: %d, %d and %d", len(api.anomalies), len(web.anomalies), len(rest.anomalies))
+		t.FailNow()
+	}
+	template, err := api.MetricTemplate()
+	if err != nil {
+		t.Logf("Failed to create the metric template. Error: %s", err)
+		t.FailNow()
+	}
+	for i, expected := range []float64{100, 500, 500, -1, 100} {
+		metric, err := template.Render()
+		if expected < 0 {
+			if err != metricCreator.ErrGap {
+				t.Logf("Fire %d should be a gap. Got: %v, Error: %v", i, metric, err)
+				t.Fail()
+			}
+			continue
+		}
+		if metric == nil || metric.Fields()["latency"] != expected {
+			t.Logf("Fire %d expected latency %v. Got: %v", i, expected, metric)
+			t.Fail()
+		}
+	}
+
+	timeslice.Anomalies = []*Anomaly{
+		{Type: "wobble"},
+		{Type: "step", Events: []string{"missing"}},
+		{Type: "step", Fields: []string{"errors"}},
+		{Type: "gap", Events: []string{"rest"}},
+	}
+	expected := []string{
+		"anomaly type wobble is not valid",
+		"step anomaly is for event missing which is not in the time slice",
+		"step anomaly changes field errors which event api does not have",
+		"event rest can not use anomalies",
+	}
+	errorBucket = new(ValidationError)
+	validateTimeSlice(*timeslice, errorBucket)
+	for _, e := range expected {
+		if !strings.Contains(errorBucket.Error(), e) {
+			t.Logf("Expected the error %q. Got: %v", e, errorBucket.errs)
+			t.Fail()
+		}
+	}
+}
+
+func TestDiurnalProfilesConfig(t *testing.T) {
+	hourly := "[1,1,1,1,1,1,2,3,4,4,4,4,4,4,4,4,4,4,3,2,2,1,1,1]"
+	tree := decodeTestTree(t, `{"diurnal_profiles": {"web": {"hourly": `+hourly+`, "weekly": [1,1,1,1,1,0.5,0.5], "timezone": "UTC"},
+  "short": {"hourly": [1, 2]}, "lost": {"hourly": `+hourly+`, "timezone": "Nowhere/Special"}},
+ "timelines": [{"time_slices": [{"time_slice_name": "day", "events": [
+  {"metric_name": "requests", "type": "influx", "connection_id": "influx1", "fields": {"count": {"diurnal": "web", "scale": 100}}, "repeat": 1, "time_between": {"static": {"time": 10}, "diurnal": "web"}},
+  {"metric_name": "missing", "type": "influx", "connection_id": "influx1", "fields": {"count": {"diurnal": "nope"}}, "repeat": 1, "time_between": {"static": {"time": 10}, "diurnal": "nope"}}
+]}]}]}`)
+	story, err := (&Config{}).newStory(tree)
+	if err != nil {
+		t.Logf("Failed to create a story. Error: %s", err)
+		t.FailNow()
+	}
+	story.setEventContext()
+	events := story.TimeLines[0].Timeslices[0].Events
+	errorBucket := new(ValidationError)
+	validateEvent(*events[0], errorBucket)
+	if errorBucket.hasErrors() || !events[0].Rendered() {
+		t.Logf("Expected a valid rendered event. Got: %v", errorBucket.errs)
+		t.Fail()
+	}
+	rate, err := events[0].RateProfile()
+	if err != nil || rate.Factor(time.Date(2023, 1, 2, 12, 0, 0, 0, time.UTC)) != 4 {
+		t.Logf("Expected the rate to follow the web profile. Error: %v", err)
+		t.Fail()
+	}
+	template, err := events[0].MetricTemplate()
+	if err != nil {
+		t.Logf("Failed to create the metric template. Error: %s", err)
+		t.FailNow()
+	}
+	template.SetClock(func() time.Time { return time.Date(2023, 1, 7, 12, 0, 0, 0, time.UTC) })
+	if metric, err := template.Render(); err != nil || metric.Fields()["count"] != 200.0 {
+		t.Logf("Expected a count of 200 on a Saturday at noon. Got: %v, Error: %v", metric, err)
+		t.Fail()
+	}
+
+	expected := []string{
+		"diurnal profile short is invalid. hourly needs 24 values",
+		"diurnal profile lost is invalid. timezone Nowhere/Special is not valid",
+		"rate follows diurnal profile nope which does not exist",
+		"field count is invalid. Error diurnal profile nope does not exist",
+	}
+	errorBucket = new(ValidationError)
+	validateStory(*story, errorBucket)
+	for _, e := range expected {
+		if !strings.Contains(errorBucket.Error(), e) {
+			t.Logf("Expected the error %q. Got: %v", e, errorBucket.errs)
+			t.Fail()
+		}
+	}
+}
+
+func TestDeliveryConfig(t *testing.T) {
+	tree := decodeTestTree(t, `{"timelines": [{"time_slices": [{"time_slice_name": "flaky", "events": [
+  {"metric_name": "good", "type": "influx", "connection_id": "influx1", "fields": {"value": 1}, "repeat": 1, "time_between": {"static": {"time": 10}},
+   "delivery": {"drop": 0.1, "duplicate": 0.05, "delay": {"probability": 0.2, "seconds": 30}, "backdate": {"probability": 0.1, "seconds": 300}}},
+  {"metric_name": "bad", "type": "statsd", "connection_id": "statsd1", "tags": {"metric_type": "gauge"}, "fields": {"value": 1}, "repeat": 1, "time_between": {"static": {"time": 10}},
+   "delivery": {"drop": 2, "delay": {"probability": 0.5}, "backdate": {"probability": -1, "seconds": 10}}}
+]}]}]}`)
+	story, err := (&Config{}).newStory(tree)
+	if err != nil {
+		t.Logf("Failed to create a story. Error: %s", err)
+		t.FailNow()
+	}
+	events := story.TimeLines[0].Timeslices[0].Events
+	errorBucket := new(ValidationError)
+	validateEvent(*events[0], errorBucket)
+	if errorBucket.hasErrors() {
+		t.Logf("Expected a valid delivery. Got: %v", errorBucket.errs)
+		t.Fail()
+	}
+	if events[0].Delivery.Backdate.Duration() != 5*time.Minute {
+		t.Logf("Expected a backdate of 5m. Got: %s", events[0].Delivery.Backdate.Duration())
+		t.Fail()
+	}
+
+	expected := []string{
+		"event bad delivery drop must be from 0 to 1.",
+		"event bad delivery delay seconds must be more than 0.",
+		"event bad delivery backdate probability must be from 0 to 1.",
+		"event bad can not backdate points. Only influx points have times.",
+	}
+	errorBucket = new(ValidationError)
+	validateEvent(*events[1], errorBucket)
+	for _, e := range expected {
+		if !strings.Contains(errorBucket.Error(), e) {
+			t.Logf("Expected the error %q. Got: %v", e, errorBucket.errs)
+			t.Fail()
+		}
+	}
+}
+
+func TestEventLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Logf("Failed to create a temp dir. Error: %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	// Each story is valid apart from how an event links to its connection.
+	tests := map[string]string{
+		"event disk has an bad id logs": `{
+  "story_name": "links",
+  "file": [{"id": "log", "path": "` + filepath.Join(dir, "out.log") + `", "format": "influx"}],
+  "timelines": [{"timeline_name": "load", "time_slices": [{"time_slice_name": "scene", "repeat": 1, "events": [
+    {"metric_name": "disk", "type": "file", "connection_id": "logs", "fields": {"used": 1}, "repeat": 1, "time_between": {"static": {"time": 10}}}
+  ]}]}]
+}`,
+		"event orders has an bad id shop": `{
+  "story_name": "links",
+  "http": [{"id": "api", "host": "http://localhost:8080", "http_timeout": 10}],
+  "timelines": [{"timeline_name": "load", "time_slices": [{"time_slice_name": "scene", "repeat": 1, "events": [
+    {"metric_name": "orders", "type": "http", "connection_id": "shop", "http_request": {"method": "POST", "path": "/orders"}, "fields": {"count": 1}, "repeat": 1, "time_between": {"static": {"time": 10}}}
+  ]}]}]
+}`,
+		"event deploy has an bad grafana id dashboards": `{
+  "story_name": "links",
+  "grafana": [{"id": "grafana", "host": "http://localhost:3000", "http_timeout": 10}],
+  "timelines": [{"timeline_name": "load", "time_slices": [{"time_slice_name": "scene", "repeat": 1, "events": [
+    {"metric_name": "deploy", "type": "annotation", "annotation": {"title": "Deploy", "grafana_connection_id": "dashboards"}, "repeat": 1, "time_between": {"static": {"time": 10}}}
+  ]}]}]
+}`,
+		"event hits writes statsd to a file and must have a tag metric_type.": `{
+  "story_name": "links",
+  "file": [{"id": "log", "path": "` + filepath.Join(dir, "out.log") + `", "format": "statsd"}],
+  "timelines": [{"timeline_name": "load", "time_slices": [{"time_slice_name": "scene", "repeat": 1, "events": [
+    {"metric_name": "hits", "type": "file", "connection_id": "log", "fields": {"count": 1}, "repeat": 1, "time_between": {"static": {"time": 10}}}
+  ]}]}]
+}`,
+	}
+	for expected, story := range tests {
+		storyPath := filepath.Join(dir, "story.json")
+		ioutil.WriteFile(storyPath, []byte(story), 0644)
+		_, err := New(storyPath)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Logf("Expected the error %q. Got: %v", expected, err)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/silverstagtech/teller/metricCreator"
+)
+
+// CSVField is a field value that comes from a column of a CSV file, written in the fields
+// of an event as {"csv": "cpu.csv", "column": "value", "loop": true}. CSV is relative to the
+// configuration file it is in. TimestampColumn is optional and replays the rows at the times
+// they were recorded rather than one row per fire.
+type CSVField struct {
+	CSV             string `json:"csv"`
+	Column          string `json:"column"`
+	TimestampColumn string `json:"timestamp_column"`
+	Loop            bool   `json:"loop"`
+}
+
+// csvField returns the CSVField that a field value describes. ok is false if the value
+// is not a CSV field.
+func csvField(value interface{}) (field *CSVField, ok bool, err error) {
+	m, isMap := value.(map[string]interface{})
+	if !isMap {
+		return nil, false, nil
+	}
+	if _, ok := m["csv"]; !ok {
+		return nil, false, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, true, err
+	}
+	field = new(CSVField)
+	if err := json.Unmarshal(b, field); err != nil {
+		return nil, true, err
+	}
+	if field.CSV == "" || field.Column == "" {
+		return nil, true, fmt.Errorf("csv and column must be set")
+	}
+	return field, true, nil
+}
+
+// Series reads the CSV file.
+func (cf *CSVField) Series() (*metricCreator.CSVSeries, error) {
+	return metricCreator.NewCSVSeries(cf.CSV, cf.Column, cf.TimestampColumn, cf.Loop)
+}
+
+// setCSVSource makes the paths of CSV fields relative to dir.
+func (e *Event) setCSVSource(dir string) {
+	for _, value := range e.Fields {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if path, ok := m["csv"].(string); ok && path != "" && !filepath.IsAbs(path) {
+			m["csv"] = filepath.Join(dir, path)
+		}
+	}
+}
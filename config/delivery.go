@@ -0,0 +1,28 @@
+package config
+
+import (
+	"time"
+)
+
+// Delivery makes an event send imperfect data to test how pipelines and dashboards cope
+// with it. Drop and Duplicate are the chance, from 0 to 1, of each point being lost or
+// sent twice. Delay sends points late with the time they were made and Backdate sends them
+// straight away with a time in the past.
+type Delivery struct {
+	Drop      float64        `json:"drop"`
+	Duplicate float64        `json:"duplicate"`
+	Delay     *DeliveryShift `json:"delay"`
+	Backdate  *DeliveryShift `json:"backdate"`
+}
+
+// DeliveryShift is the chance, from 0 to 1, of a point being moved in time and how many
+// seconds it is moved by.
+type DeliveryShift struct {
+	Probability float64 `json:"probability"`
+	Seconds     float64 `json:"seconds"`
+}
+
+// Duration returns how far the point is moved.
+func (ds *DeliveryShift) Duration() time.Duration {
+	return time.Duration(ds.Seconds * float64(time.Second))
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/silverstagtech/teller/metricCreator"
+)
+
+// DiurnalProfile is a daily and weekly curve, named in the diurnal_profiles of the story,
+// that shapes field values and event rates. Hourly has 24 values, one for the start of each
+// hour from midnight. Weekly is optional and multiplies them for each day from Monday.
+// Timezone is an IANA name like "Europe/London" and defaults to the local time zone.
+type DiurnalProfile struct {
+	Hourly   []float64 `json:"hourly"`
+	Weekly   []float64 `json:"weekly"`
+	Timezone string    `json:"timezone"`
+}
+
+// Profile returns the metricCreator.DiurnalProfile that the profile describes.
+func (dp *DiurnalProfile) Profile() (*metricCreator.DiurnalProfile, error) {
+	var location *time.Location
+	if dp.Timezone != "" {
+		var err error
+		location, err = time.LoadLocation(dp.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("timezone %s is not valid", dp.Timezone)
+		}
+	}
+	return metricCreator.NewDiurnalProfile(dp.Hourly, dp.Weekly, location)
+}
+
+// DiurnalField is a field value that follows a diurnal profile, written in the fields of
+// an event as {"diurnal": "web", "scale": 1000}. Scale multiplies the profile and
+// defaults to 1.
+type DiurnalField struct {
+	Diurnal string  `json:"diurnal"`
+	Scale   float64 `json:"scale"`
+}
+
+// diurnalField returns the DiurnalField that a field value describes. ok is false if the
+// value is not a diurnal field.
+func diurnalField(value interface{}) (field *DiurnalField, ok bool, err error) {
+	m, isMap := value.(map[string]interface{})
+	if !isMap {
+		return nil, false, nil
+	}
+	if _, ok := m["diurnal"]; !ok {
+		return nil, false, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, true, err
+	}
+	field = new(DiurnalField)
+	if err := json.Unmarshal(b, field); err != nil {
+		return nil, true, err
+	}
+	if field.Scale == 0 {
+		field.Scale = 1
+	}
+	return field, true, nil
+}
+
+// diurnalProfile returns the profile of the story that an event uses by name.
+func (e *Event) diurnalProfile(name string) (*metricCreator.DiurnalProfile, error) {
+	profile, ok := e.profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("diurnal profile %s does not exist", name)
+	}
+	p, err := profile.Profile()
+	if err != nil {
+		return nil, fmt.Errorf("diurnal profile %s is invalid. Error %s", name, err)
+	}
+	return p, nil
+}
+
+// RateProfile returns the diurnal profile that the rate of the event follows, or nil if
+// it has none.
+func (e *Event) RateProfile() (*metricCreator.DiurnalProfile, error) {
+	if e.TimeBetween.Diurnal == "" {
+		return nil, nil
+	}
+	return e.diurnalProfile(e.TimeBetween.Diurnal)
+}
+
+func sortedProfileNames(profiles map[string]*DiurnalProfile) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
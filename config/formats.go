@@ -0,0 +1,187 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// FormatJSON is used for JSON configuration files.
+	FormatJSON = "json"
+	// FormatYAML is used for YAML configuration files.
+	FormatYAML = "yaml"
+	// FormatTOML is used for TOML configuration files.
+	FormatTOML = "toml"
+)
+
+var (
+	validFormats = []string{FormatJSON, FormatYAML, FormatTOML}
+)
+
+// FormatFromPath works out the format of a configuration file from its extension.
+// Files that are not .yaml, .yml or .toml are expected to be JSON.
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatJSON
+	}
+}
+
+func validateFormat(format string) error {
+	for _, valid := range validFormats {
+		if format == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("format %s is not valid. Only %s are valid", format, strings.Join(validFormats, ","))
+}
+
+// decode reads a configuration file in the format given into a tree of maps, lists and values.
+// Every format is decoded into the same shape so that the JSON tags on the story can be used.
+func decode(data []byte, format string) (map[string]interface{}, error) {
+	if err := validateFormat(format); err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	switch format {
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &tree); err != nil {
+			return nil, err
+		}
+	case FormatTOML:
+		m := make(map[string]interface{})
+		if _, err := toml.Decode(string(data), &m); err != nil {
+			return nil, err
+		}
+		tree = m
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&tree); err != nil {
+			return nil, err
+		}
+	}
+	normalised, ok := normalise(tree).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the configuration must be an object at the top level")
+	}
+	return normalised, nil
+}
+
+// normalise turns the maps that YAML creates into maps with string keys and drops
+// nulls, which TOML can not represent.
+func normalise(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if item == nil {
+				continue
+			}
+			m[fmt.Sprintf("%v", key)] = normalise(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if item == nil {
+				continue
+			}
+			m[key] = normalise(item)
+		}
+		return m
+	case []map[string]interface{}:
+		l := make([]interface{}, len(v))
+		for index, item := range v {
+			l[index] = normalise(item)
+		}
+		return l
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for index, item := range v {
+			l[index] = normalise(item)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// encode writes a tree of maps, lists and values in the format given.
+func encode(tree map[string]interface{}, format string) ([]byte, error) {
+	if err := validateFormat(format); err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatYAML:
+		return yaml.Marshal(tree)
+	case FormatTOML:
+		buf := &bytes.Buffer{}
+		if err := toml.NewEncoder(buf).Encode(tomlNumbers(tree)); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		b, err := json.MarshalIndent(tree, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	}
+}
+
+// tomlNumbers turns JSON numbers into ints or floats as the TOML encoder does
+// not know what to do with them.
+func tomlNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = tomlNumbers(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for index, item := range v {
+			l[index] = tomlNumbers(item)
+		}
+		return l
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	default:
+		return v
+	}
+}
+
+// Convert reads the configuration file at path in the format given and returns it
+// written in the output format. The file is converted as it is written, so environment
+// variables, secret files, variables and includes are left as references and the story
+// does not need to be valid. An empty inputFormat will use the extension of the file.
+func Convert(path, inputFormat, outputFormat string) ([]byte, error) {
+	if inputFormat == "" {
+		inputFormat = FormatFromPath(path)
+	}
+	file, err := (&Config{}).readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := decode(file, inputFormat)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s configuration %s. Error %s", inputFormat, path, err)
+	}
+	return encode(tree, outputFormat)
+}
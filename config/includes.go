@@ -0,0 +1,205 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	includeKey = "include"
+)
+
+// storyFile is one of the configuration files that make up a story.
+type storyFile struct {
+	path     string
+	tree     map[string]interface{}
+	resolved map[string]interface{}
+}
+
+// loadFiles reads the configuration files given and every file that they include.
+// Included files come straight after the file that includes them. A file is only read
+// once even if it is included more than once. The variables of each file are collected
+// as it is read so that later include paths can use them.
+func (cf *Config) loadFiles(paths []string, errorBucket *ValidationError) ([]*storyFile, error) {
+	loaded := make(map[string]bool)
+	files := []*storyFile{}
+	for _, path := range paths {
+		format := cf.format
+		if format == "" {
+			format = FormatFromPath(path)
+		}
+		if err := cf.loadFile(path, format, loaded, &files, errorBucket); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func (cf *Config) loadFile(path, format string, loaded map[string]bool, files *[]*storyFile, errorBucket *ValidationError) error {
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("Failed to find configuration file %s. Error %s", path, err)
+	}
+	if loaded[absolutePath] {
+		return nil
+	}
+	loaded[absolutePath] = true
+
+	file, err := cf.readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	sf := &storyFile{path: path}
+	sf.tree, err = decode(file, format)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s configuration %s. Error %s", format, path, err)
+	}
+	sf.resolved, err = cf.resolve(sf.tree, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	*files = append(*files, sf)
+	cf.variables.collect(sf.resolved, path, errorBucket)
+
+	includes, err := includedPaths(sf.resolved)
+	if err != nil {
+		return fmt.Errorf("%s in %s", err, path)
+	}
+	for _, include := range includes {
+		missing := make(map[string]bool)
+		include = variableString(cf.variables.substituteString(include, missing))
+		if len(missing) > 0 {
+			return fmt.Errorf("include %s in %s uses a variable that is not defined", include, path)
+		}
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		if err := cf.loadFile(include, FormatFromPath(include), loaded, files, errorBucket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// includedPaths returns the list of files a configuration includes.
+func includedPaths(tree map[string]interface{}) ([]string, error) {
+	value, ok := tree[includeKey]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("include must be a list of files")
+	}
+	paths := make([]string, 0, len(list))
+	for _, item := range list {
+		path, ok := item.(string)
+		if !ok || path == "" {
+			return nil, fmt.Errorf("include must be a list of files")
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// composeStory creates a story from each of the files and merges them into one.
+// Variables are filled in first. Templates are shared between all the files so that an
+// event can extend a template that is defined in another file, as are diurnal profiles
+// which must have different names in each file. Global tags must not be set to different
+// values in different files. Duplicate connections and timelines are found when the
+// story is validated.
+// Conflicts are added to the errorBucket.
+func (cf *Config) composeStory(files []*storyFile, errorBucket *ValidationError) (*Story, error) {
+	cf.variables.checkOverrides(errorBucket)
+	for _, sf := range files {
+		cf.variables.substitute(sf.resolved, errorBucket)
+	}
+
+	templates := make(map[string]interface{})
+	templateSources := make(map[string]string)
+	for _, sf := range files {
+		fileTemplates, _ := sf.resolved[templatesKey].(map[string]interface{})
+		for name, template := range fileTemplates {
+			if source, ok := templateSources[name]; ok {
+				errorBucket.add(fmt.Sprintf("template %s is defined in %s and %s.", name, source, sf.path))
+				continue
+			}
+			templates[name] = template
+			templateSources[name] = sf.path
+		}
+	}
+
+	for _, sf := range files {
+		sf.resolved[templatesKey] = copyTree(templates)
+		resolveTemplates(sf.resolved, errorBucket)
+	}
+	// The files can't be turned into stories if the values are not filled in.
+	if errorBucket.hasErrors() {
+		return nil, errorBucket
+	}
+
+	var story *Story
+	globalTagSources := make(map[string]string)
+	profileSources := make(map[string]string)
+	for _, sf := range files {
+		fileStory, err := cf.newStory(sf.resolved)
+		if err != nil {
+			return nil, fmt.Errorf("%s in %s", err, sf.path)
+		}
+		fileStory.setSource(sf.path)
+
+		if story == nil {
+			story = fileStory
+			for tag := range story.GlobalTags {
+				globalTagSources[tag] = sf.path
+			}
+			for name := range story.DiurnalProfiles {
+				profileSources[name] = sf.path
+			}
+			continue
+		}
+		for _, tag := range sortedTagKeys(fileStory.GlobalTags) {
+			value := fileStory.GlobalTags[tag]
+			if existing, ok := story.GlobalTags[tag]; ok {
+				if existing != value {
+					errorBucket.add(fmt.Sprintf("global tag %s is set to %s in %s and %s in %s.", tag, existing, globalTagSources[tag], value, sf.path))
+				}
+				continue
+			}
+			if story.GlobalTags == nil {
+				story.GlobalTags = make(map[string]string)
+			}
+			story.GlobalTags[tag] = value
+			globalTagSources[tag] = sf.path
+		}
+		for _, name := range sortedProfileNames(fileStory.DiurnalProfiles) {
+			if source, ok := profileSources[name]; ok {
+				errorBucket.add(fmt.Sprintf("diurnal profile %s is defined in %s and %s.", name, source, sf.path))
+				continue
+			}
+			if story.DiurnalProfiles == nil {
+				story.DiurnalProfiles = make(map[string]*DiurnalProfile)
+			}
+			story.DiurnalProfiles[name] = fileStory.DiurnalProfiles[name]
+			profileSources[name] = sf.path
+		}
+		story.Influx = append(story.Influx, fileStory.Influx...)
+		story.StatsD = append(story.StatsD, fileStory.StatsD...)
+		story.Grafana = append(story.Grafana, fileStory.Grafana...)
+		story.HTTP = append(story.HTTP, fileStory.HTTP...)
+		story.File = append(story.File, fileStory.File...)
+		story.TimeLines = append(story.TimeLines, fileStory.TimeLines...)
+	}
+	story.Variables = cf.variables.values()
+	return story, nil
+}
+
+func sortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
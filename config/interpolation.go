@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	secretFileSuffix = "_file"
+)
+
+var (
+	// secretSections are the connection sections that can have credentials in files.
+	secretSections = []string{"influx", "statsd", "grafana", "http"}
+	// secretKeys are the credentials that can be given in files with the _file suffix.
+	secretKeys = []string{"password", "username", "api_key", "bearer_token"}
+	// envReference matches $$ or ${NAME} or ${NAME:-default}.
+	envReference = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+)
+
+// copyTree returns a deep copy of a decoded configuration so that it can be changed
+// without changing the original.
+func copyTree(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = copyTree(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for index, item := range v {
+			l[index] = copyTree(item)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// interpolateEnv replaces ${NAME} and ${NAME:-default} in every string with the value of
+// the environment variable. The default is used if the variable is not set or is empty.
+// $$ is replaced with a single $. Variables that are not set and have no default are
+// added to the errorBucket.
+func interpolateEnv(value interface{}, errorBucket *ValidationError) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = interpolateEnv(item, errorBucket)
+		}
+		return v
+	case []interface{}:
+		for index, item := range v {
+			v[index] = interpolateEnv(item, errorBucket)
+		}
+		return v
+	case string:
+		return envReference.ReplaceAllStringFunc(v, func(reference string) string {
+			if reference == "$$" {
+				return "$"
+			}
+			parts := envReference.FindStringSubmatch(reference)
+			name := parts[1]
+			hasDefault := strings.Contains(reference, ":-")
+			if envValue, ok := os.LookupEnv(name); ok && (envValue != "" || !hasDefault) {
+				return envValue
+			}
+			if hasDefault {
+				return parts[2]
+			}
+			errorBucket.add(fmt.Sprintf("environment variable %s is not set and has no default.", name))
+			return ""
+		})
+	default:
+		return v
+	}
+}
+
+// readSecretFiles reads the credentials of connections that are given as files. A
+// credential key ending in _file is replaced with the key without the suffix holding the
+// contents of the file. Trailing new lines are removed. Relative paths are relative to
+// the directory given.
+func readSecretFiles(tree map[string]interface{}, dir string, errorBucket *ValidationError) {
+	for _, section := range secretSections {
+		connections, ok := tree[section].([]interface{})
+		if !ok {
+			continue
+		}
+		for _, item := range connections {
+			if connection, ok := item.(map[string]interface{}); ok {
+				readConnectionSecrets(connection, dir, errorBucket)
+			}
+		}
+	}
+}
+
+func readConnectionSecrets(connection map[string]interface{}, dir string, errorBucket *ValidationError) {
+	// The keys are changed after they are all read so that the map isn't changed while
+	// ranging over it.
+	secrets := make(map[string]string)
+	for _, target := range secretKeys {
+		key := target + secretFileSuffix
+		item, ok := connection[key]
+		if !ok {
+			continue
+		}
+		path, isString := item.(string)
+		if !isString {
+			errorBucket.add(fmt.Sprintf("%s must be a path.", key))
+			continue
+		}
+		if _, ok := connection[target]; ok {
+			errorBucket.add(fmt.Sprintf("%s and %s can not both be set.", target, key))
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			errorBucket.add(fmt.Sprintf("failed to read %s %s. Error %s", key, path, err))
+			continue
+		}
+		secrets[target] = strings.TrimRight(string(contents), "\r\n")
+	}
+	for target, secret := range secrets {
+		connection[target] = secret
+		delete(connection, target+secretFileSuffix)
+	}
+}
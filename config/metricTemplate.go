@@ -0,0 +1,146 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/silverstagtech/teller/metricCreator"
+)
+
+// Rendered is true if the event needs a new metric each time it fires rather than
+// sending the same one.
+func (e *Event) Rendered() bool {
+	return len(e.FanOut) > 0 || len(e.RandomTags) > 0 || e.hasExpressions() || e.hasFieldSources() || len(e.anomalies) > 0
+}
+
+// hasExpressions is true if the event has derived fields or fields that are expressions.
+func (e *Event) hasExpressions() bool {
+	if len(e.DerivedFields) > 0 {
+		return true
+	}
+	for _, value := range e.Fields {
+		if metricCreator.IsExpression(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// templateFields returns the fixed fields of the event, the fields that come from a
+// source such as a CSV file or a diurnal profile and its derived fields, which include
+// the fields that are expressions. derived is nil if there are none.
+func (e *Event) templateFields() (map[string]interface{}, map[string]metricCreator.FieldSource, *metricCreator.DerivedFields, error) {
+	fields, definitions := metricCreator.ExpressionFields(e.Fields)
+	sources := make(map[string]metricCreator.FieldSource)
+	for name, value := range fields {
+		source, ok, err := e.fieldSource(value)
+		if !ok {
+			continue
+		}
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("field %s is invalid. Error %s", name, err)
+		}
+		sources[name] = source
+		delete(fields, name)
+	}
+	for name, definition := range e.DerivedFields {
+		if _, ok := e.Fields[name]; ok {
+			return nil, nil, nil, fmt.Errorf("derived field %s is also a field", name)
+		}
+		definitions[name] = definition
+	}
+	if len(definitions) == 0 {
+		return fields, sources, nil, nil
+	}
+	known := make(map[string]interface{}, len(fields)+len(sources))
+	for name, value := range fields {
+		known[name] = value
+	}
+	for name := range sources {
+		known[name] = 0
+	}
+	derived, err := metricCreator.NewDerivedFields(definitions, known)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return fields, sources, derived, nil
+}
+
+// fieldSource returns the source of a field value that comes from a CSV file or a diurnal
+// profile. ok is false if the value is neither.
+func (e *Event) fieldSource(value interface{}) (metricCreator.FieldSource, bool, error) {
+	if field, ok, err := csvField(value); ok {
+		if err != nil {
+			return nil, true, err
+		}
+		series, err := field.Series()
+		return series, true, err
+	}
+	if field, ok, err := diurnalField(value); ok {
+		if err != nil {
+			return nil, true, err
+		}
+		profile, err := e.diurnalProfile(field.Diurnal)
+		if err != nil {
+			return nil, true, err
+		}
+		return profile.Field(field.Scale), true, nil
+	}
+	return nil, false, nil
+}
+
+// hasFieldSources is true if any of the fields of the event come from a CSV file or a
+// diurnal profile.
+func (e *Event) hasFieldSources() bool {
+	for _, value := range e.Fields {
+		_, isCSV, _ := csvField(value)
+		_, isDiurnal, _ := diurnalField(value)
+		if isCSV || isDiurnal {
+			return true
+		}
+	}
+	return false
+}
+
+// MetricTemplate returns a metric template for the event with a TagPicker for each
+// of its random tags, its derived fields, its expression fields, its CSV and diurnal fields
+// and the anomalies of its time slice.
+func (e *Event) MetricTemplate() (*metricCreator.MetricTemplate, error) {
+	pickers := make(map[string]metricCreator.TagPicker, len(e.RandomTags))
+	for key, randomTag := range e.RandomTags {
+		picker, err := randomTag.Picker()
+		if err != nil {
+			return nil, fmt.Errorf("random tag %s is invalid. Error %s", key, err)
+		}
+		pickers[key] = picker
+	}
+	fields := e.Fields
+	var sources map[string]metricCreator.FieldSource
+	var derived *metricCreator.DerivedFields
+	if e.hasExpressions() || e.hasFieldSources() {
+		var err error
+		fields, sources, derived, err = e.templateFields()
+		if err != nil {
+			return nil, err
+		}
+	}
+	template := metricCreator.NewMetricTemplate(e.MetricName, e.Tags, fields, pickers)
+	if len(sources) > 0 {
+		template.SetFieldSources(sources)
+	}
+	if derived != nil {
+		template.SetDerivedFields(derived)
+	}
+	if len(e.anomalies) > 0 {
+		overlays := make([]*metricCreator.Anomaly, 0, len(e.anomalies))
+		for _, anomaly := range e.anomalies {
+			overlay, err := anomaly.Overlay()
+			if err != nil {
+				return nil, fmt.Errorf("anomaly %s is invalid. Error %s", anomaly.Type, err)
+			}
+			overlays = append(overlays, overlay)
+		}
+		template.SetAnomalies(overlays, e.Repeat)
+	}
+	template.SetTimeslice(e.timeslice)
+	return template, nil
+}
@@ -0,0 +1,25 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/silverstagtech/teller/metricCreator"
+)
+
+// Picker returns the TagPicker that the random tag describes.
+func (rt *RandomTag) Picker() (metricCreator.TagPicker, error) {
+	switch {
+	case rt == nil || (rt.Weighted == nil && rt.Zipf == nil):
+		return nil, fmt.Errorf("random tags need weighted or zipf values")
+	case rt.Weighted != nil && rt.Zipf != nil:
+		return nil, fmt.Errorf("random tags can not have both weighted and zipf values")
+	case rt.Weighted != nil:
+		return metricCreator.NewWeightedTag(rt.Weighted)
+	default:
+		values, err := metricCreator.ExpandTagValues(rt.Zipf.Values)
+		if err != nil {
+			return nil, err
+		}
+		return metricCreator.NewZipfTag(values, rt.Zipf.S, rt.Zipf.V)
+	}
+}
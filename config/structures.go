@@ -1,22 +1,100 @@
 package config
 
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/silverstagtech/teller/cron"
+)
+
+const (
+	// LoopForever makes a timeline play out until the story is stopped.
+	LoopForever = "forever"
+)
+
 // Story is a complete configuration that describes connections and timelines.
 // A story basically defines how often things happen when
 type Story struct {
-	StoryName    string              `json:"story_name"`
-	Continuous   bool                `json:"continuous"`
-	DebugLogging bool                `json:"debug_logging"`
-	GlobalTags   map[string]string   `json:"global_tags"`
-	Influx       []*InfluxConnection `json:"influx"`
-	StatsD       []*StatsDConnection `json:"statsd"`
-	TimeLines    []*TimeLine         `json:"timelines"`
+	// Include lists other configuration files that are merged into the story.
+	Include []string `json:"include"`
+	// Variables are the defaults of the values referenced with {{ .name }}.
+	Variables  map[string]interface{} `json:"variables"`
+	StoryName  string                 `json:"story_name"`
+	Continuous bool                   `json:"continuous"`
+	// StopAfterFiniteTimelines stops the story once every timeline with a loop count has
+	// finished, even if other timelines loop forever.
+	StopAfterFiniteTimelines bool `json:"stop_after_finite_timelines"`
+	// MaxDuration, such as "30m", stops the story once it has passed.
+	MaxDuration string `json:"max_duration"`
+	// DrainTimeout limits how long the connections have to send what they have queued
+	// when the story stops.
+	DrainTimeout string               `json:"drain_timeout"`
+	DebugLogging bool                 `json:"debug_logging"`
+	GlobalTags   map[string]string    `json:"global_tags"`
+	Influx       []*InfluxConnection  `json:"influx"`
+	StatsD       []*StatsDConnection  `json:"statsd"`
+	Grafana      []*GrafanaConnection `json:"grafana"`
+	HTTP         []*HTTPConnection    `json:"http"`
+	File         []*FileConnection    `json:"file"`
+	Templates    map[string]*Event    `json:"templates"`
+	// DiurnalProfiles are the daily curves that fields and event rates can follow by name.
+	DiurnalProfiles map[string]*DiurnalProfile `json:"diurnal_profiles"`
+	TimeLines       []*TimeLine                `json:"timelines"`
+}
+
+// ParsedMaxDuration returns how long the story runs for or 0 if it has no limit.
+func (s *Story) ParsedMaxDuration() time.Duration {
+	return parseDuration(s.MaxDuration)
+}
+
+// ParsedDrainTimeout returns how long the connections have to drain when the story stops
+// or 0 if they can take as long as they need.
+func (s *Story) ParsedDrainTimeout() time.Duration {
+	return parseDuration(s.DrainTimeout)
+}
+
+// parseDuration returns the duration given or 0 if it is blank or invalid.
+func parseDuration(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0
+	}
+	return d
 }
 
 // TimeLine defines the expected structure of a list of timelines in a
 // story.
 type TimeLine struct {
-	Name       string       `json:"timeline_name"`
-	Timeslices []*Timeslice `json:"time_slices"`
+	Name string `json:"timeline_name"`
+	// StartAfter, such as "30s", delays the start of the timeline.
+	StartAfter string `json:"start_after"`
+	// Loop is how many times the timeline plays out, a number or "forever". It overrides
+	// the continuous setting of the story.
+	Loop interface{} `json:"loop"`
+	// Selection picks how the next time slice is chosen, in order by default, "markov" to
+	// use the transitions of each time slice or "weighted_random" to use their weights.
+	Selection string `json:"selection"`
+	// StateTag adds the name of the time slice to every event as a tag.
+	StateTag string `json:"state_tag"`
+	// StateMetric is sent each time a time slice starts.
+	StateMetric *StateMetric `json:"state_metric"`
+	Timeslices  []*Timeslice `json:"time_slices"`
+	source      string
+}
+
+// StateMetric is a metric that is sent each time a time slice in a timeline starts.
+// It has a state tag with the name of the time slice and a value field of 1.
+type StateMetric struct {
+	MetricName   string            `json:"metric_name"`
+	Type         string            `json:"type"`
+	ConnectionID string            `json:"connection_id"`
+	Tags         map[string]string `json:"tags"`
 }
 
 // Timeslice is a group of events in a story, they can repeat if needed.
@@ -25,22 +103,154 @@ type Timeslice struct {
 	Events    []*Event `json:"events"`
 	Repeat    int      `json:"repeat"`
 	SingleUse bool     `json:"single_use"`
+	// Transitions are the percentage chance of moving to each other time slice, by name,
+	// in a markov timeline.
+	Transitions map[string]float64 `json:"transitions"`
+	// Weight is how likely the time slice is to be picked in a weighted random timeline.
+	Weight float64 `json:"weight"`
+	// Duration, such as "5m", makes the events play out over and over until it has passed.
+	Duration string `json:"duration"`
+	// Schedule, a cron expression with seconds, or At, a time of day like "14:30", make
+	// the time slice wait for the wall clock before it starts.
+	Schedule string `json:"schedule"`
+	At       string `json:"at"`
+	// WaitFor makes the time slice wait for a signal that another time slice Signals when
+	// it starts.
+	WaitFor string   `json:"wait_for"`
+	Signals []string `json:"signals"`
+	// Anomalies change the values its events send, see Anomaly.
+	Anomalies []*Anomaly `json:"anomalies"`
+}
+
+// Loops returns how many times the timeline plays out, 0 means forever. Timelines
+// without a loop follow the continuous setting of the story.
+func (tl *TimeLine) Loops(continuous bool) (int, error) {
+	var loops float64
+	switch v := tl.Loop.(type) {
+	case nil:
+		if continuous {
+			return 0, nil
+		}
+		return 1, nil
+	case float64:
+		loops = v
+	case string:
+		if v == LoopForever {
+			return 0, nil
+		}
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("loop %s must be a positive number or %s", v, LoopForever)
+		}
+		loops = parsed
+	default:
+		return 0, fmt.Errorf("loop %v must be a positive number or %s", v, LoopForever)
+	}
+	if loops < 1 || loops != math.Trunc(loops) {
+		return 0, fmt.Errorf("loop %v must be a positive number or %s", tl.Loop, LoopForever)
+	}
+	return int(loops), nil
+}
+
+// ParsedStartAfter returns how long the timeline waits before it starts or 0 if it
+// does not have a valid start_after.
+func (tl *TimeLine) ParsedStartAfter() time.Duration {
+	return parseDuration(tl.StartAfter)
+}
+
+// ParsedDuration returns the duration of the time slice or 0 if it does not have a valid
+// one.
+func (ts *Timeslice) ParsedDuration() time.Duration {
+	return parseDuration(ts.Duration)
+}
+
+// ParsedSchedule returns the schedule of the time slice or nil if it does not have one.
+func (ts *Timeslice) ParsedSchedule() (*cron.Schedule, error) {
+	switch {
+	case ts.Schedule != "":
+		return cron.Parse(ts.Schedule)
+	case ts.At != "":
+		return cron.ParseAt(ts.At)
+	}
+	return nil, nil
 }
 
 // Event is a timeline event that can be a sleeper or a metric being
 // sent to the endpoint of choice.
 type Event struct {
-	MetricName          string                 `json:"metric_name"`
-	Type                string                 `json:"type"`
-	ConnectionID        string                 `json:"connection_id"`
-	Repeat              int                    `json:"repeat"`
-	Fields              map[string]interface{} `json:"fields"`
-	Tags                map[string]string      `json:"tags"`
-	StatsDTaggingFormat string                 `json:"statsd_tagging_format"`
-	TimeBetween         TimeBetween            `json:"time_between"`
+	// Extends names a template in the story that the event is built on top of.
+	Extends      string `json:"extends"`
+	MetricName   string `json:"metric_name"`
+	Type         string `json:"type"`
+	ConnectionID string `json:"connection_id"`
+	Repeat       int    `json:"repeat"`
+	// Fields whose value starts with = are worked out from an expression each time the
+	// event fires. A field can also take its values from a CSV file, see CSVField, or
+	// follow a diurnal profile, see DiurnalField.
+	Fields map[string]interface{} `json:"fields"`
+	Tags   map[string]string      `json:"tags"`
+	// RandomTags pick the value of a tag each time the event fires.
+	RandomTags map[string]*RandomTag `json:"random_tags"`
+	// FanOut maps tag names to lists of values or brace ranges and turns the event into a
+	// series for every combination of them.
+	FanOut     map[string]interface{} `json:"fan_out"`
+	FanOutMode string                 `json:"fan_out_mode"`
+	FanOutSize int                    `json:"fan_out_size"`
+	// DerivedFields are fields worked out from expressions each time the event fires.
+	DerivedFields       map[string]string `json:"derived_fields"`
+	StatsDTaggingFormat string            `json:"statsd_tagging_format"`
+	TimeBetween         TimeBetween       `json:"time_between"`
+	Annotation          *Annotation       `json:"annotation"`
+	HTTPRequest         *HTTPRequest      `json:"http_request"`
+	// Delivery drops, delays, backdates or duplicates points.
+	Delivery  *Delivery `json:"delivery"`
+	timeslice string
+	anomalies []*Anomaly
+	profiles  map[string]*DiurnalProfile
+}
+
+// RandomTag defines how the value of a tag is picked each time an event fires.
+// Only one of Weighted or Zipf can be used.
+type RandomTag struct {
+	Weighted map[string]float64 `json:"weighted"`
+	Zipf     *ZipfTag           `json:"zipf"`
 }
 
-// TimeBetween defines the expected structure of a metric timing story
+// ZipfTag picks tag values with a zipf distribution. Values is a list or brace range in the
+// same form as a fan out, with the most common value first. S must be greater than 1 and
+// controls how skewed the values are. V must be 1 or more and defaults to 1.
+type ZipfTag struct {
+	Values interface{} `json:"values"`
+	S      float64     `json:"s"`
+	V      float64     `json:"v"`
+}
+
+// Annotation defines the expected structure of an annotation event. The annotation
+// is written to the Influx connection of the event, and/or posted to the Grafana
+// connection named here.
+type Annotation struct {
+	Title               string   `json:"title"`
+	Text                string   `json:"text"`
+	Tags                []string `json:"tags"`
+	GrafanaConnectionID string   `json:"grafana_connection_id"`
+	DashboardID         int      `json:"dashboard_id"`
+	PanelID             int      `json:"panel_id"`
+}
+
+// HTTPRequest defines the expected structure of the request made by a http event.
+// The path, header values and strings in the body are templates that have access to
+// the metric name, tags and fields of the event.
+type HTTPRequest struct {
+	Method         string            `json:"method"`
+	Path           string            `json:"path"`
+	Headers        map[string]string `json:"headers"`
+	Body           interface{}       `json:"body"`
+	ExpectedStatus int               `json:"expected_status"`
+	Timeout        int               `json:"timeout"`
+}
+
+// TimeBetween defines the expected structure of a metric timing story.
+// Diurnal names a diurnal profile that the rate of the event follows.
 type TimeBetween struct {
 	Static struct {
 		Time int `json:"time"`
@@ -49,6 +259,7 @@ type TimeBetween struct {
 		MinimumTime int `json:"minimum_time"`
 		Vary        int `json:"vary"`
 	} `json:"dynamic"`
+	Diurnal string `json:"diurnal"`
 }
 
 // InfluxConnection defines the expected structure of the Influx connections
@@ -64,6 +275,7 @@ type InfluxConnection struct {
 	FlushInterval int    `json:"flush_interval"`
 	HTTPTimeout   int    `json:"http_timeout"`
 	NWriters      int    `json:"number_of_writers"`
+	source        string
 }
 
 // StatsDConnection defines the expected structure of the StatsD connections
@@ -74,4 +286,113 @@ type StatsDConnection struct {
 	Port       uint16 `json:"port"`
 	Transport  string `json:"transport"`
 	QueueDepth int    `json:"buffer_depth"`
+	source     string
+}
+
+// GrafanaConnection defines the expected structure of the Grafana connections
+// passing in via the configuration
+type GrafanaConnection struct {
+	ID          string `json:"id"`
+	Host        string `json:"host"`
+	APIKey      string `json:"api_key"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	HTTPTimeout int    `json:"http_timeout"`
+	source      string
+}
+
+// HTTPConnection defines the expected structure of the HTTP connections
+// passing in via the configuration
+type HTTPConnection struct {
+	ID            string            `json:"id"`
+	Host          string            `json:"host"`
+	Username      string            `json:"username"`
+	Password      string            `json:"password"`
+	BearerToken   string            `json:"bearer_token"`
+	Headers       map[string]string `json:"headers"`
+	TLSSkipVerify bool              `json:"tls_skip_verify"`
+	HTTPTimeout   int               `json:"http_timeout"`
+	source        string
+}
+
+// FileConnection defines the expected structure of the file connections
+// passing in via the configuration. A path of "-" writes to stdout.
+type FileConnection struct {
+	ID         string `json:"id"`
+	Path       string `json:"path"`
+	Format     string `json:"format"`
+	MaxSize    int64  `json:"max_size"`
+	MaxBackups int    `json:"max_backups"`
+	source     string
+}
+
+// setSource records the file that the connections and timelines of the story came from.
+// It is used to say where duplicates were found when a story is made of several files.
+func (s *Story) setSource(path string) {
+	for _, i := range s.Influx {
+		i.source = path
+	}
+	for _, sd := range s.StatsD {
+		sd.source = path
+	}
+	for _, g := range s.Grafana {
+		g.source = path
+	}
+	for _, h := range s.HTTP {
+		h.source = path
+	}
+	for _, f := range s.File {
+		f.source = path
+	}
+	for _, tl := range s.TimeLines {
+		tl.source = path
+		for _, ts := range tl.Timeslices {
+			for _, e := range ts.Events {
+				e.setCSVSource(filepath.Dir(path))
+			}
+		}
+	}
+}
+
+// setEventContext tells each event the name of the time slice it belongs to so that its
+// expressions can use it, the anomalies of the time slice that apply to it and the
+// diurnal profiles of the story.
+func (s *Story) setEventContext() {
+	for _, timeline := range s.TimeLines {
+		for _, timeslice := range timeline.Timeslices {
+			for _, event := range timeslice.Events {
+				event.timeslice = timeslice.Name
+				event.profiles = s.DiurnalProfiles
+				event.anomalies = nil
+				for _, anomaly := range timeslice.Anomalies {
+					if anomaly.appliesTo(event) {
+						event.anomalies = append(event.anomalies, anomaly)
+					}
+				}
+			}
+		}
+	}
+}
+
+// StateEvent returns the event that is sent when the time slice named by state starts.
+// It returns nil if the timeline has no state metric.
+func (tl *TimeLine) StateEvent(state string) *Event {
+	if tl.StateMetric == nil {
+		return nil
+	}
+	tags := map[string]string{"state": state}
+	if tl.StateMetric.Type == "statsd" {
+		tags["metric_type"] = "gauge"
+	}
+	for key, value := range tl.StateMetric.Tags {
+		tags[key] = value
+	}
+	return &Event{
+		MetricName:   tl.StateMetric.MetricName,
+		Type:         tl.StateMetric.Type,
+		ConnectionID: tl.StateMetric.ConnectionID,
+		Repeat:       1,
+		Tags:         tags,
+		Fields:       map[string]interface{}{"value": 1},
+	}
 }
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	templatesKey   = "templates"
+	extendsKey     = "extends"
+	timeBetweenKey = "time_between"
+)
+
+// deepMerge returns base with override merged on top of it. Maps are merged key by key,
+// anything else in override replaces the value in base.
+func deepMerge(base, override map[string]interface{}) map[string]interface{} {
+	merged := copyTree(base).(map[string]interface{})
+	for key, value := range override {
+		baseMap, baseIsMap := merged[key].(map[string]interface{})
+		overrideMap, overrideIsMap := value.(map[string]interface{})
+		if baseIsMap && overrideIsMap {
+			merged[key] = deepMerge(baseMap, overrideMap)
+			continue
+		}
+		merged[key] = copyTree(value)
+	}
+
+	// An event can only have one timer. If the override picks a timer then the
+	// other timer from the base is dropped.
+	if overrideTimer, ok := override[timeBetweenKey].(map[string]interface{}); ok {
+		if mergedTimer, ok := merged[timeBetweenKey].(map[string]interface{}); ok {
+			_, hasStatic := overrideTimer["static"]
+			_, hasDynamic := overrideTimer["dynamic"]
+			if hasStatic && !hasDynamic {
+				delete(mergedTimer, "dynamic")
+			}
+			if hasDynamic && !hasStatic {
+				delete(mergedTimer, "static")
+			}
+		}
+	}
+	return merged
+}
+
+type templateResolver struct {
+	templates   map[string]interface{}
+	resolved    map[string]map[string]interface{}
+	errorBucket *ValidationError
+}
+
+// template returns the named template with anything it extends merged in.
+// chain is the list of templates being resolved and is used to find loops.
+func (tr *templateResolver) template(name string, chain []string) (map[string]interface{}, bool) {
+	if resolved, ok := tr.resolved[name]; ok {
+		return resolved, true
+	}
+	for _, previous := range chain {
+		if previous == name {
+			tr.errorBucket.add(fmt.Sprintf("template %s extends itself through %s.", name, strings.Join(append(chain, name), " -> ")))
+			return nil, false
+		}
+	}
+	t, ok := tr.templates[name].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	resolved := t
+	if parent, ok := t[extendsKey].(string); ok {
+		parentTemplate, ok := tr.template(parent, append(chain, name))
+		if !ok {
+			if _, exists := tr.templates[parent]; !exists {
+				tr.errorBucket.add(fmt.Sprintf("template %s extends unknown template %s.", name, parent))
+			}
+			return nil, false
+		}
+		resolved = deepMerge(parentTemplate, t)
+	}
+	delete(resolved, extendsKey)
+	tr.resolved[name] = resolved
+	return resolved, true
+}
+
+// resolveTemplates merges the templates that events extend into the events.
+// The event keeps its own values where both have the same key.
+func resolveTemplates(tree map[string]interface{}, errorBucket *ValidationError) {
+	templates, _ := tree[templatesKey].(map[string]interface{})
+	tr := &templateResolver{
+		templates:   templates,
+		resolved:    make(map[string]map[string]interface{}),
+		errorBucket: errorBucket,
+	}
+
+	timelines, _ := tree["timelines"].([]interface{})
+	for _, timeline := range timelines {
+		timelineMap, _ := timeline.(map[string]interface{})
+		timeslices, _ := timelineMap["time_slices"].([]interface{})
+		for _, timeslice := range timeslices {
+			timesliceMap, _ := timeslice.(map[string]interface{})
+			events, _ := timesliceMap["events"].([]interface{})
+			for index, event := range events {
+				eventMap, ok := event.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				name, ok := eventMap[extendsKey].(string)
+				if !ok {
+					continue
+				}
+				t, ok := tr.template(name, []string{})
+				if !ok {
+					if _, exists := templates[name]; !exists {
+						errorBucket.add(fmt.Sprintf("event %v extends unknown template %s.", eventMap["metric_name"], name))
+					}
+					continue
+				}
+				events[index] = deepMerge(t, eventMap)
+			}
+		}
+	}
+}
@@ -4,13 +4,21 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
+
+	"github.com/silverstagtech/loggos"
+	"github.com/silverstagtech/teller/metricCreator"
 )
 
 var (
-	validEventTypes      = []string{"influx", "statsd", "sleeper"}
-	statsdMetricTypes    = []string{"gauge", "set", "counter", "timing", "histogram"}
-	validPrecisions      = []string{"h", "m", "s", "ms", "u", "ns"}
-	validStatsdTransport = []string{"tcp", "udp"}
+	validEventTypes         = []string{"influx", "statsd", "sleeper", "annotation", "http", "file"}
+	validFileFormats        = []string{"influx", "statsd"}
+	validRenderedEventTypes = []string{"influx", "statsd", "file"}
+	validSelections         = []string{"", "sequential", "markov", "weighted_random"}
+	validHTTPMethods        = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	statsdMetricTypes       = []string{"gauge", "set", "counter", "timing", "histogram"}
+	validPrecisions         = []string{"h", "m", "s", "ms", "u", "ns"}
+	validStatsdTransport    = []string{"tcp", "udp"}
 )
 
 // ValidationError is a collections of errors found while validation the configuration.
@@ -50,7 +58,13 @@ func validateEvent(e Event, errorBucket *ValidationError) {
 		if !validType {
 			errorBucket.add(fmt.Sprintf("Type is not valid. Only %s is allowed.", strings.Join(validEventTypes, ",")))
 		} else {
-			if e.Type != "sleeper" {
+			switch e.Type {
+			case "sleeper":
+			case "annotation":
+				validateAnnotation(e, errorBucket)
+			case "http":
+				validateHTTPRequest(e, errorBucket)
+			default:
 				if e.ConnectionID == "" {
 					errorBucket.add("event connection_id must not be blank.")
 				}
@@ -81,6 +95,139 @@ func validateEvent(e Event, errorBucket *ValidationError) {
 		errorBucket.add("event repeat must be a positive number.")
 	}
 	validateTimeBetween(e.TimeBetween, errorBucket)
+	if e.TimeBetween.Diurnal != "" {
+		if _, ok := e.profiles[e.TimeBetween.Diurnal]; !ok {
+			errorBucket.add(fmt.Sprintf("event %s rate follows diurnal profile %s which does not exist.", e.MetricName, e.TimeBetween.Diurnal))
+		}
+	}
+	if len(e.RandomTags) > 0 {
+		validateRandomTags(e, errorBucket)
+	}
+	if len(e.FanOut) > 0 || e.FanOutMode != "" || e.FanOutSize != 0 {
+		validateFanOut(e, errorBucket)
+	}
+	if e.Delivery != nil {
+		validateDelivery(e, errorBucket)
+	}
+	if e.hasExpressions() || e.hasFieldSources() {
+		validateTemplateFields(e, errorBucket)
+	}
+}
+
+func validateRenderedEventType(e Event, feature string, errorBucket *ValidationError) bool {
+	for _, renderedType := range validRenderedEventTypes {
+		if e.Type == renderedType {
+			return true
+		}
+	}
+	errorBucket.add(fmt.Sprintf("event %s can not use %s. Only %s events can.", e.MetricName, feature, strings.Join(validRenderedEventTypes, ",")))
+	return false
+}
+
+func validateRandomTags(e Event, errorBucket *ValidationError) {
+	if !validateRenderedEventType(e, "random_tags", errorBucket) {
+		return
+	}
+	for key, randomTag := range e.RandomTags {
+		if _, err := randomTag.Picker(); err != nil {
+			errorBucket.add(fmt.Sprintf("event %s random tag %s is invalid. %s.", e.MetricName, key, err))
+		}
+		if _, ok := e.FanOut[key]; ok {
+			errorBucket.add(fmt.Sprintf("event %s tag %s can not be in both random_tags and fan_out.", e.MetricName, key))
+		}
+		if e.Type == "statsd" && key == "metric_type" {
+			errorBucket.add(fmt.Sprintf("event %s metric_type can not be a random tag.", e.MetricName))
+		}
+	}
+}
+
+func validateTemplateFields(e Event, errorBucket *ValidationError) {
+	if !validateRenderedEventType(e, "derived_fields, expression fields, csv fields or diurnal fields", errorBucket) {
+		return
+	}
+	if _, _, _, err := e.templateFields(); err != nil {
+		errorBucket.add(fmt.Sprintf("event %s %s.", e.MetricName, err))
+	}
+}
+
+func validateDelivery(e Event, errorBucket *ValidationError) {
+	if !validateRenderedEventType(e, "delivery", errorBucket) {
+		return
+	}
+	d := e.Delivery
+	probabilities := map[string]float64{"drop": d.Drop, "duplicate": d.Duplicate}
+	shifts := map[string]*DeliveryShift{"delay": d.Delay, "backdate": d.Backdate}
+	for _, name := range []string{"delay", "backdate"} {
+		if shift := shifts[name]; shift != nil {
+			probabilities[name+" probability"] = shift.Probability
+			if shift.Seconds <= 0 {
+				errorBucket.add(fmt.Sprintf("event %s delivery %s seconds must be more than 0.", e.MetricName, name))
+			}
+		}
+	}
+	for _, name := range []string{"drop", "duplicate", "delay probability", "backdate probability"} {
+		if p, ok := probabilities[name]; ok && (p < 0 || p > 1) {
+			errorBucket.add(fmt.Sprintf("event %s delivery %s must be from 0 to 1.", e.MetricName, name))
+		}
+	}
+	if d.Backdate != nil && e.Type != "influx" {
+		errorBucket.add(fmt.Sprintf("event %s can not backdate points. Only influx points have times.", e.MetricName))
+	}
+}
+
+func validateFanOut(e Event, errorBucket *ValidationError) {
+	if !validateRenderedEventType(e, "fan_out", errorBucket) {
+		return
+	}
+	if len(e.FanOut) == 0 {
+		errorBucket.add(fmt.Sprintf("event %s has a fan_out_mode or fan_out_size but no fan_out.", e.MetricName))
+		return
+	}
+	if e.FanOutSize < 0 {
+		errorBucket.add(fmt.Sprintf("event %s fan_out_size must be a positive number.", e.MetricName))
+	}
+	template := metricCreator.NewMetricTemplate(e.MetricName, e.Tags, e.Fields, nil)
+	if _, err := metricCreator.NewFanOut(template, e.FanOut, e.FanOutMode, e.FanOutSize); err != nil {
+		errorBucket.add(fmt.Sprintf("event %s fan_out is invalid. %s.", e.MetricName, err))
+	}
+}
+
+func validateAnnotation(e Event, errorBucket *ValidationError) {
+	if e.Annotation == nil {
+		errorBucket.add(fmt.Sprintf("annotation event %s must have an annotation section.", e.MetricName))
+		return
+	}
+	if e.ConnectionID == "" && e.Annotation.GrafanaConnectionID == "" {
+		errorBucket.add(fmt.Sprintf("annotation event %s needs a connection_id, a grafana_connection_id or both.", e.MetricName))
+	}
+	if e.Annotation.Title == "" && e.Annotation.Text == "" {
+		errorBucket.add(fmt.Sprintf("annotation event %s needs a title or text.", e.MetricName))
+	}
+}
+
+func validateHTTPRequest(e Event, errorBucket *ValidationError) {
+	if e.ConnectionID == "" {
+		errorBucket.add("event connection_id must not be blank.")
+	}
+	if e.HTTPRequest == nil {
+		errorBucket.add(fmt.Sprintf("http event %s must have a http_request section.", e.MetricName))
+		return
+	}
+	validMethod := false
+	for _, method := range validHTTPMethods {
+		if e.HTTPRequest.Method == method {
+			validMethod = true
+		}
+	}
+	if !validMethod {
+		errorBucket.add(fmt.Sprintf("http event %s method is invalid. Only %s are valid.", e.MetricName, strings.Join(validHTTPMethods, ",")))
+	}
+	if e.HTTPRequest.ExpectedStatus != 0 && (e.HTTPRequest.ExpectedStatus < 100 || e.HTTPRequest.ExpectedStatus > 599) {
+		errorBucket.add(fmt.Sprintf("http event %s expected_status must be a valid HTTP status code.", e.MetricName))
+	}
+	if e.HTTPRequest.Timeout < 0 {
+		errorBucket.add(fmt.Sprintf("http event %s timeout must be a positive number.", e.MetricName))
+	}
 }
 
 func validateTimeBetween(t TimeBetween, errorBucket *ValidationError) {
@@ -96,6 +243,9 @@ func validateTimeBetween(t TimeBetween, errorBucket *ValidationError) {
 	if t.Dynamic.MinimumTime < 0 || t.Dynamic.Vary < 0 {
 		errorBucket.add("event dynamic timer minimum_time and vary must be a positive number")
 	}
+	if t.Static.Time == 0 && t.Dynamic.MinimumTime > 0 && t.Dynamic.Vary == 0 {
+		errorBucket.add("event dynamic timer must have a vary. Use a static timer for a fixed time.")
+	}
 }
 
 func validateTimeSlice(ts Timeslice, errorBucket *ValidationError) {
@@ -105,6 +255,16 @@ func validateTimeSlice(ts Timeslice, errorBucket *ValidationError) {
 	if ts.Repeat < 0 {
 		errorBucket.add("Timeslice should repeat at least once.")
 	}
+	if ts.Duration != "" {
+		if d, err := time.ParseDuration(ts.Duration); err != nil || d <= 0 {
+			errorBucket.add(fmt.Sprintf("Timeslice %s duration %s is invalid. Use a positive duration like 90s or 5m.", ts.Name, ts.Duration))
+		}
+	}
+	if ts.Schedule != "" && ts.At != "" {
+		errorBucket.add(fmt.Sprintf("Timeslice %s can have a schedule or at but not both.", ts.Name))
+	} else if _, err := ts.ParsedSchedule(); err != nil {
+		errorBucket.add(fmt.Sprintf("Timeslice %s %s.", ts.Name, err))
+	}
 	if len(ts.Events) == 0 {
 		errorBucket.add("Timeslices should have at least one event.")
 	} else {
@@ -112,12 +272,130 @@ func validateTimeSlice(ts Timeslice, errorBucket *ValidationError) {
 			validateEvent(*event, errorBucket)
 		}
 	}
+	for _, anomaly := range ts.Anomalies {
+		validateAnomaly(ts, anomaly, errorBucket)
+	}
+}
+
+func validateAnomaly(ts Timeslice, anomaly *Anomaly, errorBucket *ValidationError) {
+	if _, err := anomaly.Overlay(); err != nil {
+		errorBucket.add(fmt.Sprintf("Timeslice %s %s.", ts.Name, err))
+		return
+	}
+	for _, name := range anomaly.Events {
+		found := false
+		for _, event := range ts.Events {
+			if event.MetricName == name {
+				found = true
+				validateRenderedEventType(*event, "anomalies", errorBucket)
+			}
+		}
+		if !found {
+			errorBucket.add(fmt.Sprintf("Timeslice %s %s anomaly is for event %s which is not in the time slice.", ts.Name, anomaly.Type, name))
+		}
+	}
+	matched := false
+	for _, event := range ts.Events {
+		if !anomaly.appliesTo(event) {
+			continue
+		}
+		matched = true
+		for _, field := range anomaly.Fields {
+			_, isField := event.Fields[field]
+			_, isDerived := event.DerivedFields[field]
+			if !isField && !isDerived {
+				errorBucket.add(fmt.Sprintf("Timeslice %s %s anomaly changes field %s which event %s does not have.", ts.Name, anomaly.Type, field, event.MetricName))
+			}
+		}
+	}
+	if !matched && len(anomaly.Events) == 0 {
+		errorBucket.add(fmt.Sprintf("Timeslice %s %s anomaly has no influx, statsd or file events to change.", ts.Name, anomaly.Type))
+	}
+}
+
+func validateSelection(tl TimeLine, errorBucket *ValidationError) {
+	validSelection := false
+	for _, selection := range validSelections {
+		if tl.Selection == selection {
+			validSelection = true
+		}
+	}
+	if !validSelection {
+		errorBucket.add(fmt.Sprintf("Timeline %s selection is invalid. Only %s are valid.", tl.Name, strings.Join(validSelections[1:], ",")))
+		return
+	}
+
+	names := make(map[string]bool)
+	for _, ts := range tl.Timeslices {
+		if tl.Selection == "markov" && names[ts.Name] {
+			errorBucket.add(fmt.Sprintf("Timeline %s uses markov selection so time slice names must be unique. %s is duplicated.", tl.Name, ts.Name))
+		}
+		names[ts.Name] = true
+	}
+	for _, ts := range tl.Timeslices {
+		if ts.Weight < 0 {
+			errorBucket.add(fmt.Sprintf("Time slice %s weight must be a positive number.", ts.Name))
+		}
+		if ts.Weight != 0 && tl.Selection != "weighted_random" {
+			errorBucket.add(fmt.Sprintf("Time slice %s has a weight but timeline %s does not use weighted_random selection.", ts.Name, tl.Name))
+		}
+		if tl.Selection != "markov" {
+			if len(ts.Transitions) > 0 {
+				errorBucket.add(fmt.Sprintf("Time slice %s has transitions but timeline %s does not use markov selection.", ts.Name, tl.Name))
+			}
+			continue
+		}
+		if ts.SingleUse {
+			errorBucket.add(fmt.Sprintf("Time slice %s can not be single use in a markov timeline.", ts.Name))
+		}
+		total := 0.0
+		for to, weight := range ts.Transitions {
+			if !names[to] {
+				errorBucket.add(fmt.Sprintf("Time slice %s has a transition to %s which is not in timeline %s.", ts.Name, to, tl.Name))
+			}
+			if weight <= 0 {
+				errorBucket.add(fmt.Sprintf("Time slice %s transition to %s must be a positive number.", ts.Name, to))
+			}
+			total += weight
+		}
+		if total > 100 {
+			errorBucket.add(fmt.Sprintf("Time slice %s transitions add up to more than 100.", ts.Name))
+		}
+	}
+}
+
+func validateStateMetric(tl TimeLine, errorBucket *ValidationError) {
+	if tl.StateMetric == nil {
+		return
+	}
+	if tl.StateMetric.MetricName == "" {
+		errorBucket.add(fmt.Sprintf("Timeline %s state_metric metric_name can not be blank.", tl.Name))
+	}
+	validType := false
+	for _, stateType := range validRenderedEventTypes {
+		if tl.StateMetric.Type == stateType {
+			validType = true
+		}
+	}
+	if !validType {
+		errorBucket.add(fmt.Sprintf("Timeline %s state_metric type is invalid. Only %s are valid.", tl.Name, strings.Join(validRenderedEventTypes, ",")))
+	}
 }
 
 func validateTimeLine(tl TimeLine, errorBucket *ValidationError) {
+	validateSelection(tl, errorBucket)
+	validateStateMetric(tl, errorBucket)
 	if tl.Name == "" {
 		errorBucket.add("Timelines must have a name.")
 	}
+	if _, err := tl.Loops(false); err != nil {
+		errorBucket.add(fmt.Sprintf("Timeline %s %s.", tl.Name, err))
+	}
+	if tl.StartAfter != "" {
+		if d, err := time.ParseDuration(tl.StartAfter); err != nil || d <= 0 {
+			errorBucket.add(fmt.Sprintf("Timeline %s start_after %s is invalid. Use a positive duration like 30s or 5m.", tl.Name, tl.StartAfter))
+		}
+	}
 	if len(tl.Timeslices) == 0 {
 		if tl.Name == "" {
 			errorBucket.add("A timeline has no name and no events.")
@@ -131,6 +409,38 @@ func validateTimeLine(tl TimeLine, errorBucket *ValidationError) {
 	}
 }
 
+// validateSignals makes sure that every signal a time slice waits for is sent by a
+// time slice in the story, otherwise it would wait forever.
+func validateSignals(s Story, errorBucket *ValidationError) {
+	senders := make(map[string][]*Timeslice)
+	for _, tl := range s.TimeLines {
+		for _, ts := range tl.Timeslices {
+			for _, signal := range ts.Signals {
+				if signal == "" {
+					errorBucket.add(fmt.Sprintf("Time slice %s has a blank signal.", ts.Name))
+					continue
+				}
+				senders[signal] = append(senders[signal], ts)
+			}
+		}
+	}
+	for _, tl := range s.TimeLines {
+		for _, ts := range tl.Timeslices {
+			if ts.WaitFor == "" {
+				continue
+			}
+			sentBy := senders[ts.WaitFor]
+			if len(sentBy) == 0 {
+				errorBucket.add(fmt.Sprintf("Time slice %s waits for signal %s which no time slice signals.", ts.Name, ts.WaitFor))
+				continue
+			}
+			if len(sentBy) == 1 && sentBy[0] == ts {
+				errorBucket.add(fmt.Sprintf("Time slice %s waits for signal %s which only it signals.", ts.Name, ts.WaitFor))
+			}
+		}
+	}
+}
+
 func validateInfluxConnection(i InfluxConnection, errorBucket *ValidationError) {
 	if i.ID == "" {
 		errorBucket.add("influx connect id can not be blank.")
@@ -207,23 +517,135 @@ func validateStatsd(s StatsDConnection, errorBucket *ValidationError) {
 	}
 }
 
+func validateGrafanaConnection(g GrafanaConnection, errorBucket *ValidationError) {
+	if g.ID == "" {
+		errorBucket.add("grafana connection id can not be blank.")
+	}
+	if g.Host == "" {
+		errorBucket.add("grafana connection host can not be blank.")
+	} else {
+		re := regexp.MustCompile(`http[s]?\:\/\/[a-z0-9\-\_.]+(?:\:[0-9]+)?`)
+		if !re.MatchString(g.Host) {
+			errorBucket.add("grafana connection hostname is invalid.")
+		}
+	}
+	if g.HTTPTimeout == 0 {
+		errorBucket.add("grafana connection http_timeout can not be blank.")
+	}
+}
+
+func validateHTTPConnection(h HTTPConnection, errorBucket *ValidationError) {
+	if h.ID == "" {
+		errorBucket.add("http connection id can not be blank.")
+	}
+	if h.Host == "" {
+		errorBucket.add("http connection host can not be blank.")
+	} else {
+		re := regexp.MustCompile(`http[s]?\:\/\/[a-z0-9\-\_.]+(?:\:[0-9]+)?`)
+		if !re.MatchString(h.Host) {
+			errorBucket.add("http connection hostname is invalid.")
+		}
+	}
+	if h.HTTPTimeout == 0 {
+		errorBucket.add("http connection http_timeout can not be blank.")
+	}
+}
+
+func validateFileConnection(f FileConnection, errorBucket *ValidationError) {
+	if f.ID == "" {
+		errorBucket.add("file connection id can not be blank.")
+	}
+	if f.Path == "" {
+		errorBucket.add("file connection path can not be blank.")
+	}
+	validFormat := false
+	for _, format := range validFileFormats {
+		if f.Format == format {
+			validFormat = true
+		}
+	}
+	if !validFormat {
+		errorBucket.add(fmt.Sprintf("file connection %s format is invalid. Only %s are valid.", f.ID, strings.Join(validFileFormats, ",")))
+	}
+	if f.MaxSize < 0 || f.MaxBackups < 0 {
+		errorBucket.add(fmt.Sprintf("file connection %s max_size and max_backups must be positive numbers.", f.ID))
+	}
+	if f.Path == "-" && f.MaxSize > 0 {
+		errorBucket.add(fmt.Sprintf("file connection %s writes to stdout and can not be rotated.", f.ID))
+	}
+}
+
+// duplicated describes an ID or name that is used more than once. The files that it
+// was found in are named if the story came from files.
+func duplicated(kind, id, firstSource, secondSource string) string {
+	switch {
+	case firstSource == "" && secondSource == "":
+		return fmt.Sprintf("%s %s is duplicated.", kind, id)
+	case firstSource == secondSource:
+		return fmt.Sprintf("%s %s is duplicated in %s.", kind, id, firstSource)
+	default:
+		return fmt.Sprintf("%s %s is duplicated. It is in %s and %s.", kind, id, firstSource, secondSource)
+	}
+}
+
 func validateNoDuplicateConnections(s Story, errorBucket *ValidationError) {
-	influxIDs := make(map[string]bool)
-	statsdIDs := make(map[string]bool)
+	influxIDs := make(map[string]string)
+	statsdIDs := make(map[string]string)
+	grafanaIDs := make(map[string]string)
+	httpIDs := make(map[string]string)
+	fileIDs := make(map[string]string)
 
 	for _, i := range s.Influx {
-		if influxIDs[i.ID] {
-			errorBucket.add(fmt.Sprintf("Influx ID %s is duplicated.", i.ID))
+		if source, ok := influxIDs[i.ID]; ok {
+			errorBucket.add(duplicated("Influx ID", i.ID, source, i.source))
 		} else {
-			influxIDs[i.ID] = true
+			influxIDs[i.ID] = i.source
 		}
 	}
 
 	for _, i := range s.StatsD {
-		if statsdIDs[i.ID] {
-			errorBucket.add(fmt.Sprintf("Influx ID %s is duplicated.", i.ID))
+		if source, ok := statsdIDs[i.ID]; ok {
+			errorBucket.add(duplicated("StatsD ID", i.ID, source, i.source))
 		} else {
-			statsdIDs[i.ID] = true
+			statsdIDs[i.ID] = i.source
+		}
+	}
+
+	for _, i := range s.Grafana {
+		if source, ok := grafanaIDs[i.ID]; ok {
+			errorBucket.add(duplicated("Grafana ID", i.ID, source, i.source))
+		} else {
+			grafanaIDs[i.ID] = i.source
+		}
+	}
+
+	for _, i := range s.HTTP {
+		if source, ok := httpIDs[i.ID]; ok {
+			errorBucket.add(duplicated("HTTP ID", i.ID, source, i.source))
+		} else {
+			httpIDs[i.ID] = i.source
+		}
+	}
+
+	for _, i := range s.File {
+		if source, ok := fileIDs[i.ID]; ok {
+			errorBucket.add(duplicated("File ID", i.ID, source, i.source))
+		} else {
+			fileIDs[i.ID] = i.source
+		}
+	}
+}
+
+func validateNoDuplicateTimelines(s Story, errorBucket *ValidationError) {
+	names := make(map[string]string)
+	for _, tl := range s.TimeLines {
+		if tl.Name == "" {
+			continue
+		}
+		if source, ok := names[tl.Name]; ok {
+			errorBucket.add(duplicated("Timeline", tl.Name, source, tl.source))
+		} else {
+			names[tl.Name] = tl.source
 		}
 	}
 }
@@ -235,6 +657,10 @@ func validateEventLinks(s Story, errorBucket *ValidationError) {
 	}
 	influxIds := make(map[string]*link)
 	statsdIds := make(map[string]*link)
+	grafanaIds := make(map[string]*link)
+	httpIds := make(map[string]*link)
+	fileIds := make(map[string]*link)
+	fileFormats := make(map[string]string)
 
 	// gather IDs
 	for _, i := range s.Influx {
@@ -243,21 +669,62 @@ func validateEventLinks(s Story, errorBucket *ValidationError) {
 	for _, s := range s.StatsD {
 		statsdIds[s.ID] = new(link)
 	}
+	for _, g := range s.Grafana {
+		grafanaIds[g.ID] = new(link)
+	}
+	for _, h := range s.HTTP {
+		httpIds[h.ID] = new(link)
+	}
+	for _, f := range s.File {
+		fileIds[f.ID] = new(link)
+		fileFormats[f.ID] = f.Format
+	}
 
 	for _, timeline := range s.TimeLines {
+		events := []*Event{}
 		for _, timeslice := range timeline.Timeslices {
-			for _, event := range timeslice.Events {
-				switch event.Type {
-				case "sleeper":
-					continue
-				case "statsd":
-					if statsdIds[event.ConnectionID] == nil {
-						errorBucket.add(fmt.Sprintf("event %s has an bad id %s", event.MetricName, event.ConnectionID))
-					} else {
-						statsdIds[event.ConnectionID].count++
-						statsdIds[event.ConnectionID].used = true
+			events = append(events, timeslice.Events...)
+		}
+		if stateEvent := timeline.StateEvent(""); stateEvent != nil {
+			events = append(events, stateEvent)
+		}
+		for _, event := range events {
+			switch event.Type {
+			case "sleeper":
+				continue
+			case "statsd":
+				if statsdIds[event.ConnectionID] == nil {
+					errorBucket.add(fmt.Sprintf("event %s has an bad id %s", event.MetricName, event.ConnectionID))
+				} else {
+					statsdIds[event.ConnectionID].count++
+					statsdIds[event.ConnectionID].used = true
+				}
+			case "influx":
+				if influxIds[event.ConnectionID] == nil {
+					errorBucket.add(fmt.Sprintf("event %s has an bad id %s", event.MetricName, event.ConnectionID))
+				} else {
+					influxIds[event.ConnectionID].count++
+					influxIds[event.ConnectionID].used = true
+				}
+			case "http":
+				if httpIds[event.ConnectionID] == nil {
+					errorBucket.add(fmt.Sprintf("event %s has an bad id %s", event.MetricName, event.ConnectionID))
+				} else {
+					httpIds[event.ConnectionID].count++
+					httpIds[event.ConnectionID].used = true
+				}
+			case "file":
+				if fileIds[event.ConnectionID] == nil {
+					errorBucket.add(fmt.Sprintf("event %s has an bad id %s", event.MetricName, event.ConnectionID))
+				} else {
+					fileIds[event.ConnectionID].count++
+					fileIds[event.ConnectionID].used = true
+					if fileFormats[event.ConnectionID] == "statsd" && event.Tags["metric_type"] == "" {
+						errorBucket.add(fmt.Sprintf("event %s writes statsd to a file and must have a tag metric_type.", event.MetricName))
 					}
-				case "influx":
+				}
+			case "annotation":
+				if event.ConnectionID != "" {
 					if influxIds[event.ConnectionID] == nil {
 						errorBucket.add(fmt.Sprintf("event %s has an bad id %s", event.MetricName, event.ConnectionID))
 					} else {
@@ -265,28 +732,60 @@ func validateEventLinks(s Story, errorBucket *ValidationError) {
 						influxIds[event.ConnectionID].used = true
 					}
 				}
+				if event.Annotation != nil && event.Annotation.GrafanaConnectionID != "" {
+					grafanaID := event.Annotation.GrafanaConnectionID
+					if grafanaIds[grafanaID] == nil {
+						errorBucket.add(fmt.Sprintf("event %s has an bad grafana id %s", event.MetricName, grafanaID))
+					} else {
+						grafanaIds[grafanaID].count++
+						grafanaIds[grafanaID].used = true
+					}
+				}
 			}
 		}
 	}
 
-	for id, links := range influxIds {
-		if !links.used {
-			errorBucket.add(fmt.Sprintf("influx connection %s has no events linked to it.", id))
-		}
-	}
-	for id, links := range statsdIds {
-		if !links.used {
-			errorBucket.add(fmt.Sprintf("statsd endpoint %s has no events linked to it.", id))
+	// A connection with no events is only a warning because it does no harm.
+	unused := func(kind string, ids map[string]*link) {
+		for id, links := range ids {
+			if !links.used {
+				jm := loggos.JSONWarnln("Connection has no events linked to it.")
+				jm.Add("type", kind)
+				jm.Add("id", id)
+				loggos.SendJSON(jm)
+			}
 		}
 	}
+	unused("influx", influxIds)
+	unused("statsd", statsdIds)
+	unused("grafana", grafanaIds)
+	unused("http", httpIds)
+	unused("file", fileIds)
 }
 
 func validateStory(s Story, errorBucket *ValidationError) {
 	if s.StoryName == "" {
 		errorBucket.add("story_name can not be blank")
 	}
+	if s.MaxDuration != "" {
+		if d, err := time.ParseDuration(s.MaxDuration); err != nil || d <= 0 {
+			errorBucket.add(fmt.Sprintf("max_duration %s is invalid. Use a positive duration like 30m.", s.MaxDuration))
+		}
+	}
+	if s.DrainTimeout != "" {
+		if d, err := time.ParseDuration(s.DrainTimeout); err != nil || d <= 0 {
+			errorBucket.add(fmt.Sprintf("drain_timeout %s is invalid. Use a positive duration like 30s.", s.DrainTimeout))
+		}
+	}
+	for _, name := range sortedProfileNames(s.DiurnalProfiles) {
+		if _, err := s.DiurnalProfiles[name].Profile(); err != nil {
+			errorBucket.add(fmt.Sprintf("diurnal profile %s is invalid. %s.", name, err))
+		}
+	}
 	// Check for duplicate connection IDs
 	validateNoDuplicateConnections(s, errorBucket)
+	// Check for duplicate timeline names
+	validateNoDuplicateTimelines(s, errorBucket)
 	// Check that the influx connections are valid
 	for _, i := range s.Influx {
 		validateInfluxConnection(*i, errorBucket)
@@ -295,12 +794,24 @@ func validateStory(s Story, errorBucket *ValidationError) {
 	for _, s := range s.StatsD {
 		validateStatsd(*s, errorBucket)
 	}
+	// Check that the grafana connections are valid
+	for _, g := range s.Grafana {
+		validateGrafanaConnection(*g, errorBucket)
+	}
+	// Check that the http connections are valid
+	for _, h := range s.HTTP {
+		validateHTTPConnection(*h, errorBucket)
+	}
+	// Check that the file connections are valid
+	for _, f := range s.File {
+		validateFileConnection(*f, errorBucket)
+	}
 	// check that the timelines and events are valid.
 	for _, t := range s.TimeLines {
 		validateTimeLine(*t, errorBucket)
 	}
+	// Check that every signal that is waited for is sent
+	validateSignals(s, errorBucket)
 	// Check that every event has a valid link to a connection
-	if len(errorBucket.errs) > 0 {
-		validateEventLinks(s, errorBucket)
-	}
+	validateEventLinks(s, errorBucket)
 }
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+const (
+	variablesKey = "variables"
+)
+
+var (
+	// variableReference matches {{ .name }}.
+	variableReference = regexp.MustCompile(`\{\{\s*\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+	// onlyVariableReference matches a string that is nothing but a variable reference.
+	onlyVariableReference = regexp.MustCompile(`^\s*\{\{\s*\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}\s*$`)
+	// requestTemplateNames are the fields of the data that http request templates are
+	// rendered with. References to them are left alone unless the story has a variable with
+	// the same name.
+	requestTemplateNames = map[string]bool{
+		"MetricName": true,
+		"Tags":       true,
+		"Fields":     true,
+		"Time":       true,
+	}
+)
+
+// storyVariables holds the variables defined in the configuration files and the
+// values set on the command line, which take priority over the defaults in the files.
+type storyVariables struct {
+	defaults  map[string]interface{}
+	sources   map[string]string
+	overrides map[string]string
+}
+
+func newStoryVariables(overrides map[string]string) *storyVariables {
+	if overrides == nil {
+		overrides = make(map[string]string)
+	}
+	return &storyVariables{
+		defaults:  make(map[string]interface{}),
+		sources:   make(map[string]string),
+		overrides: overrides,
+	}
+}
+
+// collect adds the variables defined in a configuration file. A variable can be defined in
+// more than one file but the defaults must be the same.
+func (sv *storyVariables) collect(tree map[string]interface{}, path string, errorBucket *ValidationError) {
+	variables, ok := tree[variablesKey].(map[string]interface{})
+	if !ok {
+		if _, exists := tree[variablesKey]; exists {
+			errorBucket.add(fmt.Sprintf("variables in %s must be a map of names to values.", path))
+		}
+		return
+	}
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := variables[name]
+		if existing, ok := sv.defaults[name]; ok {
+			if fmt.Sprint(existing) != fmt.Sprint(value) {
+				errorBucket.add(fmt.Sprintf("variable %s is set to %v in %s and %v in %s.", name, existing, sv.sources[name], value, path))
+			}
+			continue
+		}
+		sv.defaults[name] = value
+		sv.sources[name] = path
+	}
+}
+
+// checkOverrides makes sure that every variable set on the command line is used by the story.
+func (sv *storyVariables) checkOverrides(errorBucket *ValidationError) {
+	names := make([]string, 0, len(sv.overrides))
+	for name := range sv.overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := sv.defaults[name]; !ok {
+			errorBucket.add(fmt.Sprintf("variable %s is set on the command line but is not defined in the story.", name))
+		}
+	}
+}
+
+func (sv *storyVariables) lookup(name string) (interface{}, bool) {
+	if value, ok := sv.overrides[name]; ok {
+		return value, true
+	}
+	value, ok := sv.defaults[name]
+	return value, ok
+}
+
+// values returns the value of every variable after the command line has been applied.
+func (sv *storyVariables) values() map[string]interface{} {
+	values := make(map[string]interface{}, len(sv.defaults))
+	for name := range sv.defaults {
+		values[name], _ = sv.lookup(name)
+	}
+	return values
+}
+
+// substitute replaces {{ .name }} in every string with the value of the variable.
+// A string that is only a reference is replaced with the value itself so numbers and
+// bools keep their type. The variables section is not changed.
+func (sv *storyVariables) substitute(tree map[string]interface{}, errorBucket *ValidationError) {
+	missing := make(map[string]bool)
+	for key, item := range tree {
+		if key == variablesKey {
+			continue
+		}
+		tree[key] = sv.substituteValue(item, missing)
+	}
+	names := make([]string, 0, len(missing))
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		errorBucket.add(fmt.Sprintf("variable %s is used but not defined.", name))
+	}
+}
+
+func (sv *storyVariables) substituteValue(value interface{}, missing map[string]bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = sv.substituteValue(item, missing)
+		}
+		return v
+	case []interface{}:
+		for index, item := range v {
+			v[index] = sv.substituteValue(item, missing)
+		}
+		return v
+	case string:
+		return sv.substituteString(v, missing)
+	default:
+		return v
+	}
+}
+
+func (sv *storyVariables) substituteString(s string, missing map[string]bool) interface{} {
+	if parts := onlyVariableReference.FindStringSubmatch(s); parts != nil {
+		if value, ok := sv.lookup(parts[1]); ok {
+			return copyTree(value)
+		}
+	}
+	return variableReference.ReplaceAllStringFunc(s, func(reference string) string {
+		name := variableReference.FindStringSubmatch(reference)[1]
+		value, ok := sv.lookup(name)
+		if !ok {
+			if !requestTemplateNames[name] {
+				missing[name] = true
+			}
+			return reference
+		}
+		return variableString(value)
+	})
+}
+
+// variableString writes a variable as it should appear inside a longer string.
+func variableString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	case bool, int, int64, float64:
+		return toString(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
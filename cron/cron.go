@@ -0,0 +1,233 @@
+// Package cron works out when time slices that run on a wall clock schedule should start.
+//
+// Schedules use cron expressions with a seconds field:
+//
+//	second minute hour day-of-month month day-of-week
+//
+// The usual 5 field cron expressions are also accepted and start on the 0th second.
+// Each field can be *, a number, a range like 1-5, a list like 1,15,30 or a step like */15
+// or 0-30/10. Months and days of the week can also use their first 3 letters, like JAN or MON.
+// As with cron, if both the day of the month and day of the week are set then a day that
+// matches either of them is used.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// searchLimit is how far into the future Next looks for a matching time.
+	searchLimit = 5 * 366 * 24 * time.Hour
+)
+
+// field describes the values that a part of a cron expression can have.
+type field struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	secondField = field{name: "second", min: 0, max: 59}
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	dayField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}}
+	weekdayField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}}
+)
+
+// Schedule is a parsed cron expression. Each field is a set of bits, one for each value
+// that matches.
+type Schedule struct {
+	spec     string
+	second   uint64
+	minute   uint64
+	hour     uint64
+	day      uint64
+	month    uint64
+	weekday  uint64
+	anyDay   bool
+	anyWeek  bool
+	timezone *time.Location
+}
+
+// Parse reads a 6 field cron expression, or a 5 field one without seconds.
+func Parse(spec string) (*Schedule, error) {
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("schedule %q must have 6 fields: second minute hour day-of-month month day-of-week", spec)
+	}
+
+	s := &Schedule{spec: spec, timezone: time.Local}
+	var err error
+	if s.second, err = parseField(fields[0], secondField); err != nil {
+		return nil, fmt.Errorf("schedule %q is invalid. Error %s", spec, err)
+	}
+	if s.minute, err = parseField(fields[1], minuteField); err != nil {
+		return nil, fmt.Errorf("schedule %q is invalid. Error %s", spec, err)
+	}
+	if s.hour, err = parseField(fields[2], hourField); err != nil {
+		return nil, fmt.Errorf("schedule %q is invalid. Error %s", spec, err)
+	}
+	if s.day, err = parseField(fields[3], dayField); err != nil {
+		return nil, fmt.Errorf("schedule %q is invalid. Error %s", spec, err)
+	}
+	if s.month, err = parseField(fields[4], monthField); err != nil {
+		return nil, fmt.Errorf("schedule %q is invalid. Error %s", spec, err)
+	}
+	if s.weekday, err = parseField(fields[5], weekdayField); err != nil {
+		return nil, fmt.Errorf("schedule %q is invalid. Error %s", spec, err)
+	}
+	// 7 is also Sunday.
+	if s.weekday&(1<<7) != 0 {
+		s.weekday |= 1
+	}
+	s.anyDay = fields[3] == "*" || fields[3] == "?"
+	s.anyWeek = fields[5] == "*" || fields[5] == "?"
+	return s, nil
+}
+
+// ParseAt reads a time of day like "14:30" or "14:30:15" and returns a Schedule that
+// starts at that time every day.
+func ParseAt(at string) (*Schedule, error) {
+	parts := strings.Split(at, ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil, fmt.Errorf("at %q must be a time of day like 14:30 or 14:30:15", at)
+	}
+	limits := []field{hourField, minuteField, secondField}
+	values := []int{0, 0, 0}
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < limits[i].min || value > limits[i].max {
+			return nil, fmt.Errorf("at %q must be a time of day like 14:30 or 14:30:15", at)
+		}
+		values[i] = value
+	}
+	s, err := Parse(fmt.Sprintf("%d %d %d * * *", values[2], values[1], values[0]))
+	if err != nil {
+		return nil, err
+	}
+	s.spec = at
+	return s, nil
+}
+
+// In returns a copy of the schedule that uses the time zone given instead of the local time.
+func (s *Schedule) In(timezone *time.Location) *Schedule {
+	copied := *s
+	copied.timezone = timezone
+	return &copied
+}
+
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// Next returns the first time after the time given that matches the schedule. The zero
+// time is returned if nothing matches in the next 5 years, like the 30th of February.
+func (s *Schedule) Next(after time.Time) time.Time {
+	t := after.In(s.timezone).Truncate(time.Second).Add(time.Second)
+	limit := t.Add(searchLimit)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location())
+		case s.second&(1<<uint(t.Second())) == 0:
+			t = t.Add(time.Second)
+		default:
+			return t.In(after.Location())
+		}
+	}
+	return time.Time{}
+}
+
+// matchesDay checks the day of the month and day of the week. Like cron, when both are
+// set a day only needs to match one of them.
+func (s *Schedule) matchesDay(t time.Time) bool {
+	day := s.day&(1<<uint(t.Day())) != 0
+	weekday := s.weekday&(1<<uint(t.Weekday())) != 0
+	if s.anyDay || s.anyWeek {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// parseField turns a field of a cron expression into a set of bits.
+func parseField(spec string, f field) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(spec, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("%s step %s is not a positive number", f.name, part[i+1:])
+			}
+			part = part[:i]
+		}
+
+		low, high := f.min, f.max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if high, err = f.value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if high < low {
+				return 0, fmt.Errorf("%s range %s goes backwards", f.name, part)
+			}
+		default:
+			var err error
+			if low, err = f.value(part); err != nil {
+				return 0, err
+			}
+			// A single value with a step, like 5/15, runs from that value to the end.
+			high = low
+			if step > 1 {
+				high = f.max
+			}
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+// value reads a single number or name in a field.
+func (f field) value(s string) (int, error) {
+	if value, ok := f.names[strings.ToUpper(s)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s %s is not a number", f.name, s)
+	}
+	if value < f.min || value > f.max {
+		return 0, fmt.Errorf("%s %d must be between %d and %d", f.name, value, f.min, f.max)
+	}
+	return value, nil
+}
@@ -0,0 +1,67 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	start := time.Date(2024, time.March, 15, 14, 7, 30, 500, time.UTC)
+	tests := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"0 */15 * * * *", time.Date(2024, time.March, 15, 14, 15, 0, 0, time.UTC)},
+		{"*/10 * * * * *", time.Date(2024, time.March, 15, 14, 7, 40, 0, time.UTC)},
+		{"0 0 2 * * *", time.Date(2024, time.March, 16, 2, 0, 0, 0, time.UTC)},
+		{"30 0 9 * * MON-FRI", time.Date(2024, time.March, 18, 9, 0, 30, 0, time.UTC)},
+		{"0 0 0 1 JAN *", time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 12 29 2 *", time.Date(2028, time.February, 29, 12, 0, 0, 0, time.UTC)},
+		{"0 0 1,13 * * 7", time.Date(2024, time.March, 17, 1, 0, 0, 0, time.UTC)},
+		{"0 0 * * *", time.Date(2024, time.March, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 0 30 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		schedule, err := Parse(test.spec)
+		if err != nil {
+			t.Logf("Failed to parse %q. Error: %s", test.spec, err)
+			t.Fail()
+			continue
+		}
+		next := schedule.In(time.UTC).Next(start)
+		if !next.Equal(test.expected) {
+			t.Logf("%q should next run at %s. Got: %s", test.spec, test.expected, next)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseAt(t *testing.T) {
+	start := time.Date(2024, time.March, 15, 14, 30, 0, 0, time.UTC)
+	schedule, err := ParseAt("14:30")
+	if err != nil {
+		t.Logf("Failed to parse 14:30. Error: %s", err)
+		t.FailNow()
+	}
+	expected := time.Date(2024, time.March, 16, 14, 30, 0, 0, time.UTC)
+	if next := schedule.In(time.UTC).Next(start); !next.Equal(expected) {
+		t.Logf("14:30 should next run at %s. Got: %s", expected, next)
+		t.Fail()
+	}
+
+	for _, at := range []string{"24:00", "14", "14:30:60", "two"} {
+		if _, err := ParseAt(at); err == nil {
+			t.Logf("Expected an error for at %q.", at)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{"* * * *", "60 * * * * *", "* * * 0 * *", "*/0 * * * * *", "* * 5-1 * * *", "* * * * FOO *"} {
+		if _, err := Parse(spec); err == nil {
+			t.Logf("Expected an error for %q.", spec)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Package expression parses and evaluates the small expressions used to work out field values.
+//
+// Expressions are made of numbers, "strings", names and function calls joined with the
+// operators below, from the lowest to the highest precedence:
+//
+//	||
+//	&&
+//	== != < <= > >=
+//	+ -
+//	* / %
+//	unary - and !
+//	^
+//
+// Comparisons and logic give 1 for true and 0 for false. pi is a constant. Other names
+// and the latest function are looked up in the Environment given to Evaluate.
+package expression
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Environment gives an expression the values of the names it uses. Variable returns a
+// float64 or a string. Latest returns the last value a metric sent for a field.
+type Environment interface {
+	Variable(name string) (interface{}, bool)
+	Latest(metricName, field string) (float64, bool)
+}
+
+// Expression is a parsed expression that can be evaluated many times.
+type Expression struct {
+	source string
+	root   node
+	names  []string
+}
+
+// Parse reads an expression.
+func Parse(source string) (*Expression, error) {
+	p := &parser{lexer: newLexer(source)}
+	if err := p.advance(); err != nil {
+		return nil, fmt.Errorf("expression %q is invalid. Error %s", source, err)
+	}
+	root, err := p.parseExpression()
+	if err != nil {
+		return nil, fmt.Errorf("expression %q is invalid. Error %s", source, err)
+	}
+	if p.token.kind != tokenEnd {
+		return nil, fmt.Errorf("expression %q is invalid. Error unexpected %s", source, p.token)
+	}
+
+	e := &Expression{source: source, root: root}
+	seen := make(map[string]bool)
+	walk(root, func(n node) {
+		if v, ok := n.(*variableNode); ok && !seen[v.name] {
+			seen[v.name] = true
+			e.names = append(e.names, v.name)
+		}
+	})
+	sort.Strings(e.names)
+	return e, nil
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Names returns the sorted names of the variables used by the expression.
+func (e *Expression) Names() []string {
+	return e.names
+}
+
+// Evaluate works out the value of the expression. The result must be a number.
+func (e *Expression) Evaluate(env Environment) (float64, error) {
+	value, err := e.root.eval(env)
+	if err != nil {
+		return 0, fmt.Errorf("expression %q failed. Error %s", e.source, err)
+	}
+	number, ok := value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("expression %q gave %q which is not a number", e.source, value)
+	}
+	return number, nil
+}
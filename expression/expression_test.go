@@ -0,0 +1,110 @@
+package expression
+
+import (
+	"math"
+	"testing"
+)
+
+// testEnvironment has a few variables and a single latest value.
+type testEnvironment map[string]interface{}
+
+func (te testEnvironment) Variable(name string) (interface{}, bool) {
+	value, ok := te[name]
+	return value, ok
+}
+
+func (te testEnvironment) Latest(metricName, field string) (float64, bool) {
+	if metricName == "api_requests" && field == "count" {
+		return 250, true
+	}
+	return 0, false
+}
+
+func TestEvaluate(t *testing.T) {
+	env := testEnvironment{"requests": 200.0, "error_rate": 0.05, "state": "outage"}
+	tests := map[string]float64{
+		"1 + 2 * 3":                                7,
+		"(1 + 2) * 3":                              9,
+		"-2 ^ 2":                                   -4,
+		"2 ^ 3 ^ 2":                                512,
+		"10 % 4":                                   2,
+		"requests * error_rate":                    10,
+		"1.5e2 + .5":                               150.5,
+		"max(1, 7, 3) - min(4, 2)":                 5,
+		"clamp(150, 0, 100)":                       100,
+		"requests > 100 && error_rate < 0.1":       1,
+		"!(requests == 200) || 0":                  0,
+		`if(state == "outage", 500, 100)`:          500,
+		`if(state != 'outage', 500, 100)`:          100,
+		`if(state == "up", 1 / 0, 2)`:              2,
+		"if(0, requests / 0, 1)":                   1,
+		`latest("api_requests", "count") / 10`:     25,
+		`latest("missing", "count")`:               0,
+		`latest("missing", "count", requests + 1)`: 201,
+		"100 + 20 * sin(pi / 2)":                   120,
+		"cos(0) + tan(0)":                          1,
+	}
+	for source, expected := range tests {
+		e, err := Parse(source)
+		if err != nil {
+			t.Logf("Failed to parse %s. Error: %s", source, err)
+			t.Fail()
+			continue
+		}
+		value, err := e.Evaluate(env)
+		if err != nil || math.Abs(value-expected) > 1e-9 {
+			t.Logf("%s should be %v. Got: %v, Error: %v", source, expected, value, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestRandomFunctions(t *testing.T) {
+	env := testEnvironment{}
+	uniform, _ := Parse("uniform(10, 20)")
+	normal, _ := Parse("normal(100, 5)")
+	total := 0.0
+	for i := 0; i < 1000; i++ {
+		value, err := uniform.Evaluate(env)
+		if err != nil || value < 10 || value >= 20 {
+			t.Logf("uniform(10, 20) gave %v. Error: %v", value, err)
+			t.FailNow()
+		}
+		value, _ = normal.Evaluate(env)
+		total += value
+	}
+	if mean := total / 1000; mean < 99 || mean > 101 {
+		t.Logf("normal(100, 5) should average about 100. Got: %v", mean)
+		t.Fail()
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, source := range []string{"1 +", "(1 + 2", "nope(1)", "max()", "1 2", `"open`, "2 $ 3"} {
+		if _, err := Parse(source); err == nil {
+			t.Logf("Expected an error parsing %s.", source)
+			t.Fail()
+		}
+	}
+	for _, source := range []string{"1 / 0", "missing + 1", `"text" + 1`, `"text"`} {
+		e, err := Parse(source)
+		if err != nil {
+			t.Logf("Failed to parse %s. Error: %s", source, err)
+			t.Fail()
+			continue
+		}
+		if _, err := e.Evaluate(testEnvironment{}); err == nil {
+			t.Logf("Expected an error evaluating %s.", source)
+			t.Fail()
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	e, _ := Parse(`latency_p50 * 3 + normal(0, noise) + latency_p50 + latest("a", "b")`)
+	names := e.Names()
+	if len(names) != 2 || names[0] != "latency_p50" || names[1] != "noise" {
+		t.Logf("Expected latency_p50 and noise. Got: %v", names)
+		t.Fail()
+	}
+}
@@ -0,0 +1,121 @@
+package expression
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// function is a function that can be called in an expression. maxArgs is -1 if it takes
+// any number of arguments.
+type function struct {
+	minArgs int
+	maxArgs int
+	call    func(env Environment, args []interface{}) (interface{}, error)
+}
+
+func (f function) arguments() string {
+	switch {
+	case f.minArgs == f.maxArgs:
+		return fmt.Sprintf("%d arguments", f.minArgs)
+	case f.maxArgs < 0:
+		return fmt.Sprintf("at least %d arguments", f.minArgs)
+	}
+	return fmt.Sprintf("%d to %d arguments", f.minArgs, f.maxArgs)
+}
+
+// numeric wraps a function that only works with numbers.
+func numeric(minArgs, maxArgs int, f func(args []float64) (float64, error)) function {
+	return function{
+		minArgs: minArgs,
+		maxArgs: maxArgs,
+		call: func(env Environment, args []interface{}) (interface{}, error) {
+			numbers := make([]float64, len(args))
+			for i, arg := range args {
+				number, err := toNumber(arg)
+				if err != nil {
+					return nil, err
+				}
+				numbers[i] = number
+			}
+			return f(numbers)
+		},
+	}
+}
+
+// math1 wraps a math function that takes a single number.
+func math1(f func(float64) float64) function {
+	return numeric(1, 1, func(args []float64) (float64, error) {
+		return f(args[0]), nil
+	})
+}
+
+// ifFunction is parsed into an ifNode rather than called so that only the branch it
+// picks is worked out.
+const ifFunction = "if"
+
+// constants are names with a fixed value. They can't be used as variable names.
+var constants = map[string]float64{
+	"pi": math.Pi,
+}
+
+// functions are the functions that expressions can call.
+var functions = map[string]function{
+	"abs":   math1(math.Abs),
+	"floor": math1(math.Floor),
+	"ceil":  math1(math.Ceil),
+	"round": math1(math.Round),
+	"sqrt":  math1(math.Sqrt),
+	"exp":   math1(math.Exp),
+	"log":   math1(math.Log),
+	"sin":   math1(math.Sin),
+	"cos":   math1(math.Cos),
+	"tan":   math1(math.Tan),
+	"pow": numeric(2, 2, func(args []float64) (float64, error) {
+		return math.Pow(args[0], args[1]), nil
+	}),
+	"min": numeric(1, -1, func(args []float64) (float64, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Min(result, arg)
+		}
+		return result, nil
+	}),
+	"max": numeric(1, -1, func(args []float64) (float64, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Max(result, arg)
+		}
+		return result, nil
+	}),
+	"clamp": numeric(3, 3, func(args []float64) (float64, error) {
+		return math.Max(args[1], math.Min(args[2], args[0])), nil
+	}),
+	"random": numeric(0, 0, func(args []float64) (float64, error) {
+		return rand.Float64(), nil
+	}),
+	"uniform": numeric(2, 2, func(args []float64) (float64, error) {
+		return args[0] + rand.Float64()*(args[1]-args[0]), nil
+	}),
+	"normal": numeric(2, 2, func(args []float64) (float64, error) {
+		return args[0] + rand.NormFloat64()*args[1], nil
+	}),
+	"latest": {
+		minArgs: 2,
+		maxArgs: 3,
+		call: func(env Environment, args []interface{}) (interface{}, error) {
+			metricName, ok := args[0].(string)
+			field, fieldOK := args[1].(string)
+			if !ok || !fieldOK {
+				return nil, fmt.Errorf("the metric name and field must be text")
+			}
+			if value, ok := env.Latest(metricName, field); ok {
+				return value, nil
+			}
+			if len(args) == 3 {
+				return toNumber(args[2])
+			}
+			return 0.0, nil
+		},
+	},
+}
@@ -0,0 +1,114 @@
+package expression
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenName
+	tokenOperator
+	tokenOpen
+	tokenClose
+	tokenComma
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	number float64
+}
+
+func (t token) String() string {
+	if t.kind == tokenEnd {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// operators are checked in order so the longer ones come first.
+var operators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "%", "^", "!"}
+
+type lexer struct {
+	source string
+	pos    int
+}
+
+func newLexer(source string) *lexer {
+	return &lexer{source: source}
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.source) && unicode.IsSpace(rune(l.source[l.pos])) {
+		l.pos++
+	}
+	if l.pos >= len(l.source) {
+		return token{kind: tokenEnd}, nil
+	}
+
+	rest := l.source[l.pos:]
+	c := rest[0]
+	switch {
+	case c == '(':
+		l.pos++
+		return token{kind: tokenOpen, text: "("}, nil
+	case c == ')':
+		l.pos++
+		return token{kind: tokenClose, text: ")"}, nil
+	case c == ',':
+		l.pos++
+		return token{kind: tokenComma, text: ","}, nil
+	case c == '"' || c == '\'':
+		end := strings.IndexByte(rest[1:], c)
+		if end < 0 {
+			return token{}, fmt.Errorf("string %s is not closed", rest)
+		}
+		l.pos += end + 2
+		return token{kind: tokenString, text: rest[1 : end+1]}, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		end := 0
+		for end < len(rest) && (rest[end] == '.' || (rest[end] >= '0' && rest[end] <= '9')) {
+			end++
+		}
+		// Allow exponents like 1e6 and 2.5e-3.
+		if end < len(rest) && (rest[end] == 'e' || rest[end] == 'E') {
+			exp := end + 1
+			if exp < len(rest) && (rest[exp] == '+' || rest[exp] == '-') {
+				exp++
+			}
+			if exp < len(rest) && rest[exp] >= '0' && rest[exp] <= '9' {
+				end = exp
+				for end < len(rest) && rest[end] >= '0' && rest[end] <= '9' {
+					end++
+				}
+			}
+		}
+		number, err := strconv.ParseFloat(rest[:end], 64)
+		if err != nil {
+			return token{}, fmt.Errorf("%s is not a number", rest[:end])
+		}
+		l.pos += end
+		return token{kind: tokenNumber, text: rest[:end], number: number}, nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		end := 0
+		for end < len(rest) && (rest[end] == '_' || unicode.IsLetter(rune(rest[end])) || unicode.IsDigit(rune(rest[end]))) {
+			end++
+		}
+		l.pos += end
+		return token{kind: tokenName, text: rest[:end]}, nil
+	}
+	for _, operator := range operators {
+		if strings.HasPrefix(rest, operator) {
+			l.pos += len(operator)
+			return token{kind: tokenOperator, text: operator}, nil
+		}
+	}
+	return token{}, fmt.Errorf("unexpected %q", string(c))
+}
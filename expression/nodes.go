@@ -0,0 +1,199 @@
+package expression
+
+import (
+	"fmt"
+	"math"
+)
+
+type node interface {
+	eval(env Environment) (interface{}, error)
+}
+
+// walk calls f for n and every node under it.
+func walk(n node, f func(node)) {
+	f(n)
+	switch v := n.(type) {
+	case *unaryNode:
+		walk(v.operand, f)
+	case *binaryNode:
+		walk(v.left, f)
+		walk(v.right, f)
+	case *callNode:
+		for _, arg := range v.args {
+			walk(arg, f)
+		}
+	case *ifNode:
+		walk(v.condition, f)
+		walk(v.then, f)
+		walk(v.otherwise, f)
+	}
+}
+
+type constantNode struct {
+	value interface{}
+}
+
+func (n *constantNode) eval(env Environment) (interface{}, error) {
+	return n.value, nil
+}
+
+type variableNode struct {
+	name string
+}
+
+func (n *variableNode) eval(env Environment) (interface{}, error) {
+	value, ok := env.Variable(n.name)
+	if !ok {
+		return nil, fmt.Errorf("%s is not defined", n.name)
+	}
+	return value, nil
+}
+
+type unaryNode struct {
+	operator string
+	operand  node
+}
+
+func (n *unaryNode) eval(env Environment) (interface{}, error) {
+	value, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	number, err := toNumber(value)
+	if err != nil {
+		return nil, err
+	}
+	if n.operator == "!" {
+		return boolToNumber(number == 0), nil
+	}
+	return -number, nil
+}
+
+type binaryNode struct {
+	operator string
+	left     node
+	right    node
+}
+
+func (n *binaryNode) eval(env Environment) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Strings can only be compared with each other.
+	leftText, leftIsText := left.(string)
+	rightText, rightIsText := right.(string)
+	if leftIsText || rightIsText {
+		switch {
+		case n.operator == "==":
+			return boolToNumber(leftIsText && rightIsText && leftText == rightText), nil
+		case n.operator == "!=":
+			return boolToNumber(!leftIsText || !rightIsText || leftText != rightText), nil
+		}
+		return nil, fmt.Errorf("%s can not be used with text", n.operator)
+	}
+
+	a, b := left.(float64), right.(float64)
+	switch n.operator {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return a / b, nil
+	case "%":
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(a, b), nil
+	case "^":
+		return math.Pow(a, b), nil
+	case "==":
+		return boolToNumber(a == b), nil
+	case "!=":
+		return boolToNumber(a != b), nil
+	case "<":
+		return boolToNumber(a < b), nil
+	case "<=":
+		return boolToNumber(a <= b), nil
+	case ">":
+		return boolToNumber(a > b), nil
+	case ">=":
+		return boolToNumber(a >= b), nil
+	case "&&":
+		return boolToNumber(a != 0 && b != 0), nil
+	case "||":
+		return boolToNumber(a != 0 || b != 0), nil
+	}
+	return nil, fmt.Errorf("%s is not a known operator", n.operator)
+}
+
+type callNode struct {
+	name     string
+	function function
+	args     []node
+}
+
+func (n *callNode) eval(env Environment) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	value, err := n.function.call(env, args)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed. %s", n.name, err)
+	}
+	return value, nil
+}
+
+// ifNode only works out the branch that the condition picks so that the other branch
+// can be one that fails, such as a division by zero.
+type ifNode struct {
+	condition node
+	then      node
+	otherwise node
+}
+
+func (n *ifNode) eval(env Environment) (interface{}, error) {
+	value, err := n.condition.eval(env)
+	if err != nil {
+		return nil, fmt.Errorf("if failed. %s", err)
+	}
+	condition, err := toNumber(value)
+	if err != nil {
+		return nil, fmt.Errorf("if failed. %s", err)
+	}
+	if condition != 0 {
+		return n.then.eval(env)
+	}
+	return n.otherwise.eval(env)
+}
+
+func toNumber(value interface{}) (float64, error) {
+	number, ok := value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("%q is not a number", value)
+	}
+	return number, nil
+}
+
+func boolToNumber(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
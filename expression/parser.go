@@ -0,0 +1,171 @@
+package expression
+
+import (
+	"fmt"
+)
+
+// binaryLevels are the binary operators from the lowest to the highest precedence.
+// ^ is handled on its own as it binds to the right.
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+type parser struct {
+	lexer *lexer
+	token token
+}
+
+func (p *parser) advance() error {
+	t, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.token = t
+	return nil
+}
+
+func (p *parser) isOperator(operators ...string) bool {
+	if p.token.kind != tokenOperator {
+		return false
+	}
+	for _, operator := range operators {
+		if p.token.text == operator {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) parseExpression() (node, error) {
+	return p.parseBinary(0)
+}
+
+func (p *parser) parseBinary(level int) (node, error) {
+	if level == len(binaryLevels) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator(binaryLevels[level]...) {
+		operator := p.token.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{operator: operator, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOperator("-", "!") {
+		operator := p.token.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{operator: operator, operand: operand}, nil
+	}
+	return p.parsePower()
+}
+
+func (p *parser) parsePower() (node, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOperator("^") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		exponent, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{operator: "^", left: base, right: exponent}, nil
+	}
+	return base, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.token
+	switch t.kind {
+	case tokenNumber:
+		return &constantNode{value: t.number}, p.advance()
+	case tokenString:
+		return &constantNode{value: t.text}, p.advance()
+	case tokenOpen:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		inner, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if p.token.kind != tokenClose {
+			return nil, fmt.Errorf("expected ) but found %s", p.token)
+		}
+		return inner, p.advance()
+	case tokenName:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.token.kind != tokenOpen {
+			if value, ok := constants[t.text]; ok {
+				return &constantNode{value: value}, nil
+			}
+			return &variableNode{name: t.text}, nil
+		}
+		return p.parseCall(t.text)
+	}
+	return nil, fmt.Errorf("unexpected %s", t)
+}
+
+func (p *parser) parseCall(name string) (node, error) {
+	f, ok := functions[name]
+	if !ok && name != ifFunction {
+		return nil, fmt.Errorf("%s is not a known function", name)
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	args := []node{}
+	for p.token.kind != tokenClose {
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.token.kind == tokenComma {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if p.token.kind != tokenClose {
+			return nil, fmt.Errorf("expected , or ) but found %s", p.token)
+		}
+	}
+	if name == ifFunction {
+		if len(args) != 3 {
+			return nil, fmt.Errorf("if takes 3 arguments")
+		}
+		return &ifNode{condition: args[0], then: args[1], otherwise: args[2]}, p.advance()
+	}
+	if len(args) < f.minArgs || (f.maxArgs >= 0 && len(args) > f.maxArgs) {
+		return nil, fmt.Errorf("%s takes %s", name, f.arguments())
+	}
+	return &callNode{name: name, function: f, args: args}, p.advance()
+}
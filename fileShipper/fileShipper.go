@@ -0,0 +1,169 @@
+// Package fileShipper writes metrics to a file or to stdout.
+//
+// Each metric is written on its own line exactly as it was given to the shipper. This
+// makes it useful for dry running a story and diffing the output.
+//
+// Files can be rotated once they reach a maximum size in bytes. The current file is moved
+// to path.1, path.1 is moved to path.2 and so on. If a maximum number of backups is set
+// the oldest backups are removed.
+//
+// To start the shipper first, create a new shipper, call Connect() to open the file, call
+// Start() to signal that metrics can be written. Use Ship(metric string) to submit metrics.
+// Call Stop() to drain the queue, once finished the StopChan will receive a true.
+package fileShipper
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/silverstagtech/loggos"
+)
+
+const (
+	// Stdout is the path used to write to stdout rather than a file.
+	Stdout = "-"
+)
+
+// FileShipper will write metrics to the path given.
+type FileShipper struct {
+	id         string
+	path       string
+	maxSize    int64
+	maxBackups int
+	size       int64
+	writer     io.Writer
+	file       *os.File
+	queue      chan string
+	StopChan   chan bool
+	stopped    bool
+	finished   bool
+}
+
+// New will return a pointer to a FileShipper. You will need to call connect on it to open
+// the file. A maxSize of 0 will never rotate the file. A maxBackups of 0 keeps all backups.
+func New(id, path string, maxSize int64, maxBackups int) *FileShipper {
+	return &FileShipper{
+		id:         id,
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		queue:      make(chan string, 1000),
+		StopChan:   make(chan bool, 1),
+	}
+}
+
+// Connect will open the file, truncating anything that is already in it.
+func (fs *FileShipper) Connect() error {
+	if fs.path == Stdout {
+		fs.writer = os.Stdout
+		return nil
+	}
+	return fs.open()
+}
+
+func (fs *FileShipper) open() error {
+	file, err := os.Create(fs.path)
+	if err != nil {
+		return fmt.Errorf("Failed to open file %s. Error: %s", fs.path, err)
+	}
+	fs.file = file
+	fs.writer = file
+	fs.size = 0
+	return nil
+}
+
+func (fs *FileShipper) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", fs.path, n)
+}
+
+// rotate moves the current file and its backups along by one and opens a new file.
+func (fs *FileShipper) rotate() error {
+	if err := fs.file.Close(); err != nil {
+		return err
+	}
+
+	// Find the oldest backup, then move them all along by one starting with the oldest.
+	oldest := 0
+	for {
+		if _, err := os.Stat(fs.backupPath(oldest + 1)); err != nil {
+			break
+		}
+		oldest++
+	}
+	for n := oldest; n > 0; n-- {
+		if fs.maxBackups > 0 && n >= fs.maxBackups {
+			if err := os.Remove(fs.backupPath(n)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Rename(fs.backupPath(n), fs.backupPath(n+1)); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(fs.path, fs.backupPath(1)); err != nil {
+		return err
+	}
+	return fs.open()
+}
+
+func (fs *FileShipper) write(metric string) error {
+	line := metric + "\n"
+	if fs.file != nil && fs.maxSize > 0 && fs.size > 0 && fs.size+int64(len(line)) > fs.maxSize {
+		if err := fs.rotate(); err != nil {
+			return fmt.Errorf("Failed to rotate file %s. Error: %s", fs.path, err)
+		}
+	}
+	n, err := io.WriteString(fs.writer, line)
+	fs.size += int64(n)
+	return err
+}
+
+// Start signals that the shipper should start writing metrics.
+func (fs *FileShipper) Start() {
+	go func() {
+		for metric := range fs.queue {
+			if err := fs.write(metric); err != nil {
+				jm := loggos.JSONCritln("Failed to write metric to file.")
+				jm.Add("id", fs.id)
+				jm.Add("shipper_type", "File")
+				jm.Add("path", fs.path)
+				jm.Error(err)
+				loggos.SendJSON(jm)
+			}
+		}
+		if fs.file != nil {
+			if err := fs.file.Close(); err != nil {
+				jm := loggos.JSONWarnln("File connection had an error closing.")
+				jm.Add("id", fs.id)
+				jm.Add("path", fs.path)
+				jm.Error(err)
+				loggos.SendJSON(jm)
+			}
+		}
+		fs.finished = true
+		fs.StopChan <- true
+	}()
+}
+
+// Ship queues a metric to be written.
+func (fs *FileShipper) Ship(metric string) error {
+	if fs.stopped {
+		return fmt.Errorf("input is closed")
+	}
+	fs.queue <- metric
+	return nil
+}
+
+// Stop will stop the shipper taking new metrics. Once the queued metrics have been
+// written and the file closed the StopChan will get a true.
+func (fs *FileShipper) Stop() {
+	fs.stopped = true
+	close(fs.queue)
+}
+
+// Finished will signal if the shipper is finished writing all the metrics given to it.
+func (fs *FileShipper) Finished() bool {
+	return fs.finished
+}
@@ -0,0 +1,88 @@
+package fileShipper
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "fileShipper")
+	if err != nil {
+		t.Logf("Failed to create a temp dir. Error: %s", err)
+		t.FailNow()
+	}
+	return dir
+}
+
+func readFile(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Logf("Failed to read %s. Error: %s", path, err)
+		t.FailNow()
+	}
+	return string(b)
+}
+
+func TestWriteMetrics(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.txt")
+
+	fs := New("test", path, 0, 0)
+	if err := fs.Connect(); err != nil {
+		t.Logf("Failed to connect. Error: %s", err)
+		t.FailNow()
+	}
+	fs.Start()
+	fs.Ship("metric1 value=1")
+	fs.Ship("metric2 value=2")
+	fs.Stop()
+	<-fs.StopChan
+
+	expected := "metric1 value=1\nmetric2 value=2\n"
+	if got := readFile(t, path); got != expected {
+		t.Logf("TestWriteMetrics got unexpected output.\nExpected: %q\nGot: %q", expected, got)
+		t.Fail()
+	}
+	if err := fs.Ship("late"); err == nil {
+		t.Logf("TestWriteMetrics expected an error shipping after stopping.")
+		t.Fail()
+	}
+}
+
+func TestRotation(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.txt")
+
+	// Each line is 4 bytes so every file will hold 2 lines.
+	fs := New("test", path, 8, 2)
+	if err := fs.Connect(); err != nil {
+		t.Logf("Failed to connect. Error: %s", err)
+		t.FailNow()
+	}
+	fs.Start()
+	for _, metric := range []string{"m_1", "m_2", "m_3", "m_4", "m_5", "m_6", "m_7"} {
+		fs.Ship(metric)
+	}
+	fs.Stop()
+	<-fs.StopChan
+
+	expected := map[string]string{
+		path:        "m_7\n",
+		path + ".1": "m_5\nm_6\n",
+		path + ".2": "m_3\nm_4\n",
+	}
+	for file, content := range expected {
+		if got := readFile(t, file); got != content {
+			t.Logf("TestRotation %s has unexpected content.\nExpected: %q\nGot: %q", file, content, got)
+			t.Fail()
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Logf("TestRotation kept more backups than allowed.")
+		t.Fail()
+	}
+}
@@ -3,6 +3,8 @@ module github.com/silverstagtech/teller
 go 1.12
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/morfien101/influxLineProtocolOutput v0.0.0-20180103121825-607c6b3a96f5
 	github.com/silverstagtech/loggos v0.3.0
+	gopkg.in/yaml.v2 v2.4.0
 )
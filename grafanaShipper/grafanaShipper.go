@@ -0,0 +1,181 @@
+// Package grafanaShipper writes annotations to Grafana using the HTTP API.
+//
+// Annotations are posted to /api/annotations one at a time as they are sent to the
+// shipper. Authentication can be done with an API key, which is sent as a bearer token,
+// or with a username and password which are sent as basic auth.
+//
+// To start the shipper first, create a new shipper, call Connect() to make sure that
+// Grafana is reachable, call Start() to signal that annotations can be shipped.
+// Use Ship(annotation) to submit annotations. Call Stop() to drain the queue, once
+// finished the StopChan will receive a true.
+package grafanaShipper
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/silverstagtech/loggos"
+)
+
+// Annotation is the body that is sent to the Grafana annotations API.
+// Time is the epoch time in milliseconds.
+type Annotation struct {
+	DashboardID int      `json:"dashboardId,omitempty"`
+	PanelID     int      `json:"panelId,omitempty"`
+	Time        int64    `json:"time"`
+	Tags        []string `json:"tags"`
+	Text        string   `json:"text"`
+}
+
+// GrafanaShipper will ship annotations to the Grafana server given.
+type GrafanaShipper struct {
+	id          string
+	address     string
+	apiKey      string
+	username    string
+	password    string
+	httpTimeout time.Duration
+	httpClient  *http.Client
+	queue       chan *Annotation
+	StopChan    chan bool
+	stopped     bool
+	finished    bool
+}
+
+// New will return a pointer to a GrafanaShipper. You will need to call connect on it to get it
+// ready to start sending annotations. httpTimeout is in seconds.
+func New(id, address, apiKey, username, password string, httpTimeout int) *GrafanaShipper {
+	return &GrafanaShipper{
+		id:          id,
+		address:     address,
+		apiKey:      apiKey,
+		username:    username,
+		password:    password,
+		httpTimeout: time.Duration(httpTimeout) * time.Second,
+		queue:       make(chan *Annotation, 1000),
+		StopChan:    make(chan bool, 1),
+	}
+}
+
+func (gs *GrafanaShipper) healthURL() string {
+	return fmt.Sprintf("%s/api/health", gs.address)
+}
+
+func (gs *GrafanaShipper) annotationsURL() string {
+	return fmt.Sprintf("%s/api/annotations", gs.address)
+}
+
+func (gs *GrafanaShipper) createHTTPClient() {
+	gs.httpClient = &http.Client{
+		Transport: &http.Transport{
+			Dial: (&net.Dialer{
+				Timeout: gs.httpTimeout,
+			}).Dial,
+			TLSHandshakeTimeout: gs.httpTimeout,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		},
+		Timeout: gs.httpTimeout,
+	}
+}
+
+func (gs *GrafanaShipper) setCreds(req *http.Request) {
+	if gs.apiKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", gs.apiKey))
+		return
+	}
+	if gs.username != "" && gs.password != "" {
+		req.SetBasicAuth(gs.username, gs.password)
+	}
+}
+
+// Connect will check that Grafana is healthy using the health API.
+func (gs *GrafanaShipper) Connect() error {
+	gs.createHTTPClient()
+	req, err := http.NewRequest("GET", gs.healthURL(), nil)
+	if err != nil {
+		return fmt.Errorf("Failed to make request for Grafana health. Error: %s", err)
+	}
+	gs.setCreds(req)
+	resp, err := gs.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to check Grafana health. Error: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("Got a bad status code from Grafana. Code: %d", resp.StatusCode)
+	}
+	jm := loggos.JSONInfoln("Successful health check on Grafana")
+	jm.Add("id", gs.id)
+	jm.Add("shipper_type", "Grafana")
+	loggos.SendJSON(jm)
+	return nil
+}
+
+// Start signals that the shipper should start sending annotations.
+func (gs *GrafanaShipper) Start() {
+	go func() {
+		for annotation := range gs.queue {
+			if err := gs.send(annotation); err != nil {
+				jm := loggos.JSONCritln("Failed to write annotation to Grafana.")
+				jm.Add("id", gs.id)
+				jm.Add("shipper_type", "Grafana")
+				jm.Error(err)
+				loggos.SendJSON(jm)
+			}
+		}
+		gs.finished = true
+		gs.StopChan <- true
+	}()
+}
+
+func (gs *GrafanaShipper) send(annotation *Annotation) error {
+	body, err := json.Marshal(annotation)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", gs.annotationsURL(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	gs.setCreds(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := gs.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("Got a bad status code from Grafana. Code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Ship queues an annotation to be sent to Grafana.
+func (gs *GrafanaShipper) Ship(annotation *Annotation) error {
+	if gs.stopped {
+		return fmt.Errorf("input is closed")
+	}
+	gs.queue <- annotation
+	return nil
+}
+
+// Stop will stop the shipper taking new annotations. Once the queued annotations have been
+// sent the StopChan will get a true.
+func (gs *GrafanaShipper) Stop() {
+	gs.stopped = true
+	close(gs.queue)
+}
+
+// Finished will signal if the shipper is finished sending all the annotations given to it.
+func (gs *GrafanaShipper) Finished() bool {
+	return gs.finished
+}
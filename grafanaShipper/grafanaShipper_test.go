@@ -0,0 +1,103 @@
+package grafanaShipper
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/silverstagtech/loggos"
+)
+
+func setupLogger() {
+	loggos.JSONLoggerEnableDebugLogging(true)
+	loggos.JSONLoggerEnablePrettyPrint(true)
+	loggos.JSONLoggerEnableHumanTimestamps(true)
+}
+
+func TestGoodConnect(t *testing.T) {
+	setupLogger()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/health" {
+			w.WriteHeader(404)
+			return
+		}
+		w.WriteHeader(200)
+	})
+	testServers := []*httptest.Server{httptest.NewServer(handler), httptest.NewTLSServer(handler)}
+
+	for _, server := range testServers {
+		gs := New("test", server.URL, "key", "", "", 1)
+		if err := gs.Connect(); err != nil {
+			t.Logf("TestGoodConnect failed to connect to %s. Error: %s", server.URL, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestBadConnect(t *testing.T) {
+	setupLogger()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	})
+	server := httptest.NewServer(handler)
+
+	gs := New("test", server.URL, "key", "", "", 1)
+	if err := gs.Connect(); err == nil {
+		t.Logf("TestBadConnect did not get an error from an unhealthy Grafana.")
+		t.Fail()
+	}
+}
+
+func TestShipAnnotation(t *testing.T) {
+	setupLogger()
+	received := make(chan *Annotation, 1)
+	authHeaders := make(chan string, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/annotations" && r.Method == "POST" {
+			a := new(Annotation)
+			if err := json.NewDecoder(r.Body).Decode(a); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			authHeaders <- r.Header.Get("Authorization")
+			received <- a
+		}
+		w.WriteHeader(200)
+	})
+	server := httptest.NewServer(handler)
+
+	gs := New("test", server.URL, "secret", "", "", 1)
+	if err := gs.Connect(); err != nil {
+		t.Logf("TestShipAnnotation failed to connect. Error: %s", err)
+		t.FailNow()
+	}
+	gs.Start()
+	gs.Ship(&Annotation{Time: 1000, Tags: []string{"outage"}, Text: "database down"})
+	gs.Stop()
+
+	select {
+	case a := <-received:
+		if a.Text != "database down" || a.Time != 1000 || len(a.Tags) != 1 || a.Tags[0] != "outage" {
+			t.Logf("TestShipAnnotation got an unexpected annotation: %+v", a)
+			t.Fail()
+		}
+		if auth := <-authHeaders; auth != "Bearer secret" {
+			t.Logf("TestShipAnnotation expected a bearer token. Got: %q", auth)
+			t.Fail()
+		}
+	case <-time.After(time.Second):
+		t.Logf("TestShipAnnotation did not receive the annotation.")
+		t.Fail()
+	}
+	<-gs.StopChan
+	if !gs.Finished() {
+		t.Logf("TestShipAnnotation expected the shipper to be finished.")
+		t.Fail()
+	}
+	if err := gs.Ship(&Annotation{}); err == nil {
+		t.Logf("TestShipAnnotation expected an error shipping after stopping.")
+		t.Fail()
+	}
+}
@@ -0,0 +1,181 @@
+// Package httpShipper sends HTTP requests to an endpoint, such as a webhook receiver.
+//
+// The connection details, authentication, extra headers and TLS settings are shared by
+// every request sent through a shipper. Requests are made from a template that is rendered
+// each time an event fires.
+//
+// To start the shipper first, create a new shipper, call Connect() to create the HTTP client,
+// call Start() to signal that requests can be sent. Use Ship(request) to submit requests.
+// Call Stop() to drain the queue, once finished the StopChan will receive a true.
+package httpShipper
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/silverstagtech/loggos"
+)
+
+// Request is a rendered HTTP request waiting to be sent.
+// A ExpectedStatus of 0 will accept any 2xx status code.
+type Request struct {
+	Method         string
+	Path           string
+	Headers        map[string]string
+	Body           []byte
+	ExpectedStatus int
+	Timeout        time.Duration
+}
+
+// HTTPShipper will send requests to the endpoint given.
+type HTTPShipper struct {
+	id            string
+	address       string
+	username      string
+	password      string
+	bearerToken   string
+	headers       map[string]string
+	tlsSkipVerify bool
+	httpTimeout   time.Duration
+	httpClient    *http.Client
+	queue         chan *Request
+	StopChan      chan bool
+	stopped       bool
+	finished      bool
+}
+
+// New will return a pointer to a HTTPShipper. You will need to call connect on it to get it
+// ready to start sending requests. httpTimeout is in seconds.
+func New(id, address, username, password, bearerToken string, headers map[string]string, tlsSkipVerify bool, httpTimeout int) *HTTPShipper {
+	return &HTTPShipper{
+		id:            id,
+		address:       address,
+		username:      username,
+		password:      password,
+		bearerToken:   bearerToken,
+		headers:       headers,
+		tlsSkipVerify: tlsSkipVerify,
+		httpTimeout:   time.Duration(httpTimeout) * time.Second,
+		queue:         make(chan *Request, 1000),
+		StopChan:      make(chan bool, 1),
+	}
+}
+
+// Connect creates the HTTP client used to send requests. There is no generic way to check
+// that a webhook receiver is healthy, so requests failing will only be seen in the logs.
+func (hs *HTTPShipper) Connect() error {
+	hs.httpClient = &http.Client{
+		Transport: &http.Transport{
+			Dial: (&net.Dialer{
+				Timeout: hs.httpTimeout,
+			}).Dial,
+			TLSHandshakeTimeout: hs.httpTimeout,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: hs.tlsSkipVerify,
+			},
+		},
+		Timeout: hs.httpTimeout,
+	}
+	return nil
+}
+
+// Start signals that the shipper should start sending requests.
+func (hs *HTTPShipper) Start() {
+	go func() {
+		for request := range hs.queue {
+			if err := hs.send(request); err != nil {
+				jm := loggos.JSONCritln("HTTP request failed.")
+				jm.Add("id", hs.id)
+				jm.Add("shipper_type", "HTTP")
+				jm.Add("method", request.Method)
+				jm.Add("path", request.Path)
+				jm.Error(err)
+				loggos.SendJSON(jm)
+			}
+		}
+		hs.finished = true
+		hs.StopChan <- true
+	}()
+}
+
+func (hs *HTTPShipper) newRequest(request *Request) (*http.Request, error) {
+	req, err := http.NewRequest(request.Method, hs.address+request.Path, bytes.NewReader(request.Body))
+	if err != nil {
+		return nil, err
+	}
+	if len(request.Body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range hs.headers {
+		req.Header.Set(key, value)
+	}
+	for key, value := range request.Headers {
+		req.Header.Set(key, value)
+	}
+	if hs.bearerToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", hs.bearerToken))
+	} else if hs.username != "" && hs.password != "" {
+		req.SetBasicAuth(hs.username, hs.password)
+	}
+	return req, nil
+}
+
+func (hs *HTTPShipper) send(request *Request) error {
+	req, err := hs.newRequest(request)
+	if err != nil {
+		return err
+	}
+
+	client := hs.httpClient
+	if request.Timeout > 0 {
+		client = &http.Client{
+			Transport: hs.httpClient.Transport,
+			Timeout:   request.Timeout,
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if request.ExpectedStatus == 0 {
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("Got a bad status code. Code: %d", resp.StatusCode)
+		}
+	} else if resp.StatusCode != request.ExpectedStatus {
+		return fmt.Errorf("Got status code %d but expected %d", resp.StatusCode, request.ExpectedStatus)
+	}
+
+	jm := loggos.JSONDebugln("HTTP request sent.")
+	jm.Add("id", hs.id)
+	jm.Add("shipper_type", "HTTP")
+	jm.Add("response_code", resp.StatusCode)
+	loggos.SendJSON(jm)
+	return nil
+}
+
+// Ship queues a request to be sent.
+func (hs *HTTPShipper) Ship(request *Request) error {
+	if hs.stopped {
+		return fmt.Errorf("input is closed")
+	}
+	hs.queue <- request
+	return nil
+}
+
+// Stop will stop the shipper taking new requests. Once the queued requests have been
+// sent the StopChan will get a true.
+func (hs *HTTPShipper) Stop() {
+	hs.stopped = true
+	close(hs.queue)
+}
+
+// Finished will signal if the shipper is finished sending all the requests given to it.
+func (hs *HTTPShipper) Finished() bool {
+	return hs.finished
+}
@@ -0,0 +1,162 @@
+package httpShipper
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/silverstagtech/loggos"
+)
+
+func setupLogger() {
+	loggos.JSONLoggerEnableDebugLogging(true)
+	loggos.JSONLoggerEnablePrettyPrint(true)
+	loggos.JSONLoggerEnableHumanTimestamps(true)
+}
+
+func TestRenderRequest(t *testing.T) {
+	body := map[string]interface{}{
+		"service": "{{ .Tags.service }}",
+		"value":   "{{ .Fields.count }}",
+		"message": "{{ .MetricName }} fired on {{ .Tags.service }}",
+		"labels":  []interface{}{"{{ .Tags.service }}", "static"},
+	}
+	rt, err := NewRequestTemplate("POST", "/hooks/{{ .Tags.service }}", map[string]string{"X-Service": "{{ .Tags.service }}"}, body, 202, 3)
+	if err != nil {
+		t.Logf("Failed to create request template. Error: %s", err)
+		t.FailNow()
+	}
+	req, err := rt.Render(TemplateData{
+		MetricName: "alert",
+		Tags:       map[string]string{"service": "api"},
+		Fields:     map[string]interface{}{"count": 5},
+	})
+	if err != nil {
+		t.Logf("Failed to render request. Error: %s", err)
+		t.FailNow()
+	}
+
+	if req.Path != "/hooks/api" || req.Headers["X-Service"] != "api" || req.ExpectedStatus != 202 || req.Timeout != 3*time.Second {
+		t.Logf("Rendered request is not as expected: %+v", req)
+		t.Fail()
+	}
+	got := map[string]interface{}{}
+	if err := json.Unmarshal(req.Body, &got); err != nil {
+		t.Logf("Rendered body is not JSON. Error: %s", err)
+		t.FailNow()
+	}
+	if got["service"] != "api" || got["value"] != float64(5) || got["message"] != "alert fired on api" {
+		t.Logf("Rendered body is not as expected: %s", req.Body)
+		t.Fail()
+	}
+	if labels, ok := got["labels"].([]interface{}); !ok || labels[0] != "api" || labels[1] != "static" {
+		t.Logf("Rendered body list is not as expected: %s", req.Body)
+		t.Fail()
+	}
+}
+
+func TestRenderBodyTypes(t *testing.T) {
+	body := map[string]interface{}{
+		"flag":    "{{ .Tags.flag }}",
+		"count":   "{{ .Fields.count }}",
+		"healthy": "{{ .Fields.healthy }}",
+		"ratio":   "{{ .Fields.ratio }}",
+		"text":    "{{ .Fields.text }}",
+	}
+	rt, err := NewRequestTemplate("POST", "/", nil, body, 200, 0)
+	if err != nil {
+		t.Logf("Failed to create request template. Error: %s", err)
+		t.FailNow()
+	}
+	req, err := rt.Render(TemplateData{
+		Tags:   map[string]string{"flag": "t"},
+		Fields: map[string]interface{}{"count": 3, "healthy": true, "ratio": math.NaN(), "text": "1"},
+	})
+	if err != nil {
+		t.Logf("Failed to render request. Error: %s", err)
+		t.FailNow()
+	}
+	got := map[string]interface{}{}
+	if err := json.Unmarshal(req.Body, &got); err != nil {
+		t.Logf("Rendered body is not JSON. Error: %s", err)
+		t.FailNow()
+	}
+	expected := map[string]interface{}{"flag": "t", "count": float64(3), "healthy": true, "ratio": "NaN", "text": "1"}
+	for key, value := range expected {
+		if got[key] != value {
+			t.Logf("Expected %s to be %#v. Got: %#v", key, value, got[key])
+			t.Fail()
+		}
+	}
+}
+
+func TestBadTemplate(t *testing.T) {
+	_, err := NewRequestTemplate("POST", "/{{ .Tags.service ", nil, nil, 0, 0)
+	if err == nil {
+		t.Logf("A bad path template did not raise an error.")
+		t.Fail()
+	}
+}
+
+func TestShipRequest(t *testing.T) {
+	setupLogger()
+	type received struct {
+		path   string
+		auth   string
+		header string
+		body   string
+	}
+	requests := make(chan received, 2)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		requests <- received{
+			path:   r.URL.Path,
+			auth:   r.Header.Get("Authorization"),
+			header: r.Header.Get("X-Team"),
+			body:   string(b),
+		}
+		w.WriteHeader(202)
+	})
+	server := httptest.NewServer(handler)
+
+	hs := New("test", server.URL, "", "", "token", map[string]string{"X-Team": "sre"}, false, 1)
+	hs.Connect()
+	hs.Start()
+	hs.Ship(&Request{Method: "POST", Path: "/hook", Body: []byte(`{"a":1}`), ExpectedStatus: 202})
+	hs.Stop()
+	<-hs.StopChan
+
+	select {
+	case r := <-requests:
+		if r.path != "/hook" || r.auth != "Bearer token" || r.header != "sre" || r.body != `{"a":1}` {
+			t.Logf("TestShipRequest got an unexpected request: %+v", r)
+			t.Fail()
+		}
+	default:
+		t.Logf("TestShipRequest did not receive a request.")
+		t.Fail()
+	}
+}
+
+func TestUnexpectedStatus(t *testing.T) {
+	setupLogger()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	})
+	server := httptest.NewServer(handler)
+
+	hs := New("test", server.URL, "", "", "", nil, false, 1)
+	hs.Connect()
+	if err := hs.send(&Request{Method: "GET", Path: "/", ExpectedStatus: 201}); err == nil {
+		t.Logf("TestUnexpectedStatus expected an error when the status did not match.")
+		t.Fail()
+	}
+	if err := hs.send(&Request{Method: "GET", Path: "/"}); err != nil {
+		t.Logf("TestUnexpectedStatus expected any 2xx to be accepted. Error: %s", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,214 @@
+package httpShipper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// TemplateData is the data that request templates are rendered with.
+type TemplateData struct {
+	MetricName string
+	Tags       map[string]string
+	Fields     map[string]interface{}
+	Time       time.Time
+}
+
+// RequestTemplate is used to render a Request each time an event fires.
+// The path, header values and every string in the body are go templates.
+type RequestTemplate struct {
+	method         string
+	path           *template.Template
+	headers        map[string]*template.Template
+	body           interface{}
+	bodyTemplates  map[string]*template.Template
+	expectedStatus int
+	timeout        time.Duration
+}
+
+// NewRequestTemplate parses the templates in the request description and returns a
+// RequestTemplate. body can be any value that can be turned into JSON, nil will send no body.
+// timeout is in seconds, 0 uses the connection timeout.
+func NewRequestTemplate(method, path string, headers map[string]string, body interface{}, expectedStatus, timeout int) (*RequestTemplate, error) {
+	rt := &RequestTemplate{
+		method:         method,
+		headers:        make(map[string]*template.Template),
+		body:           body,
+		bodyTemplates:  make(map[string]*template.Template),
+		expectedStatus: expectedStatus,
+		timeout:        time.Duration(timeout) * time.Second,
+	}
+	var err error
+	rt.path, err = parseTemplate(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse path template. Error: %s", err)
+	}
+	for key, value := range headers {
+		rt.headers[key], err = parseTemplate(value)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse header %s template. Error: %s", key, err)
+		}
+	}
+	if err := rt.parseBody(body); err != nil {
+		return nil, fmt.Errorf("Failed to parse body template. Error: %s", err)
+	}
+	return rt, nil
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New(text).Option("missingkey=zero").Parse(text)
+}
+
+func (rt *RequestTemplate) parseBody(body interface{}) error {
+	switch v := body.(type) {
+	case string:
+		if _, ok := rt.bodyTemplates[v]; ok {
+			return nil
+		}
+		t, err := parseTemplate(v)
+		if err != nil {
+			return err
+		}
+		rt.bodyTemplates[v] = t
+	case map[string]interface{}:
+		for _, value := range v {
+			if err := rt.parseBody(value); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if err := rt.parseBody(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func execute(t *template.Template, data TemplateData) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// renderBody walks the body and renders every string. A string that is only a field
+// such as "{{ .Fields.value }}" keeps the type of the field in the JSON if it is a number
+// or bool.
+func (rt *RequestTemplate) renderBody(body interface{}, data TemplateData) (interface{}, error) {
+	switch v := body.(type) {
+	case string:
+		t := rt.bodyTemplates[v]
+		if value, ok := nativeField(t, data); ok {
+			return value, nil
+		}
+		return execute(t, data)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			rendered, err := rt.renderBody(value, data)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = rendered
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for index, value := range v {
+			rendered, err := rt.renderBody(value, data)
+			if err != nil {
+				return nil, err
+			}
+			out[index] = rendered
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
+
+// nativeField returns the value of the field that the template is made of if the
+// template is only that field and the value is a number or bool. NaN and Inf can't be
+// sent as JSON so they are left to be rendered as strings.
+func nativeField(t *template.Template, data TemplateData) (interface{}, bool) {
+	var action *parse.ActionNode
+	for _, node := range t.Tree.Root.Nodes {
+		switch n := node.(type) {
+		case *parse.TextNode:
+			if strings.TrimSpace(string(n.Text)) != "" {
+				return nil, false
+			}
+		case *parse.ActionNode:
+			if action != nil {
+				return nil, false
+			}
+			action = n
+		default:
+			return nil, false
+		}
+	}
+	if action == nil || len(action.Pipe.Decl) != 0 || len(action.Pipe.Cmds) != 1 || len(action.Pipe.Cmds[0].Args) != 1 {
+		return nil, false
+	}
+	field, ok := action.Pipe.Cmds[0].Args[0].(*parse.FieldNode)
+	if !ok || len(field.Ident) != 2 || field.Ident[0] != "Fields" {
+		return nil, false
+	}
+	value, ok := data.Fields[field.Ident[1]]
+	if !ok || value == nil {
+		return nil, false
+	}
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return value, true
+	case reflect.Float32, reflect.Float64:
+		f := reflect.ValueOf(value).Float()
+		return value, !math.IsNaN(f) && !math.IsInf(f, 0)
+	}
+	return nil, false
+}
+
+// Render creates a Request using the data given.
+func (rt *RequestTemplate) Render(data TemplateData) (*Request, error) {
+	path, err := execute(rt.path, data)
+	if err != nil {
+		return nil, err
+	}
+	headers := make(map[string]string, len(rt.headers))
+	for key, t := range rt.headers {
+		headers[key], err = execute(t, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var body []byte
+	if rt.body != nil {
+		rendered, err := rt.renderBody(rt.body, data)
+		if err != nil {
+			return nil, err
+		}
+		body, err = json.Marshal(rendered)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Request{
+		Method:         rt.method,
+		Path:           path,
+		Headers:        headers,
+		Body:           body,
+		ExpectedStatus: rt.expectedStatus,
+		Timeout:        rt.timeout,
+	}, nil
+}
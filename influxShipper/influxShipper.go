@@ -66,19 +66,9 @@ func New(id, address, database, username, password, influxPrecision string, batc
 	}
 }
 
+// influxTimeStamp returns the time now in the precision of the connection.
 func (is *InfluxShipper) influxTimeStamp() string {
-	// valid options
-	//"h", "m", "s", "ms", "u", "ns"
-	// Epoch normally counts to seconds. Use the UnixNano() to get down to
-	// nano seconds which also includes ms, u.
-	switch is.influxPrecision {
-	case "h", "m", "s":
-		return fmt.Sprintf("%d", time.Now().Unix())
-	case "ms", "u", "ns":
-		return fmt.Sprintf("%d", time.Now().UnixNano())
-	default:
-		return fmt.Sprintf("%d", time.Now().Unix())
-	}
+	return fmt.Sprintf("%d", timestampAt(is.influxPrecision, time.Now()))
 }
 
 // newShipper will create a new shipper that will connect and write metrics to InfluxDB
@@ -153,6 +143,30 @@ func (is *InfluxShipper) ShipWithTimeStamp(metric string) {
 	is.queue <- fmt.Sprintf("%s %s", metric, is.influxTimeStamp())
 }
 
+// ShipAt takes a metric with no time and sends it with the time given in the precision of
+// the connection. This lets points be sent late or with a time in the past.
+func (is *InfluxShipper) ShipAt(metric string, at time.Time) error {
+	return is.Ship(fmt.Sprintf("%s %d", metric, timestampAt(is.influxPrecision, at)))
+}
+
+// timestampAt returns at as a number of the units of precision since the epoch.
+func timestampAt(precision string, at time.Time) int64 {
+	switch precision {
+	case "h":
+		return at.Unix() / 3600
+	case "m":
+		return at.Unix() / 60
+	case "ms":
+		return at.UnixNano() / int64(time.Millisecond)
+	case "u":
+		return at.UnixNano() / int64(time.Microsecond)
+	case "ns":
+		return at.UnixNano()
+	default:
+		return at.Unix()
+	}
+}
+
 // Ship takes a metric as a string and sends it to a Influx connections to be
 // written to the database on the next flush.
 func (is *InfluxShipper) Ship(metric string) error {
@@ -281,3 +281,30 @@ func TestBadInfluxShipperConnect(t *testing.T) {
 		}
 	}
 }
+
+func TestTimestampAt(t *testing.T) {
+	at := time.Date(2023, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	tests := map[string]int64{
+		"h":  at.Unix() / 3600,
+		"m":  at.Unix() / 60,
+		"s":  1672628645,
+		"ms": 1672628645006,
+		"u":  1672628645006000,
+		"ns": 1672628645006000000,
+	}
+	for precision, expected := range tests {
+		if timestamp := timestampAt(precision, at); timestamp != expected {
+			t.Logf("Precision %s should give %d. Got: %d", precision, expected, timestamp)
+			t.Fail()
+		}
+	}
+}
+
+func TestInfluxTimeStamp(t *testing.T) {
+	// Milliseconds since the epoch have 13 digits until the year 2286.
+	is := &InfluxShipper{influxPrecision: "ms"}
+	if timestamp := is.influxTimeStamp(); len(timestamp) != 13 {
+		t.Logf("Expected a timestamp in milliseconds. Got: %s", timestamp)
+		t.Fail()
+	}
+}
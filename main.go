@@ -3,26 +3,140 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 
 	"github.com/silverstagtech/loggos"
 	"github.com/silverstagtech/teller/config"
+	"github.com/silverstagtech/teller/fileShipper"
 	"github.com/silverstagtech/teller/orchestrator"
+	"github.com/silverstagtech/teller/planner"
 )
 
 const (
-	version = "0.0.1"
+	version        = "0.0.1"
+	planCommand    = "plan"
+	convertCommand = "convert"
 )
 
 var (
-	configLocation    = flag.String("c", "./config.json", "The configuration file for the test. The configuration should tell the story in timelines that oyu want to send to the metric systems.")
+	configLocations   = configFiles{}
+	variables         = variableFlags{}
+	configFormat      = flag.String("format", "", "The format of the configuration file, json, yaml or toml. Defaults to the file extension.")
 	exampleConfigFlag = flag.Bool("e", false, "Print a example json configuration to the terminal.")
+	dryRunFlag        = flag.Bool("dry-run", false, "Record the events in memory rather than sending them. A summary is printed at the end.")
+	durationFlag      = flag.Duration("duration", 0, "Stop the story after this long, like 30m. Overrides max_duration in the story.")
+	backfillFlag      = flag.Duration("backfill", 0, "Send the points the story would have sent over this long before now, like 24h, with the times they are for. Only influx points can be backfilled.")
+	backfillSpeed     = flag.Float64("backfill-speed", 60, "How many times faster than real time a backfill runs.")
 	versionFlag       = flag.Bool("v", false, "Shows the version of the application.")
 	helpFlag          = flag.Bool("h", false, "Shows this help menu.")
+	logs              = &logPrinter{}
 )
 
+func init() {
+	flag.Var(&configLocations, "c", "The configuration file for the test. The configuration should tell the story in timelines that oyu want to send to the metric systems. Can be given more than once to merge several files. Defaults to ./config.json.")
+	flag.Var(variables, "set", "Override a story variable with name=value. Can be given more than once.")
+}
+
+// configFiles collects the configuration files given with -c. It can be given more than once.
+type configFiles []string
+
+func (cf *configFiles) String() string {
+	return strings.Join(*cf, ",")
+}
+
+func (cf *configFiles) Set(value string) error {
+	*cf = append(*cf, value)
+	return nil
+}
+
+// paths returns the files given or the default configuration file.
+func (cf configFiles) paths() []string {
+	if len(cf) == 0 {
+		return []string{"./config.json"}
+	}
+	return cf
+}
+
+// variableFlags collects the story variables given with -set name=value.
+type variableFlags map[string]string
+
+func (vf variableFlags) String() string {
+	pairs := []string{}
+	for name, value := range vf {
+		pairs = append(pairs, name+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (vf variableFlags) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("variables must be given as name=value")
+	}
+	vf[parts[0]] = parts[1]
+	return nil
+}
+
+// logPrinter holds on to the logs until it is told where to write them. Stories can write
+// their metrics to stdout, in which case the logs need to go to stderr.
+type logPrinter struct {
+	sync.Mutex
+	out  io.Writer
+	held []string
+}
+
+// Send writes the log message or holds on to it if there is nowhere to write it yet.
+func (lp *logPrinter) Send(msg string) {
+	lp.Lock()
+	defer lp.Unlock()
+	if lp.out == nil {
+		lp.held = append(lp.held, msg)
+		return
+	}
+	fmt.Fprintln(lp.out, msg)
+}
+
+// use writes the logs that are held to out and any that follow.
+func (lp *logPrinter) use(out io.Writer) {
+	lp.Lock()
+	defer lp.Unlock()
+	lp.out = out
+	for _, msg := range lp.held {
+		fmt.Fprintln(lp.out, msg)
+	}
+	lp.held = nil
+}
+
+// writesToStdout is true if any file connection of the story writes to stdout.
+func writesToStdout(story *config.Story) bool {
+	for _, file := range story.File {
+		if file.Path == fileShipper.Stdout {
+			return true
+		}
+	}
+	return false
+}
+
 func main() {
+	// Starting the logger first lets its printer be replaced before anything is logged.
+	loggos.JSONLoggerEnablePrettyPrint(true)
+	loggos.DefaultJSONLogger.OverridePrinter(logs)
+
+	// Sub commands have their own flags so need to be checked first.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case planCommand:
+			plan(os.Args[2:])
+		case convertCommand:
+			convert(os.Args[2:])
+		}
+	}
+
 	// consume flags and test for show stoppers.
 	flag.Parse()
 	showStoppers()
@@ -43,17 +157,37 @@ func main() {
 	loggos.SendJSON(loggos.JSONInfoln("Starting teller"))
 
 	// Collect configuration file.
-	config, err := config.New(*configLocation)
+	config, err := config.NewFromFiles(configLocations.paths(), config.Options{Format: *configFormat, Variables: variables})
 	if err != nil {
+		logs.use(os.Stdout)
 		jm := loggos.JSONCritln("Failed to read configuration.")
 		jm.Error(err)
 		loggos.SendJSON(jm)
 
 		terminate(1)
 	}
+	if writesToStdout(config.Story) {
+		logs.use(os.Stderr)
+	} else {
+		logs.use(os.Stdout)
+	}
+	if *durationFlag > 0 {
+		config.Story.MaxDuration = durationFlag.String()
+	}
 	// start single run
 	// start continuous run
 	orchestrator := orchestrator.New(signals, config)
+	if *dryRunFlag {
+		orchestrator.EnableDryRun()
+	}
+	if *backfillFlag > 0 {
+		if err := orchestrator.EnableBackfill(*backfillFlag, *backfillSpeed); err != nil {
+			jm := loggos.JSONCritln("Failed to set up the backfill")
+			jm.Error(err)
+			loggos.SendJSON(jm)
+			terminate(1)
+		}
+	}
 	err = orchestrator.Start()
 	if err != nil {
 		jm := loggos.JSONCritln("Failed to start the timelines")
@@ -61,8 +195,18 @@ func main() {
 		loggos.SendJSON(jm)
 		terminate(1)
 	}
-	<-orchestrator.StopChan
+	if err := <-orchestrator.StopChan; err != nil {
+		jm := loggos.JSONCritln("Failed to stop cleanly")
+		jm.Error(err)
+		loggos.SendJSON(jm)
+		terminate(1)
+	}
 	loggos.SendJSON(loggos.JSONInfoln("Finished successfully!"))
+	if *dryRunFlag {
+		// Flush the logs first so that the summary is not mixed in with them.
+		<-loggos.Flush()
+		fmt.Print(orchestrator.DryRunSummary())
+	}
 	terminate(0)
 }
 
@@ -81,6 +225,69 @@ func showStoppers() {
 	}
 }
 
+// plan prints the expected timings and point counts for a story then exits.
+func plan(args []string) {
+	planFlags := flag.NewFlagSet(planCommand, flag.ExitOnError)
+	planConfigLocations := configFiles{}
+	planFlags.Var(&planConfigLocations, "c", "The configuration file for the story to plan. Can be given more than once. Defaults to ./config.json.")
+	planVariables := variableFlags{}
+	planFlags.Var(planVariables, "set", "Override a story variable with name=value. Can be given more than once.")
+	planConfigFormat := planFlags.String("format", "", "The format of the configuration file, json, yaml or toml. Defaults to the file extension.")
+	planDuration := planFlags.Duration("duration", 0, "Plan the story as if it stops after this long, like 30m. Overrides max_duration in the story.")
+	planFlags.Parse(args)
+	// The plan is printed to stdout so the logs from reading the story go to stderr.
+	logs.use(os.Stderr)
+
+	config, err := config.NewFromFiles(planConfigLocations.paths(), config.Options{Format: *planConfigFormat, Variables: planVariables})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read configuration. %s\n", err)
+		os.Exit(1)
+	}
+	if *planDuration > 0 {
+		config.Story.MaxDuration = planDuration.String()
+	}
+	storyPlan, err := planner.New(config.Story)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to plan the story. %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(storyPlan)
+	os.Exit(0)
+}
+
+// convert rewrites a story in a different configuration format then exits.
+func convert(args []string) {
+	convertFlags := flag.NewFlagSet(convertCommand, flag.ExitOnError)
+	input := convertFlags.String("c", "./config.json", "The configuration file to convert.")
+	inputFormat := convertFlags.String("format", "", "The format of the configuration file, json, yaml or toml. Defaults to the file extension.")
+	output := convertFlags.String("o", "-", "Where to write the converted configuration. Use - for stdout.")
+	outputFormat := convertFlags.String("to", "", "The format to convert to, json, yaml or toml. Defaults to the extension of -o.")
+	convertFlags.Parse(args)
+	logs.use(os.Stderr)
+
+	if *outputFormat == "" {
+		if *output == "-" {
+			fmt.Fprintln(os.Stderr, "-to is needed when writing to stdout.")
+			os.Exit(1)
+		}
+		*outputFormat = config.FormatFromPath(*output)
+	}
+	converted, err := config.Convert(*input, *inputFormat, *outputFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to convert the configuration. %s\n", err)
+		os.Exit(1)
+	}
+	if *output == "-" {
+		fmt.Print(string(converted))
+		os.Exit(0)
+	}
+	if err := ioutil.WriteFile(*output, converted, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write the converted configuration. %s\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
 // terminate is used to exit but also flush the logger
 func terminate(exitNumber int) {
 	<-loggos.Flush()
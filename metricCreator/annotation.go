@@ -0,0 +1,31 @@
+package metricCreator
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	annotationFieldTitle = "title"
+	annotationFieldText  = "text"
+	annotationFieldTags  = "tags"
+)
+
+// NewAnnotation returns a MetricObject that describes an Influx annotation point.
+// The title, text and annotation tags are written as string fields in the measurement
+// given, which is the layout Grafana expects when reading annotations from Influx.
+func NewAnnotation(measurement, title, text string, annotationTags []string, tags map[string]string) (*MetricObject, error) {
+	fields := map[string]interface{}{
+		annotationFieldTitle: influxString(title),
+		annotationFieldText:  influxString(text),
+		annotationFieldTags:  influxString(strings.Join(annotationTags, ",")),
+	}
+	return NewMetric(measurement, tags, fields)
+}
+
+// influxString quotes a string so that it is a valid Influx string field value.
+func influxString(s string) string {
+	escaped := strings.Replace(s, `\`, `\\`, -1)
+	escaped = strings.Replace(escaped, `"`, `\"`, -1)
+	return fmt.Sprintf(`"%s"`, escaped)
+}
@@ -0,0 +1,144 @@
+package metricCreator
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Anomaly types that can be laid over the fields of a metric.
+const (
+	AnomalySpike      = "spike"
+	AnomalyStep       = "step"
+	AnomalyFlatline   = "flatline"
+	AnomalyDropToZero = "drop_to_zero"
+	AnomalyGap        = "gap"
+	AnomalyNoiseBurst = "noise_burst"
+)
+
+// ValidAnomalyTypes are the anomalies that can be used.
+var ValidAnomalyTypes = []string{AnomalySpike, AnomalyStep, AnomalyFlatline, AnomalyDropToZero, AnomalyGap, AnomalyNoiseBurst}
+
+// Anomaly changes the values of a metric for some of its fires after they have been
+// generated. spike multiplies the fields by factor, step adds amount to them, flatline
+// holds them at the value they had when the anomaly started, drop_to_zero sets them to 0,
+// noise_burst adds normal noise with amount as its standard deviation and gap stops the
+// metric being sent at all.
+type Anomaly struct {
+	kind   string
+	fields []string
+	start  int
+	fires  int
+	factor float64
+	amount float64
+	held   map[string]map[string]interface{}
+	lock   sync.Mutex
+}
+
+// NewAnomaly returns an Anomaly of the kind given that changes fields, or all the numeric
+// fields if fields is empty. It starts at fire start and lasts for fires fires, or until the
+// metric stops firing if fires is 0.
+func NewAnomaly(kind string, fields []string, start, fires int, factor, amount float64) (*Anomaly, error) {
+	validKind := false
+	for _, validAnomalyType := range ValidAnomalyTypes {
+		if kind == validAnomalyType {
+			validKind = true
+		}
+	}
+	if !validKind {
+		return nil, fmt.Errorf("anomaly type %s is not valid. Only %s are valid", kind, strings.Join(ValidAnomalyTypes, ","))
+	}
+	if start < 0 || fires < 0 {
+		return nil, fmt.Errorf("anomaly start_fire and fires can not be negative")
+	}
+	if kind == AnomalySpike && factor == 0 {
+		return nil, fmt.Errorf("spike anomalies need a factor")
+	}
+	if kind == AnomalyNoiseBurst && amount <= 0 {
+		return nil, fmt.Errorf("noise_burst anomalies need an amount greater than 0")
+	}
+	return &Anomaly{
+		kind:   kind,
+		fields: fields,
+		start:  start,
+		fires:  fires,
+		factor: factor,
+		amount: amount,
+		held:   make(map[string]map[string]interface{}),
+	}, nil
+}
+
+// Type returns the kind of anomaly.
+func (a *Anomaly) Type() string {
+	return a.kind
+}
+
+// active is true if the anomaly changes the fire given.
+func (a *Anomaly) active(fire int) bool {
+	return fire >= a.start && (a.fires == 0 || fire < a.start+a.fires)
+}
+
+// apply changes fields for a fire of the series given. It returns false if the metric
+// should not be sent.
+func (a *Anomaly) apply(fire int, series string, fields map[string]interface{}) bool {
+	if a.kind == AnomalyGap {
+		return false
+	}
+	names := a.fields
+	if len(names) == 0 {
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	if a.kind == AnomalyFlatline {
+		a.lock.Lock()
+		defer a.lock.Unlock()
+		held, ok := a.held[series]
+		if !ok || fire == a.start {
+			held = make(map[string]interface{}, len(names))
+			for _, name := range names {
+				if _, ok := toFloat(fields[name]); ok {
+					held[name] = fields[name]
+				}
+			}
+			a.held[series] = held
+		}
+		for name, value := range held {
+			fields[name] = value
+		}
+		return true
+	}
+
+	for _, name := range names {
+		value, ok := toFloat(fields[name])
+		if !ok {
+			continue
+		}
+		switch a.kind {
+		case AnomalySpike:
+			value *= a.factor
+		case AnomalyStep:
+			value += a.amount
+		case AnomalyDropToZero:
+			value = 0
+		case AnomalyNoiseBurst:
+			value += rand.NormFloat64() * a.amount
+		}
+		fields[name] = value
+	}
+	return true
+}
+
+// seriesName returns a name for the series of a metric with the extra tags given so that
+// each series in a fan out is held separately.
+func seriesName(extraTags map[string]string) string {
+	parts := make([]string, 0, len(extraTags))
+	for _, key := range sortedTagKeys(extraTags) {
+		parts = append(parts, key+"="+extraTags[key])
+	}
+	return strings.Join(parts, ",")
+}
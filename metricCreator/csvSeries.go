@@ -0,0 +1,127 @@
+package metricCreator
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSVSeries gives a field the values of a column in a CSV file. The first row of the file
+// names the columns. Without a timestamp column the series moves on a row each time the
+// metric is rendered. With one, it sends the row that was current the same time after the
+// first row as the story has been running.
+type CSVSeries struct {
+	path    string
+	values  []float64
+	offsets []float64
+	loop    bool
+}
+
+// NewCSVSeries reads column from the CSV file at path. timestampColumn is optional and
+// holds RFC3339 times or seconds since the epoch, in order. If loop is set the series
+// starts again after the last row, otherwise it keeps sending the last value.
+func NewCSVSeries(path, column, timestampColumn string, loop bool) (*CSVSeries, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open CSV file %s. Error %s", path, err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read CSV file %s. Error %s", path, err)
+	}
+	if len(rows) < 2 {
+		return nil, fmt.Errorf("CSV file %s has no rows after the header", path)
+	}
+
+	valueIndex, timestampIndex := -1, -1
+	for index, name := range rows[0] {
+		switch strings.TrimSpace(name) {
+		case column:
+			valueIndex = index
+		case timestampColumn:
+			timestampIndex = index
+		}
+	}
+	if valueIndex < 0 {
+		return nil, fmt.Errorf("CSV file %s has no column %s", path, column)
+	}
+	if timestampColumn != "" && timestampIndex < 0 {
+		return nil, fmt.Errorf("CSV file %s has no timestamp column %s", path, timestampColumn)
+	}
+
+	cs := &CSVSeries{path: path, loop: loop}
+	var first float64
+	for line, row := range rows[1:] {
+		value, err := strconv.ParseFloat(strings.TrimSpace(row[valueIndex]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("CSV file %s row %d column %s is not a number", path, line+2, column)
+		}
+		cs.values = append(cs.values, value)
+		if timestampIndex < 0 {
+			continue
+		}
+		timestamp, err := parseTimestamp(strings.TrimSpace(row[timestampIndex]))
+		if err != nil {
+			return nil, fmt.Errorf("CSV file %s row %d %s", path, line+2, err)
+		}
+		if line == 0 {
+			first = timestamp
+		}
+		offset := timestamp - first
+		if line > 0 && offset < cs.offsets[line-1] {
+			return nil, fmt.Errorf("CSV file %s row %d is earlier than the row before it", path, line+2)
+		}
+		cs.offsets = append(cs.offsets, offset)
+	}
+	return cs, nil
+}
+
+// parseTimestamp returns an RFC3339 time or a number of seconds as seconds since the epoch.
+func parseTimestamp(value string) (float64, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return seconds, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("timestamp %s is not RFC3339 or a number of seconds", value)
+	}
+	return float64(t.UnixNano()) / float64(time.Second), nil
+}
+
+// Len returns the number of rows in the series.
+func (cs *CSVSeries) Len() int {
+	return len(cs.values)
+}
+
+// value returns the value of the series for a fire.
+func (cs *CSVSeries) value(fire fireContext) float64 {
+	last := len(cs.values) - 1
+	if cs.offsets == nil {
+		if cs.loop {
+			return cs.values[fire.iteration%len(cs.values)]
+		}
+		if fire.iteration > last {
+			return cs.values[last]
+		}
+		return cs.values[fire.iteration]
+	}
+
+	elapsed := fire.elapsed
+	if span := cs.offsets[last]; cs.loop && span > 0 {
+		elapsed = math.Mod(elapsed, span)
+	}
+	// Find the last row that is not after the elapsed time.
+	row := sort.Search(len(cs.offsets), func(i int) bool {
+		return cs.offsets[i] > elapsed
+	}) - 1
+	if row < 0 {
+		row = 0
+	}
+	return cs.values[row]
+}
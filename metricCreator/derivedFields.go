@@ -0,0 +1,241 @@
+package metricCreator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/silverstagtech/teller/expression"
+)
+
+// LatestValues keeps the last value each metric sent for each of its numeric fields so
+// that derived fields can use the values of other events.
+type LatestValues struct {
+	lock   sync.RWMutex
+	values map[string]map[string]float64
+}
+
+// NewLatestValues returns an empty LatestValues.
+func NewLatestValues() *LatestValues {
+	return &LatestValues{
+		values: make(map[string]map[string]float64),
+	}
+}
+
+// Record saves the numeric fields of a metric that has been sent.
+func (lv *LatestValues) Record(metricName string, fields map[string]interface{}) {
+	lv.lock.Lock()
+	defer lv.lock.Unlock()
+	values, ok := lv.values[metricName]
+	if !ok {
+		values = make(map[string]float64, len(fields))
+		lv.values[metricName] = values
+	}
+	for field, value := range fields {
+		if number, ok := toFloat(value); ok {
+			values[field] = number
+		}
+	}
+}
+
+// Latest returns the last value sent by a metric for a field.
+func (lv *LatestValues) Latest(metricName, field string) (float64, bool) {
+	lv.lock.RLock()
+	defer lv.lock.RUnlock()
+	value, ok := lv.values[metricName][field]
+	return value, ok
+}
+
+// ExpressionPrefix marks a field value as an expression that is worked out each time the
+// metric is rendered, like "=100 + 20*sin(t/60)".
+const ExpressionPrefix = "="
+
+// contextVariables can be used by expressions as well as the fields of the metric. t is
+// the seconds since the template started, iteration is the number of times it has been
+// rendered before and timeslice is the name of the time slice the event belongs to.
+var contextVariables = map[string]bool{
+	"t":         true,
+	"iteration": true,
+	"timeslice": true,
+}
+
+// IsExpression is true if a field value is an expression.
+func IsExpression(value interface{}) bool {
+	text, ok := value.(string)
+	return ok && strings.HasPrefix(text, ExpressionPrefix)
+}
+
+// ExpressionFields splits fields into the ones with a fixed value and the expressions,
+// without their prefix, of the ones that are worked out each time.
+func ExpressionFields(fields map[string]interface{}) (map[string]interface{}, map[string]string) {
+	fixed := make(map[string]interface{}, len(fields))
+	expressions := make(map[string]string)
+	for name, value := range fields {
+		if IsExpression(value) {
+			expressions[name] = strings.TrimPrefix(value.(string), ExpressionPrefix)
+			continue
+		}
+		fixed[name] = value
+	}
+	return fixed, expressions
+}
+
+// DerivedFields works out fields from expressions each time a metric is rendered.
+// An expression can use the other fields of the metric, including other derived fields,
+// the context variables t, iteration and timeslice, and the latest value of other metrics
+// with latest("metric_name", "field"). Fields of the same name hide the context variables.
+type DerivedFields struct {
+	expressions map[string]*expression.Expression
+	order       []string
+}
+
+// NewDerivedFields parses the expressions for each derived field. The names used in the
+// expressions must be fields, other derived fields or context variables and can't refer
+// back to themselves.
+func NewDerivedFields(definitions map[string]string, fields map[string]interface{}) (*DerivedFields, error) {
+	d := &DerivedFields{
+		expressions: make(map[string]*expression.Expression, len(definitions)),
+	}
+	names := make([]string, 0, len(definitions))
+	for name, definition := range definitions {
+		if _, ok := fields[name]; ok {
+			return nil, fmt.Errorf("derived field %s is also a field", name)
+		}
+		e, err := expression.Parse(definition)
+		if err != nil {
+			return nil, fmt.Errorf("derived field %s is invalid. Error %s", name, err)
+		}
+		d.expressions[name] = e
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, used := range d.expressions[name].Names() {
+			_, isField := fields[used]
+			_, isDerived := d.expressions[used]
+			if !isField && !isDerived && !contextVariables[used] {
+				return nil, fmt.Errorf("derived field %s uses %s which is not a field", name, used)
+			}
+		}
+	}
+
+	// Work out the order so that every derived field comes after the ones it uses.
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(names))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("derived field %s uses %s in a loop", path[len(path)-1], name)
+		case done:
+			return nil
+		}
+		state[name] = visiting
+		for _, used := range d.expressions[name].Names() {
+			if _, isDerived := d.expressions[used]; isDerived {
+				if err := visit(used, append(path, name)); err != nil {
+					return err
+				}
+			}
+		}
+		state[name] = done
+		d.order = append(d.order, name)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, []string{name}); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// Names returns the names of the derived fields in the order they are worked out.
+func (d *DerivedFields) Names() []string {
+	return d.order
+}
+
+// apply works out each derived field and adds it to fields.
+func (d *DerivedFields) apply(fields map[string]interface{}, latest *LatestValues, fire fireContext) error {
+	env := &fieldEnvironment{fields: fields, latest: latest, fire: fire}
+	for _, name := range d.order {
+		value, err := d.expressions[name].Evaluate(env)
+		if err != nil {
+			return fmt.Errorf("derived field %s failed. Error %s", name, err)
+		}
+		fields[name] = value
+	}
+	return nil
+}
+
+// FieldSource gives a field a new value each time a metric is rendered.
+type FieldSource interface {
+	value(fire fireContext) float64
+}
+
+// fireContext describes the render that derived fields are being worked out for. now is
+// the time the metric is for.
+type fireContext struct {
+	now       time.Time
+	elapsed   float64
+	iteration int
+	timeslice string
+}
+
+// variable returns the value of a context variable.
+func (fc fireContext) variable(name string) (interface{}, bool) {
+	switch name {
+	case "t":
+		return fc.elapsed, true
+	case "iteration":
+		return float64(fc.iteration), true
+	case "timeslice":
+		return fc.timeslice, true
+	}
+	return nil, false
+}
+
+// fieldEnvironment lets expressions use the fields of the metric being rendered.
+type fieldEnvironment struct {
+	fields map[string]interface{}
+	latest *LatestValues
+	fire   fireContext
+}
+
+func (fe *fieldEnvironment) Variable(name string) (interface{}, bool) {
+	value, ok := fe.fields[name]
+	if !ok {
+		return fe.fire.variable(name)
+	}
+	if number, ok := toFloat(value); ok {
+		return number, true
+	}
+	return fmt.Sprint(value), true
+}
+
+func (fe *fieldEnvironment) Latest(metricName, field string) (float64, bool) {
+	if fe.latest == nil {
+		return 0, false
+	}
+	return fe.latest.Latest(metricName, field)
+}
+
+// toFloat returns numeric field values as a float64.
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,94 @@
+package metricCreator
+
+import (
+	"fmt"
+	"time"
+)
+
+// DiurnalProfile shapes values and rates by the time of day and the day of the week.
+// Hourly has a value for the start of each hour from midnight and the values between
+// them are a straight line from one hour to the next, so the curve has no steps. Weekly
+// multiplies the hourly value for each day from Monday and can be empty.
+type DiurnalProfile struct {
+	hourly   []float64
+	weekly   []float64
+	location *time.Location
+}
+
+// NewDiurnalProfile returns a DiurnalProfile. hourly must have 24 values and weekly 7 or
+// none. None of them can be negative. The hours and days are in location, or the local
+// time zone if it is nil.
+func NewDiurnalProfile(hourly, weekly []float64, location *time.Location) (*DiurnalProfile, error) {
+	if len(hourly) != 24 {
+		return nil, fmt.Errorf("hourly needs 24 values, it has %d", len(hourly))
+	}
+	if len(weekly) != 0 && len(weekly) != 7 {
+		return nil, fmt.Errorf("weekly needs 7 values, it has %d", len(weekly))
+	}
+	for _, value := range append(append([]float64{}, hourly...), weekly...) {
+		if value < 0 {
+			return nil, fmt.Errorf("values can not be negative")
+		}
+	}
+	if location == nil {
+		location = time.Local
+	}
+	return &DiurnalProfile{
+		hourly:   hourly,
+		weekly:   weekly,
+		location: location,
+	}, nil
+}
+
+// Factor returns the value of the profile at a point in time.
+func (dp *DiurnalProfile) Factor(at time.Time) float64 {
+	at = at.In(dp.location)
+	hour := at.Hour()
+	through := float64(at.Minute()*60+at.Second()) / 3600
+	value := dp.hourly[hour] + (dp.hourly[(hour+1)%24]-dp.hourly[hour])*through
+	if len(dp.weekly) > 0 {
+		// time.Weekday starts on Sunday.
+		value *= dp.weekly[(int(at.Weekday())+6)%7]
+	}
+	return value
+}
+
+// Range returns the smallest and largest values of the profile.
+func (dp *DiurnalProfile) Range() (float64, float64) {
+	low, high := dp.hourly[0], dp.hourly[0]
+	for _, value := range dp.hourly {
+		if value < low {
+			low = value
+		}
+		if value > high {
+			high = value
+		}
+	}
+	if len(dp.weekly) == 0 {
+		return low, high
+	}
+	lowDay, highDay := dp.weekly[0], dp.weekly[0]
+	for _, value := range dp.weekly {
+		if value < lowDay {
+			lowDay = value
+		}
+		if value > highDay {
+			highDay = value
+		}
+	}
+	return low * lowDay, high * highDay
+}
+
+// Field returns a field source that sends the value of the profile times scale.
+func (dp *DiurnalProfile) Field(scale float64) FieldSource {
+	return &diurnalField{profile: dp, scale: scale}
+}
+
+type diurnalField struct {
+	profile *DiurnalProfile
+	scale   float64
+}
+
+func (df *diurnalField) value(fire fireContext) float64 {
+	return df.profile.Factor(fire.now) * df.scale
+}
@@ -0,0 +1,267 @@
+package metricCreator
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// FanOutAll sends every series each time the event fires.
+	FanOutAll = "all"
+	// FanOutRoundRobin sends the next few series each time the event fires.
+	FanOutRoundRobin = "round_robin"
+	// FanOutRandom sends a few randomly picked series each time the event fires.
+	FanOutRandom = "random"
+	// MaxFanOutSeries is the largest number of series a single fan out can create.
+	MaxFanOutSeries = 1000000
+)
+
+var (
+	// ValidFanOutModes are the ways that a fan out can pick the series to send.
+	ValidFanOutModes = []string{FanOutAll, FanOutRoundRobin, FanOutRandom}
+	// braceExpression matches the first {..} in a string that has no braces inside it.
+	braceExpression = regexp.MustCompile(`\{([^{}]*)\}`)
+	// numericRange matches the inside of a range like {001..500}.
+	numericRange = regexp.MustCompile(`^(-?\d+)\.\.(-?\d+)$`)
+)
+
+// ExpandTagValues turns a fan out value into a list of tag values. The value can be a
+// list of values or a string with brace expressions. A brace expression can be a
+// range like "web-{001..500}", which keeps leading zeros, or a list like "{eu,us}".
+// Strings with more than one brace expression give every combination.
+func ExpandTagValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		values := []string{}
+		for _, item := range v {
+			expanded, err := ExpandTagValues(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, expanded...)
+		}
+		return values, nil
+	case []string:
+		values := []string{}
+		for _, item := range v {
+			expanded, err := expandBraces(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, expanded...)
+		}
+		return values, nil
+	case string:
+		return expandBraces(v)
+	case nil:
+		return nil, fmt.Errorf("fan out values can not be empty")
+	default:
+		return []string{fmt.Sprint(v)}, nil
+	}
+}
+
+func expandBraces(s string) ([]string, error) {
+	location := braceExpression.FindStringSubmatchIndex(s)
+	if location == nil {
+		return []string{s}, nil
+	}
+	prefix, inside, suffix := s[:location[0]], s[location[2]:location[3]], s[location[1]:]
+
+	var parts []string
+	if bounds := numericRange.FindStringSubmatch(inside); bounds != nil {
+		var err error
+		parts, err = expandRange(bounds[1], bounds[2])
+		if err != nil {
+			return nil, err
+		}
+	} else if strings.Contains(inside, ",") {
+		parts = strings.Split(inside, ",")
+	} else {
+		return nil, fmt.Errorf("%s is not a valid range or list in %s", inside, s)
+	}
+
+	rest, err := expandBraces(suffix)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0, len(parts)*len(rest))
+	for _, part := range parts {
+		for _, r := range rest {
+			values = append(values, prefix+part+r)
+		}
+	}
+	return values, nil
+}
+
+// expandRange returns the numbers from start to end. If either end has leading zeros
+// the numbers are padded to the same width.
+func expandRange(start, end string) ([]string, error) {
+	from, err := strconv.Atoi(start)
+	if err != nil {
+		return nil, err
+	}
+	to, err := strconv.Atoi(end)
+	if err != nil {
+		return nil, err
+	}
+	width := 0
+	if (len(start) > 1 && start[0] == '0') || (len(end) > 1 && end[0] == '0') {
+		width = len(start)
+		if len(end) > width {
+			width = len(end)
+		}
+	}
+	step := 1
+	if to < from {
+		step = -1
+	}
+	count := (to-from)*step + 1
+	if count > MaxFanOutSeries {
+		return nil, fmt.Errorf("range %s..%s has more than %d values", start, end, MaxFanOutSeries)
+	}
+	values := make([]string, 0, count)
+	for i := from; ; i += step {
+		values = append(values, fmt.Sprintf("%0*d", width, i))
+		if i == to {
+			break
+		}
+	}
+	return values, nil
+}
+
+// FanOut creates a metric for each combination of the fan out tag values.
+// The metrics are created when they are needed so large fan outs don't use much memory.
+// A FanOut without any fan out tags renders a single metric each fire.
+type FanOut struct {
+	template *MetricTemplate
+	keys     []string
+	values   [][]string
+	total    int
+	mode     string
+	size     int
+	next     int
+	lock     sync.Mutex
+}
+
+// NewFanOut returns a FanOut for the metric template given. fanOut maps tag names to the
+// values described in ExpandTagValues and overrides tags of the same name. mode is one of
+// ValidFanOutModes and size is the number of series sent each fire for round_robin and random.
+func NewFanOut(template *MetricTemplate, fanOut map[string]interface{}, mode string, size int) (*FanOut, error) {
+	if mode == "" {
+		mode = FanOutAll
+	}
+	validMode := false
+	for _, validFanOutMode := range ValidFanOutModes {
+		if mode == validFanOutMode {
+			validMode = true
+		}
+	}
+	if !validMode {
+		return nil, fmt.Errorf("fan out mode %s is not valid. Only %s are valid", mode, strings.Join(ValidFanOutModes, ","))
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	f := &FanOut{
+		template: template,
+		total:    1,
+		mode:     mode,
+		size:     size,
+	}
+	for key := range fanOut {
+		f.keys = append(f.keys, key)
+	}
+	sort.Strings(f.keys)
+	for _, key := range f.keys {
+		values, err := ExpandTagValues(fanOut[key])
+		if err != nil {
+			return nil, fmt.Errorf("fan out tag %s is invalid. Error %s", key, err)
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("fan out tag %s has no values", key)
+		}
+		f.values = append(f.values, values)
+		if f.total*len(values) > MaxFanOutSeries {
+			return nil, fmt.Errorf("fan out of %s has more than %d series", template.Name(), MaxFanOutSeries)
+		}
+		f.total *= len(values)
+	}
+	if f.size > f.total {
+		f.size = f.total
+	}
+	return f, nil
+}
+
+// Len returns the number of series in the fan out.
+func (f *FanOut) Len() int {
+	return f.total
+}
+
+// PerFire returns the number of series that are sent each time the event fires.
+func (f *FanOut) PerFire() int {
+	if f.mode == FanOutAll {
+		return f.total
+	}
+	return f.size
+}
+
+// Metrics returns the metrics to send for one fire of the event. It can be empty if an
+// anomaly leaves a gap.
+func (f *FanOut) Metrics() ([]Metric, error) {
+	var indexes []int
+	switch f.mode {
+	case FanOutRoundRobin:
+		f.lock.Lock()
+		for i := 0; i < f.size; i++ {
+			indexes = append(indexes, f.next)
+			f.next = (f.next + 1) % f.total
+		}
+		f.lock.Unlock()
+	case FanOutRandom:
+		picked := make(map[int]bool, f.size)
+		for len(indexes) < f.size {
+			index := rand.Intn(f.total)
+			if !picked[index] {
+				picked[index] = true
+				indexes = append(indexes, index)
+			}
+		}
+	default:
+		for i := 0; i < f.total; i++ {
+			indexes = append(indexes, i)
+		}
+	}
+
+	fire := f.template.nextFire()
+	metrics := make([]Metric, 0, len(indexes))
+	for _, index := range indexes {
+		metric, err := f.metric(index, fire)
+		// Anomalies can leave gaps where nothing is sent.
+		if err == ErrGap {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// metric creates the metric for a series. The index is split into one position for
+// each fan out tag, like the digits of a number.
+func (f *FanOut) metric(index int, fire fireContext) (*MetricObject, error) {
+	tags := make(map[string]string, len(f.keys))
+	for i := len(f.keys) - 1; i >= 0; i-- {
+		values := f.values[i]
+		tags[f.keys[i]] = values[index%len(values)]
+		index /= len(values)
+	}
+	return f.template.render(tags, fire)
+}
@@ -0,0 +1,57 @@
+package metricCreator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	ilpo "github.com/morfien101/influxLineProtocolOutput"
+)
+
+// Influx format
+// The measurement name is followed by the tags, a space and then the fields.
+// Tags and fields are comma seperated key=value pairs.
+//
+// https://docs.influxdata.com/influxdb/v1.7/write_protocols/line_protocol_reference/
+//
+// Example:
+// metric_name[,tag=value,tag=value] field=value[,field=value]
+
+func lineProtocol(metric *ilpo.MetricContainer) string {
+	return fmt.Sprintf("%s %s", seriesKey(metric), strings.Join(pairFields(metric.Values, "="), ","))
+}
+
+// seriesKey is the measurement and tags part of the line protocol, which is what
+// Influx uses to identify a series.
+func seriesKey(metric *ilpo.MetricContainer) string {
+	if len(metric.Tags) == 0 {
+		return metric.Name
+	}
+	return fmt.Sprintf("%s,%s", metric.Name, strings.Join(pairTags(metric.Tags, "="), ","))
+}
+
+func sortedTagKeys(t map[string]string) []string {
+	keys := make([]string, 0, len(t))
+	for key := range t {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedFieldKeys(f map[string]interface{}) []string {
+	keys := make([]string, 0, len(f))
+	for key := range f {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func pairFields(f map[string]interface{}, seperator string) []string {
+	returnFields := make([]string, len(f))
+	for index, name := range sortedFieldKeys(f) {
+		returnFields[index] = fmt.Sprintf("%s%s%v", name, seperator, f[name])
+	}
+	return returnFields
+}
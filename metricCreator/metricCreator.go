@@ -18,6 +18,7 @@ var (
 type Metric interface {
 	InfluxMetric
 	StatsdMetric
+	SeriesMetric
 }
 
 // InfluxMetric is a datapoint that can be formatted as a influx metric
@@ -32,6 +33,13 @@ type StatsdMetric interface {
 	StatsD() string
 }
 
+// SeriesMetric is a datapoint that can describe the series it belongs to and
+// the values that it holds.
+type SeriesMetric interface {
+	Series() string
+	Fields() map[string]interface{}
+}
+
 // MetricObject is a Influx Line Protocol version of a metric
 type MetricObject struct {
 	mc            *ilpo.MetricContainer
@@ -66,9 +74,10 @@ func (m *MetricObject) String() string {
 	return m.Influx()
 }
 
-// Influx returns the metric in Influx Line Protocol Output
+// Influx returns the metric in Influx Line Protocol Output.
+// Tags and fields are sorted by key so the same metric always renders the same way.
 func (m *MetricObject) Influx() string {
-	return m.mc.Output()
+	return lineProtocol(m.mc)
 }
 
 // StatsD returns the metric in StatsD format with the requested tagging format.
@@ -82,3 +91,17 @@ func (m *MetricObject) StatsD() string {
 		return statsdWithDDTagging(m.mc)
 	}
 }
+
+// Series returns the measurement name and sorted tags that identify the series of the metric.
+func (m *MetricObject) Series() string {
+	return seriesKey(m.mc)
+}
+
+// Fields returns a copy of the fields in the metric.
+func (m *MetricObject) Fields() map[string]interface{} {
+	fields := make(map[string]interface{}, len(m.mc.Values))
+	for key, value := range m.mc.Values {
+		fields[key] = value
+	}
+	return fields
+}
@@ -1,8 +1,13 @@
 package metricCreator
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewMetric(t *testing.T) {
@@ -287,3 +292,474 @@ func TestDefaultTaggingFormat(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestNewAnnotation(t *testing.T) {
+	m, err := NewAnnotation("events", "Outage", `database "db1" down`, []string{"outage", "db"}, map[string]string{"service": "db"})
+	if err != nil {
+		t.Logf("Got an error creating an annotation. Error: %s", err)
+		t.FailNow()
+	}
+	re := regexp.MustCompile(`^events,service=db (?:(?:title="Outage"|text="database \\"db1\\" down"|tags="outage,db"),?){3}$`)
+	if !re.MatchString(m.Influx()) {
+		t.Logf("Annotation didn't give the expected output.\nExpected regex: %s\nGot: %s", re, m.Influx())
+		t.Fail()
+	}
+}
+
+func TestSortedOutput(t *testing.T) {
+	m, _ := NewMetric(
+		"sorted",
+		map[string]string{"zone": "b", "app": "a", "metric_type": "gauge"},
+		map[string]interface{}{"z": 1, "a": 2},
+	)
+	expectedInflux := "sorted,app=a,metric_type=gauge,zone=b a=2,z=1"
+	if m.Influx() != expectedInflux {
+		t.Logf("Influx output is not sorted.\nExpected: %s\nGot: %s", expectedInflux, m.Influx())
+		t.Fail()
+	}
+	expectedStatsD := "sorted_a:2|g|#app:a,zone:b\nsorted_z:1|g|#app:a,zone:b"
+	if m.StatsD() != expectedStatsD {
+		t.Logf("StatsD output is not sorted.\nExpected: %s\nGot: %s", expectedStatsD, m.StatsD())
+		t.Fail()
+	}
+}
+
+func TestExpandTagValues(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected []string
+	}{
+		{value: "web-{001..003}", expected: []string{"web-001", "web-002", "web-003"}},
+		{value: "{eu,us}-{1..2}", expected: []string{"eu-1", "eu-2", "us-1", "us-2"}},
+		{value: "{3..1}", expected: []string{"3", "2", "1"}},
+		{value: []interface{}{"a", "b-{1..2}", 7}, expected: []string{"a", "b-1", "b-2", "7"}},
+		{value: "plain", expected: []string{"plain"}},
+	}
+	for _, test := range tests {
+		values, err := ExpandTagValues(test.value)
+		if err != nil {
+			t.Logf("Failed to expand %v. Error: %s", test.value, err)
+			t.Fail()
+			continue
+		}
+		if strings.Join(values, ",") != strings.Join(test.expected, ",") {
+			t.Logf("Expanding %v expected %v. Got: %v", test.value, test.expected, values)
+			t.Fail()
+		}
+	}
+	if _, err := ExpandTagValues("web-{oops}"); err == nil {
+		t.Logf("Expected an error for a bad brace expression.")
+		t.Fail()
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	fanOut := map[string]interface{}{"host": "web-{1..500}", "region": []interface{}{"eu", "us"}}
+	f, err := NewFanOut(NewMetricTemplate("cpu", map[string]string{"host": "replaced"}, map[string]interface{}{"value": 1}, nil), fanOut, FanOutAll, 0)
+	if err != nil {
+		t.Logf("Failed to create a fan out. Error: %s", err)
+		t.FailNow()
+	}
+	metrics, err := f.Metrics()
+	if err != nil || f.Len() != 1000 || len(metrics) != 1000 {
+		t.Logf("Expected 1000 series. Got: %d metrics from %d series, error %v", len(metrics), f.Len(), err)
+		t.FailNow()
+	}
+	seen := make(map[string]bool)
+	for _, metric := range metrics {
+		seen[metric.Series()] = true
+	}
+	if len(seen) != 1000 || !seen["cpu,host=web-500,region=us"] {
+		t.Logf("Expected 1000 unique series. Got: %d", len(seen))
+		t.Fail()
+	}
+
+	f, _ = NewFanOut(NewMetricTemplate("cpu", nil, map[string]interface{}{"value": 1}, nil), fanOut, FanOutRoundRobin, 3)
+	first, _ := f.Metrics()
+	second, _ := f.Metrics()
+	if len(first) != 3 || first[2].Series() != "cpu,host=web-2,region=eu" || second[0].Series() != "cpu,host=web-2,region=us" {
+		t.Logf("Round robin did not walk the series in order. Got: %s then %s", first[2].Series(), second[0].Series())
+		t.Fail()
+	}
+
+	f, _ = NewFanOut(NewMetricTemplate("cpu", nil, map[string]interface{}{"value": 1}, nil), fanOut, FanOutRandom, 10)
+	random, _ := f.Metrics()
+	unique := make(map[string]bool)
+	for _, metric := range random {
+		unique[metric.Series()] = true
+	}
+	if len(unique) != 10 {
+		t.Logf("Expected 10 different random series. Got: %d", len(unique))
+		t.Fail()
+	}
+
+	if _, err := NewFanOut(NewMetricTemplate("cpu", nil, nil, nil), map[string]interface{}{"a": "{1..1000}", "b": "{1..1001}"}, FanOutAll, 0); err == nil {
+		t.Logf("Expected an error for a fan out over the series limit.")
+		t.Fail()
+	}
+}
+
+func TestRandomTags(t *testing.T) {
+	weighted, err := NewWeightedTag(map[string]float64{"200": 95, "500": 3, "404": 2})
+	if err != nil {
+		t.Logf("Failed to create a weighted tag. Error: %s", err)
+		t.FailNow()
+	}
+	zipf, err := NewZipfTag([]string{"a", "b", "c", "d"}, 2, 1)
+	if err != nil {
+		t.Logf("Failed to create a zipf tag. Error: %s", err)
+		t.FailNow()
+	}
+	template := NewMetricTemplate("requests", map[string]string{"service": "api"}, map[string]interface{}{"count": 1}, map[string]TagPicker{
+		"status_code": weighted,
+		"user":        zipf,
+	})
+
+	counts := make(map[string]int)
+	users := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		metric, err := template.Render()
+		if err != nil {
+			t.Logf("Failed to render the metric. Error: %s", err)
+			t.FailNow()
+		}
+		tags := metric.mc.Tags
+		counts[tags["status_code"]]++
+		users[tags["user"]]++
+	}
+	if counts["200"] < 9000 || counts["500"] == 0 || counts["404"] == 0 || len(counts) != 3 {
+		t.Logf("Weighted tag values are not in proportion. Got: %v", counts)
+		t.Fail()
+	}
+	if users["a"] <= users["b"] || users["b"] <= users["d"] {
+		t.Logf("Zipf tag values should get less common. Got: %v", users)
+		t.Fail()
+	}
+
+	if _, err := NewWeightedTag(map[string]float64{"200": 0}); err == nil {
+		t.Logf("Expected an error for a zero weight.")
+		t.Fail()
+	}
+	if _, err := NewZipfTag([]string{"a"}, 1, 1); err == nil {
+		t.Logf("Expected an error for a zipf s of 1.")
+		t.Fail()
+	}
+}
+
+func TestDerivedFields(t *testing.T) {
+	fields := map[string]interface{}{"requests": 200, "error_rate": 0.05}
+	derived, err := NewDerivedFields(map[string]string{
+		"errors":    "requests * error_rate",
+		"successes": "requests - errors",
+		"upstream":  `latest("db_queries", "count") * 2`,
+	}, fields)
+	if err != nil {
+		t.Logf("Failed to create derived fields. Error: %s", err)
+		t.FailNow()
+	}
+	if names := derived.Names(); strings.Join(names, ",") != "errors,successes,upstream" {
+		t.Logf("errors must be worked out before successes. Got: %v", names)
+		t.Fail()
+	}
+
+	latest := NewLatestValues()
+	latest.Record("db_queries", map[string]interface{}{"count": 21, "host": "db1"})
+	template := NewMetricTemplate("requests", map[string]string{"service": "api"}, fields, nil)
+	template.SetDerivedFields(derived)
+	template.SetLatestValues(latest)
+	metric, err := template.Render()
+	if err != nil {
+		t.Logf("Failed to render the metric. Error: %s", err)
+		t.FailNow()
+	}
+	out := metric.Influx()
+	for _, expected := range []string{"errors=10", "successes=190", "upstream=42"} {
+		if !strings.Contains(out, expected) {
+			t.Logf("Expected %s in %s", expected, out)
+			t.Fail()
+		}
+	}
+	if len(fields) != 2 {
+		t.Logf("Rendering must not change the template fields. Got: %v", fields)
+		t.Fail()
+	}
+
+	bad := map[string]map[string]string{
+		"is also a field":         {"requests": "1"},
+		"which is not":            {"errors": "requests * missing"},
+		"in a loop":               {"a": "b + 1", "b": "a + 1"},
+		"is invalid":              {"errors": "requests *"},
+		"is not a known function": {"errors": "nope(requests)"},
+	}
+	for expected, definitions := range bad {
+		_, err := NewDerivedFields(definitions, fields)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Logf("Expected an error with %q. Got: %v", expected, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestExpressionFields(t *testing.T) {
+	fields, expressions := ExpressionFields(map[string]interface{}{
+		"base":  100,
+		"host":  "web1",
+		"value": "=base + iteration * 10",
+		"slice": `=if(timeslice == "outage", 1, 0)`,
+		"age":   "=t",
+	})
+	if len(fields) != 2 || len(expressions) != 3 || expressions["value"] != "base + iteration * 10" {
+		t.Logf("Expected 2 fixed fields and 3 expressions. Got: %v and %v", fields, expressions)
+		t.FailNow()
+	}
+	derived, err := NewDerivedFields(expressions, fields)
+	if err != nil {
+		t.Logf("Failed to create derived fields. Error: %s", err)
+		t.FailNow()
+	}
+	template := NewMetricTemplate("load", nil, fields, nil)
+	template.SetDerivedFields(derived)
+	template.SetTimeslice("outage")
+	template.SetStart(time.Now().Add(-time.Minute))
+	for i, expected := range []string{"value=100", "value=110", "value=120"} {
+		metric, err := template.Render()
+		if err != nil {
+			t.Logf("Failed to render the metric. Error: %s", err)
+			t.FailNow()
+		}
+		out := metric.Influx()
+		if !strings.Contains(out, expected) || !strings.Contains(out, "slice=1") {
+			t.Logf("Render %d expected %s and slice=1 in %s", i, expected, out)
+			t.Fail()
+		}
+		if age := metric.Fields()["age"].(float64); age < 60 || age > 70 {
+			t.Logf("t should be about 60 seconds. Got: %v", age)
+			t.Fail()
+		}
+	}
+}
+
+func TestCSVSeries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csv")
+	if err != nil {
+		t.Logf("Failed to create a temp dir. Error: %s", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cpu.csv")
+	data := "time,host,value\n2020-01-01T00:00:00Z,web1,10\n2020-01-01T00:00:30Z,web1,20\n2020-01-01T00:01:00Z,web1,30\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Logf("Failed to write %s. Error: %s", path, err)
+		t.FailNow()
+	}
+
+	tests := []struct {
+		name            string
+		timestampColumn string
+		loop            bool
+		fires           []fireContext
+		expected        []float64
+	}{
+		{
+			name:     "rows looping",
+			loop:     true,
+			fires:    []fireContext{{iteration: 0}, {iteration: 2}, {iteration: 3}},
+			expected: []float64{10, 30, 10},
+		},
+		{
+			name:     "rows stopping at the last",
+			fires:    []fireContext{{iteration: 1}, {iteration: 5}},
+			expected: []float64{20, 30},
+		},
+		{
+			name:            "timestamps looping",
+			timestampColumn: "time",
+			loop:            true,
+			fires:           []fireContext{{elapsed: 29}, {elapsed: 45}, {elapsed: 61}},
+			expected:        []float64{10, 20, 10},
+		},
+		{
+			name:            "timestamps stopping at the last",
+			timestampColumn: "time",
+			fires:           []fireContext{{elapsed: 30}, {elapsed: 600}},
+			expected:        []float64{20, 30},
+		},
+	}
+	for _, test := range tests {
+		series, err := NewCSVSeries(path, "value", test.timestampColumn, test.loop)
+		if err != nil {
+			t.Logf("%s: failed to read the series. Error: %s", test.name, err)
+			t.Fail()
+			continue
+		}
+		for i, fire := range test.fires {
+			if value := series.value(fire); value != test.expected[i] {
+				t.Logf("%s: fire %d should be %v. Got: %v", test.name, i, test.expected[i], value)
+				t.Fail()
+			}
+		}
+	}
+
+	template := NewMetricTemplate("cpu", nil, map[string]interface{}{}, nil)
+	series, _ := NewCSVSeries(path, "value", "", true)
+	template.SetFieldSources(map[string]FieldSource{"usage": series})
+	derived, _ := NewDerivedFields(map[string]string{"idle": "100 - usage"}, map[string]interface{}{"usage": 0})
+	template.SetDerivedFields(derived)
+	template.Render()
+	metric, err := template.Render()
+	if err != nil || !strings.Contains(metric.Influx(), "usage=20") || !strings.Contains(metric.Influx(), "idle=80") {
+		t.Logf("Expected usage=20 and idle=80 on the second render. Got: %v, Error: %v", metric, err)
+		t.Fail()
+	}
+
+	bad := map[string]string{
+		"has no column":        "time,host\n1,web1\n",
+		"is not a number":      "time,value\n1,high\n",
+		"has no rows":          "time,value\n",
+		"earlier than the row": "time,value\n10,1\n5,2\n",
+		"is not RFC3339":       "time,value\nyesterday,1\n",
+	}
+	for expected, contents := range bad {
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Logf("Failed to write %s. Error: %s", path, err)
+			t.FailNow()
+		}
+		_, err := NewCSVSeries(path, "value", "time", false)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Logf("Expected an error with %q. Got: %v", expected, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestAnomalies(t *testing.T) {
+	// gap marks a fire that should not send anything.
+	const gap = -1
+	tests := []struct {
+		kind     string
+		fields   []string
+		factor   float64
+		amount   float64
+		expected []float64
+	}{
+		{kind: AnomalySpike, factor: 5, expected: []float64{10, 55, 60, 13}},
+		{kind: AnomalyStep, fields: []string{"value"}, amount: -4, expected: []float64{10, 7, 8, 13}},
+		{kind: AnomalyDropToZero, expected: []float64{10, 0, 0, 13}},
+		{kind: AnomalyGap, expected: []float64{10, gap, gap, 13}},
+		{kind: AnomalyFlatline, fields: []string{"value"}, expected: []float64{10, 11, 11, 13}},
+	}
+	for _, test := range tests {
+		// The anomaly covers fires 1 and 2 of every 4.
+		anomaly, err := NewAnomaly(test.kind, test.fields, 1, 2, test.factor, test.amount)
+		if err != nil {
+			t.Logf("%s: failed to create the anomaly. Error: %s", test.kind, err)
+			t.Fail()
+			continue
+		}
+		template := NewMetricTemplate("load", nil, map[string]interface{}{}, nil)
+		derived, _ := NewDerivedFields(map[string]string{"value": "10 + iteration % 4"}, map[string]interface{}{})
+		template.SetDerivedFields(derived)
+		template.SetAnomalies([]*Anomaly{anomaly}, 4)
+		for cycle := 0; cycle < 2; cycle++ {
+			for i, expected := range test.expected {
+				metric, err := template.Render()
+				if expected == gap {
+					if err != ErrGap {
+						t.Logf("%s: fire %d should be a gap. Got: %v, Error: %v", test.kind, i, metric, err)
+						t.Fail()
+					}
+					continue
+				}
+				if err != nil {
+					t.Logf("%s: failed to render. Error: %s", test.kind, err)
+					t.FailNow()
+				}
+				if metric == nil || metric.Fields()["value"] != expected {
+					t.Logf("%s: fire %d expected %v. Got: %v", test.kind, i, expected, metric)
+					t.Fail()
+				}
+			}
+		}
+	}
+
+	noise, _ := NewAnomaly(AnomalyNoiseBurst, nil, 0, 0, 0, 5)
+	fields := map[string]interface{}{"value": 100, "host": "web1"}
+	noise.apply(0, "", fields)
+	if fields["value"] == 100 || fields["host"] != "web1" {
+		t.Logf("noise_burst should change numbers only. Got: %v", fields)
+		t.Fail()
+	}
+
+	bad := map[string]struct {
+		kind   string
+		factor float64
+		amount float64
+	}{
+		"is not valid":          {kind: "wobble"},
+		"need a factor":         {kind: AnomalySpike},
+		"amount greater than 0": {kind: AnomalyNoiseBurst},
+	}
+	for expected, b := range bad {
+		_, err := NewAnomaly(b.kind, nil, 0, 0, b.factor, b.amount)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Logf("Expected an error with %q. Got: %v", expected, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestDiurnalProfile(t *testing.T) {
+	hourly := make([]float64, 24)
+	for hour := range hourly {
+		hourly[hour] = float64(hour)
+	}
+	weekly := []float64{1, 1, 1, 1, 1, 0.5, 0.5}
+	utc := time.UTC
+	profile, err := NewDiurnalProfile(hourly, weekly, utc)
+	if err != nil {
+		t.Logf("Failed to create the profile. Error: %s", err)
+		t.FailNow()
+	}
+	// 2 January 2023 is a Monday.
+	tests := map[time.Time]float64{
+		time.Date(2023, 1, 2, 6, 0, 0, 0, utc):   6,
+		time.Date(2023, 1, 2, 6, 30, 0, 0, utc):  6.5,
+		time.Date(2023, 1, 2, 23, 30, 0, 0, utc): 11.5,
+		time.Date(2023, 1, 7, 10, 0, 0, 0, utc):  5,
+		time.Date(2023, 1, 8, 10, 45, 0, 0, utc): 5.375,
+	}
+	for at, expected := range tests {
+		if factor := profile.Factor(at); factor != expected {
+			t.Logf("The profile at %s should be %v. Got: %v", at, expected, factor)
+			t.Fail()
+		}
+	}
+	if low, high := profile.Range(); low != 0 || high != 23 {
+		t.Logf("The profile should range from 0 to 23. Got: %v to %v", low, high)
+		t.Fail()
+	}
+
+	// The field follows the clock of the template rather than the wall clock.
+	now := time.Date(2023, 1, 2, 12, 0, 0, 0, utc)
+	template := NewMetricTemplate("requests", nil, map[string]interface{}{}, nil)
+	template.SetFieldSources(map[string]FieldSource{"count": profile.Field(100)})
+	template.SetClock(func() time.Time { return now })
+	metric, err := template.Render()
+	if err != nil || metric.Fields()["count"] != 1200.0 {
+		t.Logf("Expected a count of 1200 at noon. Got: %v, Error: %v", metric, err)
+		t.Fail()
+	}
+
+	bad := map[string][][]float64{
+		"hourly needs 24": {hourly[:12], nil},
+		"weekly needs 7":  {hourly, weekly[:3]},
+		"can not be":      {hourly, []float64{1, 1, 1, 1, 1, 1, -1}},
+	}
+	for expected, values := range bad {
+		_, err := NewDiurnalProfile(values[0], values[1], nil)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Logf("Expected an error with %q. Got: %v", expected, err)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package metricCreator
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrGap is returned instead of a metric when an anomaly leaves a gap where nothing is
+// sent.
+var ErrGap = errors.New("anomaly left a gap")
+
+// MetricTemplate creates a new metric each time it is rendered. Tags with a TagPicker
+// get a new value every time, field sources move on, derived fields are worked out again and
+// anomalies are laid over the result.
+type MetricTemplate struct {
+	name          string
+	tags          map[string]string
+	fields        map[string]interface{}
+	pickers       map[string]TagPicker
+	pickerKeys    []string
+	taggingFormat string
+	derived       *DerivedFields
+	sources       map[string]FieldSource
+	anomalies     []*Anomaly
+	cycle         int
+	latest        *LatestValues
+	start         time.Time
+	clock         func() time.Time
+	timeslice     string
+	iteration     int
+	lock          sync.Mutex
+}
+
+// NewMetricTemplate returns a MetricTemplate. pickers override tags of the same name and
+// can be nil.
+func NewMetricTemplate(name string, tags map[string]string, fields map[string]interface{}, pickers map[string]TagPicker) *MetricTemplate {
+	mt := &MetricTemplate{
+		name:    name,
+		tags:    tags,
+		fields:  fields,
+		pickers: pickers,
+		start:   time.Now(),
+		clock:   time.Now,
+	}
+	for key := range pickers {
+		mt.pickerKeys = append(mt.pickerKeys, key)
+	}
+	sort.Strings(mt.pickerKeys)
+	return mt
+}
+
+// Name returns the name of the metrics that are rendered.
+func (mt *MetricTemplate) Name() string {
+	return mt.name
+}
+
+// RandomTags returns the sorted names of the tags that are picked each render.
+func (mt *MetricTemplate) RandomTags() []string {
+	return mt.pickerKeys
+}
+
+// SetTaggingFormat sets the statsd tagging format of the metrics that are rendered.
+func (mt *MetricTemplate) SetTaggingFormat(requestedFormat string) error {
+	if err := new(MetricObject).SetTaggingFormat(requestedFormat); err != nil {
+		return err
+	}
+	mt.taggingFormat = requestedFormat
+	return nil
+}
+
+// SetDerivedFields adds fields that are worked out each time the template is rendered.
+func (mt *MetricTemplate) SetDerivedFields(derived *DerivedFields) {
+	mt.derived = derived
+}
+
+// SetFieldSources adds fields that take their values from a FieldSource, such as a CSV
+// file or a diurnal profile. Derived fields can use them.
+func (mt *MetricTemplate) SetFieldSources(sources map[string]FieldSource) {
+	mt.sources = sources
+}
+
+// SetAnomalies lays anomalies over the fields after they are generated. The fires of the
+// anomalies count from 0 again every cycle renders, which is normally the repeat of the
+// event. A cycle of 0 never starts again.
+func (mt *MetricTemplate) SetAnomalies(anomalies []*Anomaly, cycle int) {
+	mt.anomalies = anomalies
+	mt.cycle = cycle
+}
+
+// SetLatestValues gives derived fields the latest values of other metrics.
+func (mt *MetricTemplate) SetLatestValues(latest *LatestValues) {
+	mt.latest = latest
+}
+
+// SetStart sets the time that t counts from in derived fields. It defaults to the time
+// the template was created.
+func (mt *MetricTemplate) SetStart(start time.Time) {
+	mt.start = start
+}
+
+// SetTimeslice sets the name of the time slice that derived fields see as timeslice.
+func (mt *MetricTemplate) SetTimeslice(name string) {
+	mt.timeslice = name
+}
+
+// SetClock sets where the template gets the time of each fire from. It defaults to the
+// wall clock and can be replaced to generate metrics for other times.
+func (mt *MetricTemplate) SetClock(clock func() time.Time) {
+	mt.clock = clock
+}
+
+// nextFire returns the context for the next fire and counts it.
+func (mt *MetricTemplate) nextFire() fireContext {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+	now := mt.clock()
+	fire := fireContext{
+		now:       now,
+		elapsed:   now.Sub(mt.start).Seconds(),
+		iteration: mt.iteration,
+		timeslice: mt.timeslice,
+	}
+	mt.iteration++
+	return fire
+}
+
+// Render returns a new metric with the random tags picked. The error is ErrGap if an
+// anomaly leaves a gap.
+func (mt *MetricTemplate) Render() (*MetricObject, error) {
+	return mt.render(nil, mt.nextFire())
+}
+
+// render returns a new metric with extra tags added on top of the template tags.
+// Every metric rendered for the same fire shares its context.
+func (mt *MetricTemplate) render(extraTags map[string]string, fire fireContext) (*MetricObject, error) {
+	tags := make(map[string]string, len(mt.tags)+len(mt.pickers)+len(extraTags))
+	for key, value := range mt.tags {
+		tags[key] = value
+	}
+	for _, key := range mt.pickerKeys {
+		tags[key] = mt.pickers[key].Pick()
+	}
+	for key, value := range extraTags {
+		tags[key] = value
+	}
+	fields := mt.fields
+	if mt.derived != nil || len(mt.sources) > 0 || len(mt.anomalies) > 0 {
+		fields = make(map[string]interface{}, len(mt.fields)+len(mt.sources))
+		for key, value := range mt.fields {
+			fields[key] = value
+		}
+		for key, source := range mt.sources {
+			fields[key] = source.value(fire)
+		}
+	}
+	if mt.derived != nil {
+		if err := mt.derived.apply(fields, mt.latest, fire); err != nil {
+			return nil, err
+		}
+	}
+	if len(mt.anomalies) > 0 {
+		count := fire.iteration
+		if mt.cycle > 0 {
+			count %= mt.cycle
+		}
+		series := seriesName(extraTags)
+		for _, anomaly := range mt.anomalies {
+			if anomaly.active(count) && !anomaly.apply(count, series, fields) {
+				return nil, ErrGap
+			}
+		}
+	}
+	metric, err := NewMetric(mt.name, tags, fields)
+	if err != nil {
+		return nil, err
+	}
+	metric.taggingFormat = mt.taggingFormat
+	return metric, nil
+}
@@ -0,0 +1,88 @@
+package metricCreator
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TagPicker picks the value of a tag each time a metric is rendered.
+type TagPicker interface {
+	Pick() string
+}
+
+// WeightedTag picks values in proportion to their weights.
+type WeightedTag struct {
+	values     []string
+	cumulative []float64
+	total      float64
+}
+
+// NewWeightedTag returns a WeightedTag for the values and weights given.
+// The weights don't need to add up to 100.
+func NewWeightedTag(weights map[string]float64) (*WeightedTag, error) {
+	if len(weights) == 0 {
+		return nil, fmt.Errorf("weighted tags need at least one value")
+	}
+	wt := &WeightedTag{}
+	for value := range weights {
+		wt.values = append(wt.values, value)
+	}
+	sort.Strings(wt.values)
+	for _, value := range wt.values {
+		if weights[value] <= 0 {
+			return nil, fmt.Errorf("weight of %s must be a positive number", value)
+		}
+		wt.total += weights[value]
+		wt.cumulative = append(wt.cumulative, wt.total)
+	}
+	return wt, nil
+}
+
+// Pick returns a value.
+func (wt *WeightedTag) Pick() string {
+	target := rand.Float64() * wt.total
+	index := sort.Search(len(wt.cumulative), func(i int) bool {
+		return wt.cumulative[i] > target
+	})
+	if index == len(wt.values) {
+		index--
+	}
+	return wt.values[index]
+}
+
+// ZipfTag picks values with a zipf distribution. The first value is the most common
+// and each value after it is less common than the one before.
+type ZipfTag struct {
+	values []string
+	zipf   *rand.Zipf
+	lock   sync.Mutex
+}
+
+// NewZipfTag returns a ZipfTag for the values given. s must be greater than 1 and
+// controls how skewed the values are. v must be 1 or more, 0 uses 1.
+func NewZipfTag(values []string, s, v float64) (*ZipfTag, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("zipf tags need at least one value")
+	}
+	if v == 0 {
+		v = 1
+	}
+	if s <= 1 || v < 1 {
+		return nil, fmt.Errorf("zipf s must be greater than 1 and v must be 1 or more")
+	}
+	source := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &ZipfTag{
+		values: values,
+		zipf:   rand.NewZipf(source, s, v, uint64(len(values)-1)),
+	}, nil
+}
+
+// Pick returns a value.
+func (zt *ZipfTag) Pick() string {
+	zt.lock.Lock()
+	defer zt.lock.Unlock()
+	return zt.values[zt.zipf.Uint64()]
+}
@@ -33,10 +33,8 @@ func digestTags(tags map[string]string) (newTags map[string]string, metricType,
 
 func pairTags(t map[string]string, seperator string) []string {
 	returnTags := make([]string, len(t))
-	index := 0
-	for name, value := range t {
-		returnTags[index] = fmt.Sprintf("%s%s%s", name, seperator, value)
-		index++
+	for index, name := range sortedTagKeys(t) {
+		returnTags[index] = fmt.Sprintf("%s%s%s", name, seperator, t[name])
 	}
 	return returnTags
 }
@@ -77,11 +75,9 @@ func statsdWithDDTagging(metric *ilpo.MetricContainer) string {
 
 	// for each field attach the value, type, samplerate and tags.
 	metrics := make([]string, len(metric.Values))
-	index := 0
-	for field, value := range metric.Values {
-		measurement := fmt.Sprintf("%s_%s:%v", metric.Name, field, value)
+	for index, field := range sortedFieldKeys(metric.Values) {
+		measurement := fmt.Sprintf("%s_%s:%v", metric.Name, field, metric.Values[field])
 		metrics[index] = fmt.Sprintf("%s|%s", measurement, metadata)
-		index++
 	}
 	// return with \n seperation
 	return strings.Join(metrics, "\n")
@@ -111,8 +107,8 @@ func statsdWithInfluxTagging(metric *ilpo.MetricContainer) string {
 	metadata := strings.Join(components, "|")
 	// for each field attach the value, type, samplerate and tags.
 	metrics := make([]string, len(metric.Values))
-	index := 0
-	for field, value := range metric.Values {
+	for index, field := range sortedFieldKeys(metric.Values) {
+		value := metric.Values[field]
 		measurement := fmt.Sprintf("%s_%v", metric.Name, field)
 		if len(tagsString) > 0 {
 			// inject tags
@@ -120,7 +116,6 @@ func statsdWithInfluxTagging(metric *ilpo.MetricContainer) string {
 		}
 		measurement = fmt.Sprintf("%s:%v", measurement, value)
 		metrics[index] = fmt.Sprintf("%s|%s", measurement, metadata)
-		index++
 	}
 
 	// return with \n seperation
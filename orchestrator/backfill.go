@@ -0,0 +1,66 @@
+package orchestrator
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/silverstagtech/teller/config"
+)
+
+// EnableBackfill makes the story send the points it would have sent over the period
+// before now, running speed times faster than the wall clock. Influx points get the
+// simulated time that they are for and the story stops once the simulated clock catches
+// up with now. Statsd, file, http and grafana can't be sent points with a time in the
+// past so a story that uses them can't be backfilled. It must be called before Start.
+func (o *Orchestrator) EnableBackfill(period time.Duration, speed float64) error {
+	if period <= 0 {
+		return fmt.Errorf("Backfill period must be more than 0")
+	}
+	if speed < 1 {
+		return fmt.Errorf("Backfill speed must be 1 or more")
+	}
+	for _, timeline := range o.config.Story.TimeLines {
+		events := []*config.Event{}
+		for _, timeslice := range timeline.Timeslices {
+			events = append(events, timeslice.Events...)
+			if stateEvent := timeline.StateEvent(timeslice.Name); stateEvent != nil {
+				events = append(events, stateEvent)
+			}
+		}
+		for _, event := range events {
+			if err := backfillable(event); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The simulated clock stops at now so that the points sent while the story stops after
+	// catching up are not in the future.
+	realStart := time.Now()
+	simulatedStart := realStart.Add(-period)
+	o.clock = func() time.Time {
+		now := time.Now()
+		simulated := simulatedStart.Add(time.Duration(float64(now.Sub(realStart)) * speed))
+		if simulated.After(now) {
+			return now
+		}
+		return simulated
+	}
+	o.backfill = period
+	o.speed = speed
+	return nil
+}
+
+// backfillable returns an error if the event sends something that has no time of its own.
+func backfillable(event *config.Event) error {
+	switch event.Type {
+	case influxEvent, sleeperEvent:
+		return nil
+	case annotationEvent:
+		if event.Annotation == nil || event.Annotation.GrafanaConnectionID == "" {
+			return nil
+		}
+		return fmt.Errorf("Event %s can not be backfilled. Grafana annotations can not be sent with a time in the past", event.MetricName)
+	}
+	return fmt.Errorf("Event %s can not be backfilled. Only influx points have times but it is a %s event", event.MetricName, event.Type)
+}
@@ -0,0 +1,124 @@
+package orchestrator
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/silverstagtech/loggos"
+	"github.com/silverstagtech/teller/config"
+	"github.com/silverstagtech/teller/metricCreator"
+	"github.com/silverstagtech/teller/trigger"
+)
+
+// sendFunc sends a metric to its connection. at is the time the point is for, or the
+// zero time if it should be sent without a time of its own.
+type sendFunc func(metric metricCreator.Metric, at time.Time)
+
+// deliveries keeps the points that are being held back so that they can be thrown away
+// when the story stops rather than sent to a connection that has closed.
+type deliveries struct {
+	lock    sync.Mutex
+	pending map[*time.Timer]bool
+	stopped bool
+}
+
+func newDeliveries() *deliveries {
+	return &deliveries{
+		pending: make(map[*time.Timer]bool),
+	}
+}
+
+// later calls f after d unless the deliveries are stopped first.
+func (ds *deliveries) later(d time.Duration, f func()) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	if ds.stopped {
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		// The lock is held while sending so that stop can't close the connections under it.
+		ds.lock.Lock()
+		defer ds.lock.Unlock()
+		if ds.stopped {
+			return
+		}
+		delete(ds.pending, t)
+		f()
+	})
+	ds.pending[t] = true
+}
+
+// stop throws away the points that are still held back.
+func (ds *deliveries) stop() {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	ds.stopped = true
+	for t := range ds.pending {
+		t.Stop()
+	}
+	if len(ds.pending) > 0 {
+		jm := loggos.JSONInfoln("Throwing away delayed points.")
+		jm.Add("points", len(ds.pending))
+		loggos.SendJSON(jm)
+	}
+	ds.pending = make(map[*time.Timer]bool)
+}
+
+// chance is true with the probability given.
+func chance(probability float64) bool {
+	return probability > 0 && rand.Float64() < probability
+}
+
+// deliver sends a metric with the imperfections that the delivery options of the event
+// ask for. Points can be dropped, sent twice, sent with a time in the past or held back
+// and sent late with the time they were made. Only points that are sent update the
+// latest values that derived fields can use.
+func (o *Orchestrator) deliver(event *config.Event, metric metricCreator.Metric, ship sendFunc) {
+	send := func(metric metricCreator.Metric, at time.Time) {
+		ship(metric, at)
+		o.latest.Record(event.MetricName, metric.Fields())
+	}
+	delivery := event.Delivery
+	if delivery == nil {
+		send(metric, time.Time{})
+		return
+	}
+	if chance(delivery.Drop) {
+		jm := loggos.JSONDebugln("Dropping point.")
+		jm.Add("event_id", event.ConnectionID)
+		jm.Add("series", metric.Series())
+		loggos.SendJSON(jm)
+		return
+	}
+
+	var at time.Time
+	copies := 1
+	if chance(delivery.Duplicate) {
+		copies = 2
+		// Both copies need the same time to be real duplicates.
+		at = o.clock()
+	}
+	if delivery.Backdate != nil && chance(delivery.Backdate.Probability) {
+		at = o.clock().Add(-delivery.Backdate.Duration())
+	}
+	sendCopies := func() {
+		for i := 0; i < copies; i++ {
+			send(metric, at)
+		}
+	}
+	if delivery.Delay != nil && chance(delivery.Delay.Probability) {
+		if at.IsZero() {
+			at = o.clock()
+		}
+		jm := loggos.JSONDebugln("Delaying point.")
+		jm.Add("event_id", event.ConnectionID)
+		jm.Add("series", metric.Series())
+		jm.Add("delay", delivery.Delay.Duration().String())
+		loggos.SendJSON(jm)
+		o.deliveries.later(trigger.Scale(delivery.Delay.Duration(), o.speed), sendCopies)
+		return
+	}
+	sendCopies()
+}
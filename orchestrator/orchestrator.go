@@ -1,49 +1,97 @@
 package orchestrator
 
 import (
+	"fmt"
 	"os"
+	"sort"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/silverstagtech/loggos"
 	"github.com/silverstagtech/teller/config"
+	"github.com/silverstagtech/teller/fileShipper"
+	"github.com/silverstagtech/teller/grafanaShipper"
+	"github.com/silverstagtech/teller/httpShipper"
 	"github.com/silverstagtech/teller/influxShipper"
 	"github.com/silverstagtech/teller/metricCreator"
+	"github.com/silverstagtech/teller/recorder"
 	"github.com/silverstagtech/teller/statsdShipper"
 	"github.com/silverstagtech/teller/trigger"
 )
 
 const (
-	influxEvent  = "influx"
-	statsdEvent  = "statsd"
-	sleeperEvent = "sleeper"
+	influxEvent     = "influx"
+	statsdEvent     = "statsd"
+	sleeperEvent    = "sleeper"
+	annotationEvent = "annotation"
+	httpEvent       = "http"
+	fileEvent       = "file"
 )
 
 // Orchestrator controls the firing of metrics as defined in the configuration.
 // It will shutdown the connections to the various systems on a SIGTERM or a SIGHUP.
 // The Finished chan will send a true and close once all systems have been shutdown.
 type Orchestrator struct {
-	signals           chan os.Signal
-	config            *config.Config
-	Finished          chan bool
-	influxConnections map[string]*influxShipper.InfluxShipper
-	statsdConnections map[string]*statsdShipper.StatsDShipper
-	StopChan          chan error
-	timelines         []*timeline
+	signals            chan os.Signal
+	config             *config.Config
+	Finished           chan bool
+	influxConnections  map[string]*influxShipper.InfluxShipper
+	statsdConnections  map[string]*statsdShipper.StatsDShipper
+	grafanaConnections map[string]*grafanaShipper.GrafanaShipper
+	httpConnections    map[string]*httpShipper.HTTPShipper
+	fileConnections    map[string]*fileShipper.FileShipper
+	StopChan           chan error
+	dryRun             bool
+	recorder           *recorder.Recorder
+	timelines          []*timeline
+	shutdownOnce       sync.Once
+	stopping           chan struct{}
+	latest             *metricCreator.LatestValues
+	started            time.Time
+	deliveries         *deliveries
+	clock              func() time.Time
+	speed              float64
+	backfill           time.Duration
 }
 
 // New creates a new Orchestrator and returns it.
 func New(signals chan os.Signal, config *config.Config) *Orchestrator {
 	return &Orchestrator{
-		signals:           signals,
-		config:            config,
-		Finished:          make(chan bool, 1),
-		StopChan:          make(chan error, 1),
-		influxConnections: make(map[string]*influxShipper.InfluxShipper),
-		statsdConnections: make(map[string]*statsdShipper.StatsDShipper),
-		timelines:         make([]*timeline, 0),
+		signals:            signals,
+		config:             config,
+		Finished:           make(chan bool, 1),
+		StopChan:           make(chan error, 1),
+		influxConnections:  make(map[string]*influxShipper.InfluxShipper),
+		statsdConnections:  make(map[string]*statsdShipper.StatsDShipper),
+		grafanaConnections: make(map[string]*grafanaShipper.GrafanaShipper),
+		httpConnections:    make(map[string]*httpShipper.HTTPShipper),
+		fileConnections:    make(map[string]*fileShipper.FileShipper),
+		timelines:          make([]*timeline, 0),
+		latest:             metricCreator.NewLatestValues(),
+		deliveries:         newDeliveries(),
+		stopping:           make(chan struct{}),
+		clock:              time.Now,
+		speed:              1,
 	}
 }
 
+// EnableDryRun stops the Orchestrator from connecting to anything. Every event that fires
+// is recorded in memory instead of being sent. Use DryRunSummary to see what was recorded.
+// It must be called before Start.
+func (o *Orchestrator) EnableDryRun() {
+	o.dryRun = true
+	o.recorder = recorder.New()
+}
+
+// DryRunSummary returns a summary of the events that were recorded during a dry run.
+func (o *Orchestrator) DryRunSummary() string {
+	if !o.dryRun {
+		return ""
+	}
+	return o.recorder.Summary()
+}
+
 type eventMetric struct {
 	fire func()
 }
@@ -53,6 +101,10 @@ type eventMetric struct {
 // will be made. The Orchestrator will shutdown on an error and queue a True in the Finished
 // chan should there be a failure.
 func (o *Orchestrator) Start() error {
+	if o.dryRun {
+		loggos.SendJSON(loggos.JSONInfoln("Dry run enabled, events will be recorded and not sent."))
+		return o.startStory()
+	}
 	loggos.SendJSON(loggos.JSONDebugln("Orchestrator attempting to start influx connections."))
 	err := o.startInflux()
 	if err != nil {
@@ -63,19 +115,76 @@ func (o *Orchestrator) Start() error {
 	if err != nil {
 		return err
 	}
+	loggos.SendJSON(loggos.JSONDebugln("Orchestrator attempting to start grafana connections."))
+	err = o.startGrafana()
+	if err != nil {
+		return err
+	}
+	loggos.SendJSON(loggos.JSONDebugln("Orchestrator attempting to start http connections."))
+	err = o.startHTTP()
+	if err != nil {
+		return err
+	}
+	loggos.SendJSON(loggos.JSONDebugln("Orchestrator attempting to start file connections."))
+	err = o.startFile()
+	if err != nil {
+		return err
+	}
+	return o.startStory()
+}
+
+// startStory starts the timelines and listens for signals to shutdown.
+func (o *Orchestrator) startStory() error {
 	jm := loggos.JSONDebugln("Orchestrator attempting to start timelines")
 	jm.Add("story_name", o.config.Story.StoryName)
 	loggos.SendJSON(jm)
 
-	err = o.startTimelines()
+	o.started = o.clock()
+	err := o.startTimelines()
 	if err != nil {
 		return err
 	}
+	// The maximum duration is on the clock of the story, which runs faster when backfilling.
+	// A backfill stops once it catches up with now if that is sooner.
+	maxDuration := o.config.Story.ParsedMaxDuration()
+	stopMessage := "Maximum duration has passed, shutting down."
+	if maxDuration > 0 {
+		jm := loggos.JSONInfoln("Story will stop after its maximum duration.")
+		jm.Add("max_duration", maxDuration.String())
+		loggos.SendJSON(jm)
+	}
+	if o.backfill > 0 {
+		jm := loggos.JSONInfoln("Backfilling story.")
+		jm.Add("from", o.started.Format(time.RFC3339))
+		jm.Add("speed", o.speed)
+		loggos.SendJSON(jm)
+
+		if catchUp := time.Since(o.started); maxDuration == 0 || catchUp < maxDuration {
+			maxDuration = catchUp
+			stopMessage = "Backfill has caught up with now, shutting down."
+		}
+	}
 	go func() {
+		// A nil deadline never fires so stories without a maximum duration only stop on a
+		// signal or when they finish.
+		var deadline <-chan time.Time
+		if maxDuration > 0 {
+			deadlineTimer := time.NewTimer(trigger.Scale(maxDuration, o.speed))
+			defer deadlineTimer.Stop()
+			deadline = deadlineTimer.C
+		}
 		for {
 			select {
 			case signal := <-o.signals:
 				o.stop(signal)
+			case <-deadline:
+				jm := loggos.JSONInfoln(stopMessage)
+				jm.Add("max_duration", maxDuration.String())
+				loggos.SendJSON(jm)
+
+				o.shutdown()
+			case <-o.stopping:
+				return
 			}
 		}
 	}()
@@ -105,18 +214,52 @@ func (o *Orchestrator) stop(signal os.Signal) {
 	}
 }
 
+// shutdown stops the timelines then the connections. It only runs once no matter how
+// many times it is called. If the story has a drain timeout and the connections take
+// longer than that to send what they have queued they are left behind and an error is
+// sent down the StopChan.
 func (o *Orchestrator) shutdown() {
+	o.shutdownOnce.Do(func() {
+		close(o.stopping)
+		loggos.SendJSON(loggos.JSONDebugln("Orchestrator attempting to stop triggers"))
+		for _, tl := range o.timelines {
+			tl.shutdown()
+		}
+		for _, tl := range o.timelines {
+			<-tl.StopChan
+		}
+		o.deliveries.stop()
+
+		drainTimeout := o.config.Story.ParsedDrainTimeout()
+		if drainTimeout == 0 {
+			o.stopConnections()
+			o.StopChan <- nil
+			return
+		}
+		drained := make(chan bool, 1)
+		go func() {
+			o.stopConnections()
+			drained <- true
+		}()
+		select {
+		case <-drained:
+			o.StopChan <- nil
+		case <-time.After(drainTimeout):
+			jm := loggos.JSONCritln("Connections did not finish sending before the drain timeout. Closing without them.")
+			jm.Add("drain_timeout", drainTimeout.String())
+			loggos.SendJSON(jm)
+
+			o.StopChan <- fmt.Errorf("connections did not finish sending within the drain timeout of %s", drainTimeout)
+		}
+	})
+}
+
+// stopConnections stops each connection and waits for it to send what it has queued.
+func (o *Orchestrator) stopConnections() {
 	log := func(msg string) {
 		loggos.SendJSON(loggos.JSONDebugln(msg))
 	}
 
-	log("Orchestrator attempting to stop triggers")
-	for _, tl := range o.timelines {
-		tl.shutdown()
-	}
-	for _, tl := range o.timelines {
-		<-tl.StopChan
-	}
 	log("Orchestrator attempting to stop StatsD connections")
 	for _, statsdC := range o.statsdConnections {
 		statsdC.Stop()
@@ -126,7 +269,21 @@ func (o *Orchestrator) shutdown() {
 		influxC.Stop()
 		<-influxC.StopChan
 	}
-	o.StopChan <- nil
+	log("Orchestrator attempting to stop Grafana connections")
+	for _, grafanaC := range o.grafanaConnections {
+		grafanaC.Stop()
+		<-grafanaC.StopChan
+	}
+	log("Orchestrator attempting to stop HTTP connections")
+	for _, httpC := range o.httpConnections {
+		httpC.Stop()
+		<-httpC.StopChan
+	}
+	log("Orchestrator attempting to stop File connections")
+	for _, fileC := range o.fileConnections {
+		fileC.Stop()
+		<-fileC.StopChan
+	}
 }
 
 func (o *Orchestrator) startInflux() error {
@@ -194,7 +351,90 @@ func (o *Orchestrator) startStatsd() error {
 	return nil
 }
 
+func (o *Orchestrator) startGrafana() error {
+	if len(o.config.Story.Grafana) == 0 {
+		return nil
+	}
+	for _, grafanaConfig := range o.config.Story.Grafana {
+		jm := loggos.JSONInfoln("Creating Grafana connection")
+		jm.Add("connection_id", grafanaConfig.ID)
+		loggos.SendJSON(jm)
+		shipper := grafanaShipper.New(
+			grafanaConfig.ID,
+			grafanaConfig.Host,
+			grafanaConfig.APIKey,
+			grafanaConfig.Username,
+			grafanaConfig.Password,
+			grafanaConfig.HTTPTimeout,
+		)
+
+		if err := shipper.Connect(); err != nil {
+			jm = loggos.JSONCritln("Grafana connection failed")
+			jm.Add("connection_id", grafanaConfig.ID)
+			loggos.SendJSON(jm)
+			return err
+		}
+		shipper.Start()
+		o.grafanaConnections[grafanaConfig.ID] = shipper
+	}
+	return nil
+}
+
+func (o *Orchestrator) startHTTP() error {
+	if len(o.config.Story.HTTP) == 0 {
+		return nil
+	}
+	for _, httpConfig := range o.config.Story.HTTP {
+		jm := loggos.JSONInfoln("Creating HTTP connection")
+		jm.Add("connection_id", httpConfig.ID)
+		loggos.SendJSON(jm)
+		shipper := httpShipper.New(
+			httpConfig.ID,
+			httpConfig.Host,
+			httpConfig.Username,
+			httpConfig.Password,
+			httpConfig.BearerToken,
+			httpConfig.Headers,
+			httpConfig.TLSSkipVerify,
+			httpConfig.HTTPTimeout,
+		)
+
+		if err := shipper.Connect(); err != nil {
+			return err
+		}
+		shipper.Start()
+		o.httpConnections[httpConfig.ID] = shipper
+	}
+	return nil
+}
+
+func (o *Orchestrator) startFile() error {
+	if len(o.config.Story.File) == 0 {
+		return nil
+	}
+	for _, fileConfig := range o.config.Story.File {
+		jm := loggos.JSONInfoln("Creating File connection")
+		jm.Add("connection_id", fileConfig.ID)
+		jm.Add("path", fileConfig.Path)
+		loggos.SendJSON(jm)
+		shipper := fileShipper.New(
+			fileConfig.ID,
+			fileConfig.Path,
+			fileConfig.MaxSize,
+			fileConfig.MaxBackups,
+		)
+
+		if err := shipper.Connect(); err != nil {
+			return err
+		}
+		shipper.Start()
+		o.fileConnections[fileConfig.ID] = shipper
+	}
+	return nil
+}
+
 func (o *Orchestrator) startTimelines() error {
+	barriers := trigger.NewBarriers()
 	for _, timelineConfig := range o.config.Story.TimeLines {
 		jm := loggos.JSONDebugln("Creating Timeline")
 		jm.Add("timeline_name", timelineConfig.Name)
@@ -206,11 +446,61 @@ func (o *Orchestrator) startTimelines() error {
 			events:   make(map[string]*eventMetric),
 			Name:     timelineConfig.Name,
 		}
+		tl.trigger.SetClock(o.clock)
+		tl.trigger.SetSpeed(o.speed)
 		o.timelines = append(o.timelines, tl)
+		loops, err := timelineConfig.Loops(o.config.Story.Continuous)
+		if err != nil {
+			return fmt.Errorf("Failed to create timeline %s. Error %s", timelineConfig.Name, err)
+		}
+		tl.trigger.SetLoops(loops)
+		tl.finite = loops > 0
+		tl.trigger.SetBarriers(barriers)
+		if startAfter := timelineConfig.ParsedStartAfter(); startAfter > 0 {
+			tl.trigger.SetStartDelay(startAfter)
+		}
+		if timelineConfig.Selection != "" {
+			if err := tl.trigger.SetSelection(timelineConfig.Selection); err != nil {
+				return err
+			}
+		}
+		timesliceIndexes := make(map[string]int)
 		for _, timeslice := range timelineConfig.Timeslices {
 			timesliceIndex := tl.trigger.NewTimeSlice(timeslice.Name, timeslice.Repeat, timeslice.SingleUse)
+			timesliceIndexes[timeslice.Name] = timesliceIndex
+			if timeslice.Weight > 0 {
+				tl.trigger.SetWeight(timesliceIndex, timeslice.Weight)
+			}
+			if duration := timeslice.ParsedDuration(); duration > 0 {
+				tl.trigger.SetDuration(timesliceIndex, duration)
+			}
+			if schedule, _ := timeslice.ParsedSchedule(); schedule != nil {
+				tl.trigger.SetSchedule(timesliceIndex, schedule)
+			}
+			if timeslice.WaitFor != "" {
+				tl.trigger.WaitFor(timesliceIndex, timeslice.WaitFor)
+			}
+			for _, signal := range timeslice.Signals {
+				tl.trigger.AddSignal(timesliceIndex, signal)
+			}
+			if stateEvent := timelineConfig.StateEvent(timeslice.Name); stateEvent != nil {
+				id := tl.stateName(timeslice.Name)
+				tl.trigger.AddStartTrigger(timesliceIndex, id)
+				eventMetric, err := o.newEventMetric(stateEvent)
+				if err != nil {
+					return err
+				}
+				tl.events[id] = eventMetric
+			}
 			for eventIndex, event := range timeslice.Events {
 				id := tl.addEventTrigger(timeslice.Name, timesliceIndex, eventIndex, event)
+				rate, err := event.RateProfile()
+				if err != nil {
+					return fmt.Errorf("Failed to create event %s. Error %s", event.MetricName, err)
+				}
+				if rate != nil {
+					tl.trigger.SetRate(id, rate)
+				}
 				eventMetric, err := o.newEventMetric(event)
 				if err != nil {
 					return err
@@ -218,20 +508,38 @@ func (o *Orchestrator) startTimelines() error {
 				tl.events[id] = eventMetric
 			}
 		}
+		for _, timeslice := range timelineConfig.Timeslices {
+			targets := make([]string, 0, len(timeslice.Transitions))
+			for to := range timeslice.Transitions {
+				targets = append(targets, to)
+			}
+			sort.Strings(targets)
+			for _, to := range targets {
+				tl.trigger.AddTransition(timesliceIndexes[timeslice.Name], timesliceIndexes[to], timeslice.Transitions[to])
+			}
+		}
 		tl.startFiring()
-		err := tl.trigger.Start()
+		err = tl.trigger.Start()
 		if err != nil {
 			return err
 		}
 	}
-	if !o.config.Story.Continuous {
-		go o.waitForSinglesToFinish()
+	// The story stops by itself once every timeline has finished. If some timelines loop
+	// forever it only stops by itself if it has been told to stop after the others finish.
+	finite := make([]*timeline, 0, len(o.timelines))
+	for _, tl := range o.timelines {
+		if tl.finite {
+			finite = append(finite, tl)
+		}
+	}
+	if len(finite) == len(o.timelines) || (o.config.Story.StopAfterFiniteTimelines && len(finite) > 0) {
+		go o.waitForTimelinesToFinish(finite)
 	}
 	return nil
 }
 
-func (o *Orchestrator) waitForSinglesToFinish() {
-	for _, tl := range o.timelines {
+func (o *Orchestrator) waitForTimelinesToFinish(timelines []*timeline) {
+	for _, tl := range timelines {
 		<-tl.StopChan
 	}
 	o.shutdown()
@@ -245,11 +553,22 @@ func (o *Orchestrator) newEventMetric(event *config.Event) (*eventMetric, error)
 		return o.createStatsdEventMetric(event)
 	case sleeperEvent:
 		return o.createSleeperEvent(event)
+	case annotationEvent:
+		return o.createAnnotationEvent(event)
+	case httpEvent:
+		return o.createHTTPEvent(event)
+	case fileEvent:
+		return o.createFileEventMetric(event)
 	}
 	return nil, nil
 }
 
 func (o *Orchestrator) createInfluxEventMetric(event *config.Event) (*eventMetric, error) {
+	if event.Rendered() {
+		return o.createRenderedEventMetric(event, "Influx", func(metric metricCreator.Metric, at time.Time) {
+			o.shipInflux(event.ConnectionID, metric, at)
+		})
+	}
 	metric, err := o.createMetric(event)
 	if err != nil {
 		return nil, err
@@ -261,7 +580,9 @@ func (o *Orchestrator) createInfluxEventMetric(event *config.Event) (*eventMetri
 		jm.Add("event_text", metric.Influx())
 		loggos.SendJSON(jm)
 
-		o.influxConnections[event.ConnectionID].Ship(metric.Influx())
+		o.deliver(event, metric, func(metric metricCreator.Metric, at time.Time) {
+			o.shipInflux(event.ConnectionID, metric, at)
+		})
 	}
 	return &eventMetric{
 		fire: f,
@@ -269,6 +590,11 @@ func (o *Orchestrator) createInfluxEventMetric(event *config.Event) (*eventMetri
 }
 
 func (o *Orchestrator) createStatsdEventMetric(event *config.Event) (*eventMetric, error) {
+	if event.Rendered() {
+		return o.createRenderedEventMetric(event, "StatsD", func(metric metricCreator.Metric, at time.Time) {
+			o.shipStatsd(event.ConnectionID, metric)
+		})
+	}
 	metric, err := o.createMetric(event)
 	if err != nil {
 		return nil, err
@@ -284,7 +610,95 @@ func (o *Orchestrator) createStatsdEventMetric(event *config.Event) (*eventMetri
 		jm.Add("event_id", event.ConnectionID)
 		jm.Add("event_text", metric.Influx())
 		loggos.SendJSON(jm)
-		o.statsdConnections[event.ConnectionID].Ship(metric.StatsD())
+		o.deliver(event, metric, func(metric metricCreator.Metric, at time.Time) {
+			o.shipStatsd(event.ConnectionID, metric)
+		})
+	}
+	return &eventMetric{
+		fire: f,
+	}, nil
+}
+
+func (o *Orchestrator) createFileEventMetric(event *config.Event) (*eventMetric, error) {
+	format := ""
+	for _, fileConfig := range o.config.Story.File {
+		if fileConfig.ID == event.ConnectionID {
+			format = fileConfig.Format
+		}
+	}
+	render := func(metric metricCreator.Metric) string {
+		if format == statsdEvent {
+			return metric.StatsD()
+		}
+		return metric.Influx()
+	}
+	if event.Rendered() {
+		return o.createRenderedEventMetric(event, "File", func(metric metricCreator.Metric, at time.Time) {
+			o.shipFile(event.ConnectionID, render(metric), metric)
+		})
+	}
+	metric, err := o.createMetric(event)
+	if err != nil {
+		return nil, err
+	}
+	if len(event.StatsDTaggingFormat) > 0 {
+		if err := metric.SetTaggingFormat(event.StatsDTaggingFormat); err != nil {
+			return nil, err
+		}
+	}
+	f := func() {
+		output := render(metric)
+		jm := loggos.JSONDebugln("Firing event.")
+		jm.Add("type", "File")
+		jm.Add("event_id", event.ConnectionID)
+		jm.Add("event_text", output)
+		loggos.SendJSON(jm)
+		o.deliver(event, metric, func(metric metricCreator.Metric, at time.Time) {
+			o.shipFile(event.ConnectionID, output, metric)
+		})
+	}
+	return &eventMetric{
+		fire: f,
+	}, nil
+}
+
+// createRenderedEventMetric creates an event that renders new metrics each time it fires.
+// This is used for random tags, derived fields, expression fields and to send a metric
+// for some or all of the series in a fan out. ship sends a single metric.
+func (o *Orchestrator) createRenderedEventMetric(event *config.Event, eventType string, ship sendFunc) (*eventMetric, error) {
+	template, err := event.MetricTemplate()
+	if err != nil {
+		return nil, err
+	}
+	if len(event.StatsDTaggingFormat) > 0 {
+		if err := template.SetTaggingFormat(event.StatsDTaggingFormat); err != nil {
+			return nil, err
+		}
+	}
+	template.SetLatestValues(o.latest)
+	template.SetStart(o.started)
+	template.SetClock(o.clock)
+	fanOut, err := metricCreator.NewFanOut(template, event.FanOut, event.FanOutMode, event.FanOutSize)
+	if err != nil {
+		return nil, err
+	}
+	f := func() {
+		metrics, err := fanOut.Metrics()
+		if err != nil {
+			jm := loggos.JSONCritln("Failed to render metrics.")
+			jm.Add("event_id", event.ConnectionID)
+			jm.Error(err)
+			loggos.SendJSON(jm)
+			return
+		}
+		jm := loggos.JSONDebugln("Firing event.")
+		jm.Add("type", eventType)
+		jm.Add("event_id", event.ConnectionID)
+		jm.Add("series", len(metrics))
+		loggos.SendJSON(jm)
+		for _, metric := range metrics {
+			o.deliver(event, metric, ship)
+		}
 	}
 	return &eventMetric{
 		fire: f,
@@ -295,6 +709,84 @@ func (o *Orchestrator) createSleeperEvent(event *config.Event) (*eventMetric, er
 	return &eventMetric{fire: func() {}}, nil
 }
 
+func (o *Orchestrator) createAnnotationEvent(event *config.Event) (*eventMetric, error) {
+	annotation := event.Annotation
+	metric, err := metricCreator.NewAnnotation(event.MetricName, annotation.Title, annotation.Text, annotation.Tags, event.Tags)
+	if err != nil {
+		return nil, err
+	}
+	grafanaText := annotation.Text
+	if annotation.Title != "" {
+		grafanaText = annotation.Title
+		if annotation.Text != "" {
+			grafanaText = fmt.Sprintf("%s\n%s", annotation.Title, annotation.Text)
+		}
+	}
+	f := func() {
+		jm := loggos.JSONDebugln("Firing event.")
+		jm.Add("type", "Annotation")
+		jm.Add("event_id", event.ConnectionID)
+		jm.Add("event_text", metric.Influx())
+		loggos.SendJSON(jm)
+
+		if event.ConnectionID != "" {
+			o.shipInflux(event.ConnectionID, metric, time.Time{})
+		}
+		if annotation.GrafanaConnectionID != "" {
+			o.shipGrafana(annotation.GrafanaConnectionID, event.MetricName, &grafanaShipper.Annotation{
+				DashboardID: annotation.DashboardID,
+				PanelID:     annotation.PanelID,
+				Time:        time.Now().UnixNano() / int64(time.Millisecond),
+				Tags:        annotation.Tags,
+				Text:        grafanaText,
+			})
+		}
+	}
+	return &eventMetric{
+		fire: f,
+	}, nil
+}
+
+func (o *Orchestrator) createHTTPEvent(event *config.Event) (*eventMetric, error) {
+	request := event.HTTPRequest
+	requestTemplate, err := httpShipper.NewRequestTemplate(
+		request.Method,
+		request.Path,
+		request.Headers,
+		request.Body,
+		request.ExpectedStatus,
+		request.Timeout,
+	)
+	if err != nil {
+		return nil, err
+	}
+	f := func() {
+		req, err := requestTemplate.Render(httpShipper.TemplateData{
+			MetricName: event.MetricName,
+			Tags:       event.Tags,
+			Fields:     event.Fields,
+			Time:       time.Now(),
+		})
+		if err != nil {
+			jm := loggos.JSONCritln("Failed to render HTTP request.")
+			jm.Add("event_id", event.ConnectionID)
+			jm.Error(err)
+			loggos.SendJSON(jm)
+			return
+		}
+		jm := loggos.JSONDebugln("Firing event.")
+		jm.Add("type", "HTTP")
+		jm.Add("event_id", event.ConnectionID)
+		jm.Add("event_text", string(req.Body))
+		loggos.SendJSON(jm)
+
+		o.shipHTTP(event.ConnectionID, event.MetricName, event.Fields, req)
+	}
+	return &eventMetric{
+		fire: f,
+	}, nil
+}
+
 func (o *Orchestrator) createMetric(event *config.Event) (metricCreator.Metric, error) {
 	metric, err := metricCreator.NewMetric(event.MetricName, event.Tags, event.Fields)
 	if err != nil {
@@ -0,0 +1,410 @@
+package orchestrator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/silverstagtech/loggos"
+	"github.com/silverstagtech/teller/config"
+	"github.com/silverstagtech/teller/httpShipper"
+)
+
+func setupLogger() {
+	loggos.JSONLoggerEnableDebugLogging(false)
+	loggos.JSONLoggerEnablePrettyPrint(true)
+	loggos.JSONLoggerEnableHumanTimestamps(true)
+}
+
+func staticEvent(eventType, connectionID string, repeat int) *config.Event {
+	event := &config.Event{
+		MetricName:   "test_metric",
+		Type:         eventType,
+		ConnectionID: connectionID,
+		Repeat:       repeat,
+		Tags:         map[string]string{"metric_type": "counter"},
+		Fields:       map[string]interface{}{"value": 1},
+	}
+	event.TimeBetween.Static.Time = 1
+	return event
+}
+
+func testStory(events ...*config.Event) *config.Config {
+	return &config.Config{
+		Story: &config.Story{
+			StoryName: "test",
+			Influx:    []*config.InfluxConnection{{ID: "influx1", Host: "http://127.0.0.1:1"}},
+			StatsD:    []*config.StatsDConnection{{ID: "statsd1", Host: "127.0.0.1", Port: 1, Transport: "tcp"}},
+			TimeLines: []*config.TimeLine{
+				{
+					Name: "timeline",
+					Timeslices: []*config.Timeslice{
+						{Name: "slice", Repeat: 1, Events: events},
+					},
+				},
+			},
+		},
+	}
+}
+
+func waitForStop(t *testing.T, o *Orchestrator) {
+	select {
+	case <-o.StopChan:
+	case <-time.After(5 * time.Second):
+		t.Logf("The orchestrator did not stop.")
+		t.FailNow()
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	setupLogger()
+	o := New(make(chan os.Signal, 1), testStory(
+		staticEvent(influxEvent, "influx1", 3),
+		staticEvent(statsdEvent, "statsd1", 2),
+	))
+	o.EnableDryRun()
+	// The connections point at closed ports so starting would fail without the dry run.
+	if err := o.Start(); err != nil {
+		t.Logf("Dry run failed to start. Error: %s", err)
+		t.FailNow()
+	}
+	waitForStop(t, o)
+
+	if points := o.recorder.Points(influxEvent, "influx1", "test_metric,metric_type=counter"); points != 3 {
+		t.Logf("Expected 3 influx points to be recorded. Got: %d", points)
+		t.Fail()
+	}
+	if points := o.recorder.Points(statsdEvent, "statsd1", "test_metric,metric_type=counter"); points != 2 {
+		t.Logf("Expected 2 statsd points to be recorded. Got: %d", points)
+		t.Fail()
+	}
+	if o.DryRunSummary() == "" {
+		t.Logf("Expected a dry run summary.")
+		t.Fail()
+	}
+}
+
+func TestFanOutDryRun(t *testing.T) {
+	setupLogger()
+	fanOut := staticEvent(influxEvent, "influx1", 2)
+	fanOut.FanOut = map[string]interface{}{
+		"host":   "web-{01..03}",
+		"region": []interface{}{"eu", "us"},
+	}
+	roundRobin := staticEvent(statsdEvent, "statsd1", 4)
+	roundRobin.FanOut = map[string]interface{}{"host": "db-{1..2}"}
+	roundRobin.FanOutMode = "round_robin"
+
+	o := New(make(chan os.Signal, 1), testStory(fanOut, roundRobin))
+	o.EnableDryRun()
+	if err := o.Start(); err != nil {
+		t.Logf("Dry run failed to start. Error: %s", err)
+		t.FailNow()
+	}
+	waitForStop(t, o)
+
+	for _, host := range []string{"web-01", "web-02", "web-03"} {
+		for _, region := range []string{"eu", "us"} {
+			series := "test_metric,host=" + host + ",metric_type=counter,region=" + region
+			if points := o.recorder.Points(influxEvent, "influx1", series); points != 2 {
+				t.Logf("Expected 2 points for %s. Got: %d", series, points)
+				t.Fail()
+			}
+		}
+	}
+	for _, host := range []string{"db-1", "db-2"} {
+		series := "test_metric,host=" + host + ",metric_type=counter"
+		if points := o.recorder.Points(statsdEvent, "statsd1", series); points != 2 {
+			t.Logf("Expected round robin to send 2 points for %s. Got: %d", series, points)
+			t.Fail()
+		}
+	}
+}
+
+func TestMarkovStateMetric(t *testing.T) {
+	setupLogger()
+	story := testStory()
+	story.Story.TimeLines[0] = &config.TimeLine{
+		Name:        "incidents",
+		Selection:   "markov",
+		StateMetric: &config.StateMetric{MetricName: "state", Type: influxEvent, ConnectionID: "influx1"},
+		Timeslices: []*config.Timeslice{
+			{Name: "healthy", Repeat: 1, Events: []*config.Event{staticEvent(influxEvent, "influx1", 1)}, Transitions: map[string]float64{"outage": 100}},
+			{Name: "outage", Repeat: 2, Events: []*config.Event{staticEvent(influxEvent, "influx1", 1)}},
+		},
+	}
+	o := New(make(chan os.Signal, 1), story)
+	o.EnableDryRun()
+	if err := o.Start(); err != nil {
+		t.Logf("Dry run failed to start. Error: %s", err)
+		t.FailNow()
+	}
+	waitForStop(t, o)
+
+	expected := map[string]int{
+		"state,state=healthy":             1,
+		"state,state=outage":              1,
+		"test_metric,metric_type=counter": 3,
+	}
+	for series, count := range expected {
+		if points := o.recorder.Points(influxEvent, "influx1", series); points != count {
+			t.Logf("Expected %d points for %s. Got: %d", count, series, points)
+			t.Fail()
+		}
+	}
+}
+
+func TestTimelineLoops(t *testing.T) {
+	setupLogger()
+	cfg := testStory(staticEvent(influxEvent, "influx1", 2))
+	cfg.Story.StopAfterFiniteTimelines = true
+	cfg.Story.TimeLines[0].Loop = float64(3)
+	cfg.Story.TimeLines = append(cfg.Story.TimeLines, &config.TimeLine{
+		Name: "background",
+		Loop: config.LoopForever,
+		Timeslices: []*config.Timeslice{
+			{Name: "traffic", Repeat: 1, Events: []*config.Event{staticEvent(statsdEvent, "statsd1", 1)}},
+		},
+	})
+	o := New(make(chan os.Signal, 1), cfg)
+	o.EnableDryRun()
+	if err := o.Start(); err != nil {
+		t.Logf("Dry run failed to start. Error: %s", err)
+		t.FailNow()
+	}
+	// The background timeline loops forever so the story only stops because the
+	// finite timeline finished.
+	waitForStop(t, o)
+
+	if points := o.recorder.Points(influxEvent, "influx1", "test_metric,metric_type=counter"); points != 6 {
+		t.Logf("Expected 3 loops of 2 influx points. Got: %d", points)
+		t.Fail()
+	}
+	if points := o.recorder.Points(statsdEvent, "statsd1", "test_metric,metric_type=counter"); points < 3 {
+		t.Logf("Expected the background timeline to keep looping. Got: %d points", points)
+		t.Fail()
+	}
+}
+
+func TestMaxDuration(t *testing.T) {
+	setupLogger()
+	cfg := testStory(staticEvent(influxEvent, "influx1", 1))
+	cfg.Story.Continuous = true
+	cfg.Story.MaxDuration = "50ms"
+	o := New(make(chan os.Signal, 1), cfg)
+	o.EnableDryRun()
+	starttime := time.Now()
+	if err := o.Start(); err != nil {
+		t.Logf("Dry run failed to start. Error: %s", err)
+		t.FailNow()
+	}
+	waitForStop(t, o)
+	// The story can't stop before its maximum duration. The upper bound is generous so that
+	// a loaded computer doesn't fail the test.
+	if stoptime := time.Since(starttime); stoptime < 50*time.Millisecond || stoptime > 5*time.Second {
+		t.Logf("Expected the continuous story to stop after about 50ms. It took %s", stoptime)
+		t.Fail()
+	}
+
+	// Stopping again, like a signal arriving after the deadline, must not block.
+	done := make(chan bool)
+	go func() {
+		o.shutdown()
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Logf("A second shutdown blocked.")
+		t.Fail()
+	}
+}
+
+func TestDerivedFieldsDryRun(t *testing.T) {
+	setupLogger()
+	queries := staticEvent(influxEvent, "influx1", 1)
+	queries.MetricName = "db_queries"
+	queries.Fields = map[string]interface{}{"count": 21}
+	requests := staticEvent(statsdEvent, "statsd1", 2)
+	requests.MetricName = "requests"
+	requests.Fields = map[string]interface{}{"count": 200, "error_rate": 0.05}
+	requests.DerivedFields = map[string]string{
+		"errors":   "count * error_rate",
+		"upstream": `latest("db_queries", "count") * 2`,
+	}
+
+	o := New(make(chan os.Signal, 1), testStory(queries, requests))
+	o.EnableDryRun()
+	if err := o.Start(); err != nil {
+		t.Logf("Dry run failed to start. Error: %s", err)
+		t.FailNow()
+	}
+	waitForStop(t, o)
+
+	if points := o.recorder.Points(statsdEvent, "statsd1", "requests,metric_type=counter"); points != 2 {
+		t.Logf("Expected 2 points for requests. Got: %d", points)
+		t.Fail()
+	}
+	// db_queries fires first so its latest value is known when requests fires.
+	for field, expected := range map[string]float64{"errors": 10, "upstream": 42} {
+		if value, _ := o.latest.Latest("requests", field); value != expected {
+			t.Logf("Expected %s to be %v. Got: %v", field, expected, value)
+			t.Fail()
+		}
+	}
+}
+
+func TestDeliveryDryRun(t *testing.T) {
+	setupLogger()
+	dropped := staticEvent(influxEvent, "influx1", 3)
+	dropped.MetricName = "dropped"
+	dropped.Delivery = &config.Delivery{Drop: 1}
+	duplicated := staticEvent(statsdEvent, "statsd1", 2)
+	duplicated.MetricName = "duplicated"
+	duplicated.Delivery = &config.Delivery{Duplicate: 1}
+	delayed := staticEvent(influxEvent, "influx1", 2)
+	delayed.MetricName = "delayed"
+	delayed.Delivery = &config.Delivery{Delay: &config.DeliveryShift{Probability: 1, Seconds: 60}}
+
+	o := New(make(chan os.Signal, 1), testStory(dropped, duplicated, delayed))
+	o.EnableDryRun()
+	if err := o.Start(); err != nil {
+		t.Logf("Dry run failed to start. Error: %s", err)
+		t.FailNow()
+	}
+	waitForStop(t, o)
+
+	expected := map[string]int{
+		"dropped,metric_type=counter": 0,
+		"delayed,metric_type=counter": 0,
+	}
+	for series, count := range expected {
+		if points := o.recorder.Points(influxEvent, "influx1", series); points != count {
+			t.Logf("Expected %d points for %s. Got: %d", count, series, points)
+			t.Fail()
+		}
+	}
+	if points := o.recorder.Points(statsdEvent, "statsd1", "duplicated,metric_type=counter"); points != 4 {
+		t.Logf("Expected every duplicated point to be sent twice. Got: %d", points)
+		t.Fail()
+	}
+	// Derived fields can only see the values of points that were sent.
+	for metricName, known := range map[string]bool{"dropped": false, "delayed": false, "duplicated": true} {
+		if _, ok := o.latest.Latest(metricName, "value"); ok != known {
+			t.Logf("Expected the latest value of %s to be known: %v. Got: %v", metricName, known, ok)
+			t.Fail()
+		}
+	}
+	if len(o.deliveries.pending) != 0 {
+		t.Logf("Expected the delayed points to be thrown away when the story stopped. Got: %d", len(o.deliveries.pending))
+		t.Fail()
+	}
+}
+
+func TestDeliveriesLater(t *testing.T) {
+	setupLogger()
+	ds := newDeliveries()
+	sent := make(chan bool, 2)
+	ds.later(time.Millisecond, func() { sent <- true })
+	ds.later(time.Hour, func() { sent <- true })
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Logf("The short delay was never sent.")
+		t.Fail()
+	}
+	ds.stop()
+	ds.later(time.Millisecond, func() { sent <- true })
+	time.Sleep(10 * time.Millisecond)
+	if len(sent) != 0 {
+		t.Logf("Nothing should be sent after the deliveries stop.")
+		t.Fail()
+	}
+}
+
+func TestBackfillDryRun(t *testing.T) {
+	setupLogger()
+	cpu := staticEvent(influxEvent, "influx1", 10)
+	cpu.MetricName = "cpu"
+	cpu.TimeBetween.Static.Time = 60000
+	hosts := staticEvent(influxEvent, "influx1", 10)
+	hosts.MetricName = "hosts"
+	hosts.TimeBetween.Static.Time = 60000
+	hosts.FanOut = map[string]interface{}{"host": []interface{}{"a"}}
+	cfg := testStory(cpu, hosts)
+	cfg.Story.Continuous = true
+
+	o := New(make(chan os.Signal, 1), cfg)
+	o.EnableDryRun()
+	// An hour at 3600 times faster takes a second.
+	if err := o.EnableBackfill(time.Hour, 3600); err != nil {
+		t.Logf("Failed to enable the backfill. Error: %s", err)
+		t.FailNow()
+	}
+	starttime := time.Now()
+	if err := o.Start(); err != nil {
+		t.Logf("Dry run failed to start. Error: %s", err)
+		t.FailNow()
+	}
+	waitForStop(t, o)
+	if stoptime := time.Since(starttime); stoptime > 4*time.Second {
+		t.Logf("Expected the backfill to catch up after about a second. It took %s", stoptime)
+		t.Fail()
+	}
+
+	// The events take turns to fire every simulated minute so each sends about 30 points
+	// in the hour, given a generous allowance for a loaded computer. Every one is for a
+	// time in the hour before now.
+	for _, series := range []string{"cpu,metric_type=counter", "hosts,host=a,metric_type=counter"} {
+		points := o.recorder.Points(influxEvent, "influx1", series)
+		first, last := o.recorder.Times(influxEvent, "influx1", series)
+		if points < 15 || points > 31 {
+			t.Logf("Expected about 30 points for %s. Got: %d", series, points)
+			t.Fail()
+		}
+		if since := time.Since(first); since < 30*time.Minute || since > time.Hour || last.After(time.Now()) {
+			t.Logf("Expected %s to be backfilled over the last hour. Got: %s to %s", series, first, last)
+			t.Fail()
+		}
+	}
+
+	statsd := New(make(chan os.Signal, 1), testStory(staticEvent(statsdEvent, "statsd1", 1)))
+	if err := statsd.EnableBackfill(time.Hour, 60); err == nil {
+		t.Logf("Expected an error backfilling statsd points.")
+		t.Fail()
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	setupLogger()
+	// The server holds on to the request so the connection never finishes draining.
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	cfg := testStory(staticEvent(sleeperEvent, "", 1))
+	cfg.Story.DrainTimeout = "50ms"
+	o := New(make(chan os.Signal, 1), cfg)
+	slow := httpShipper.New("slow", server.URL, "", "", "", nil, false, 10)
+	slow.Connect()
+	slow.Start()
+	slow.Ship(&httpShipper.Request{Method: "GET", Path: "/"})
+	o.httpConnections["slow"] = slow
+
+	go o.shutdown()
+	select {
+	case err := <-o.StopChan:
+		if err == nil {
+			t.Logf("Expected an error as the connection did not drain in time.")
+			t.Fail()
+		}
+	case <-time.After(5 * time.Second):
+		t.Logf("The orchestrator did not give up waiting for the connection to drain.")
+		t.Fail()
+	}
+}
@@ -0,0 +1,61 @@
+package orchestrator
+
+import (
+	"time"
+
+	"github.com/silverstagtech/teller/grafanaShipper"
+	"github.com/silverstagtech/teller/httpShipper"
+	"github.com/silverstagtech/teller/metricCreator"
+)
+
+// The ship functions send the output of an event to its connection. When doing a dry run
+// they record the event instead so that nothing leaves the process.
+
+// shipInflux sends the metric with the time given, or lets Influx give it the time it
+// arrives if at is zero. Backfilled points always get the time of the story clock.
+func (o *Orchestrator) shipInflux(connectionID string, metric metricCreator.Metric, at time.Time) {
+	if at.IsZero() && (o.dryRun || o.backfill > 0) {
+		at = o.clock()
+	}
+	if o.dryRun {
+		o.recorder.Record(influxEvent, connectionID, metric.Series(), metric.Fields(), at)
+		return
+	}
+	if at.IsZero() {
+		o.influxConnections[connectionID].Ship(metric.Influx())
+		return
+	}
+	o.influxConnections[connectionID].ShipAt(metric.Influx(), at)
+}
+
+func (o *Orchestrator) shipStatsd(connectionID string, metric metricCreator.Metric) {
+	if o.dryRun {
+		o.recorder.Record(statsdEvent, connectionID, metric.Series(), metric.Fields(), time.Now())
+		return
+	}
+	o.statsdConnections[connectionID].Ship(metric.StatsD())
+}
+
+func (o *Orchestrator) shipFile(connectionID, output string, metric metricCreator.Metric) {
+	if o.dryRun {
+		o.recorder.Record(fileEvent, connectionID, metric.Series(), metric.Fields(), time.Now())
+		return
+	}
+	o.fileConnections[connectionID].Ship(output)
+}
+
+func (o *Orchestrator) shipGrafana(connectionID, metricName string, annotation *grafanaShipper.Annotation) {
+	if o.dryRun {
+		o.recorder.Record("grafana", connectionID, metricName, nil, time.Now())
+		return
+	}
+	o.grafanaConnections[connectionID].Ship(annotation)
+}
+
+func (o *Orchestrator) shipHTTP(connectionID, metricName string, fields map[string]interface{}, request *httpShipper.Request) {
+	if o.dryRun {
+		o.recorder.Record(httpEvent, connectionID, metricName, fields, time.Now())
+		return
+	}
+	o.httpConnections[connectionID].Ship(request)
+}
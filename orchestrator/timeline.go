@@ -12,6 +12,7 @@ type timeline struct {
 	trigger  *trigger.Trigger
 	StopChan chan bool
 	events   map[string]*eventMetric
+	finite   bool
 }
 
 // eventName return the next index number in the events slice.
@@ -19,6 +20,11 @@ func (tl *timeline) eventName(timesliceName string, eventNumber int) string {
 	return fmt.Sprintf("%q timeslice %q event %d", tl.Name, timesliceName, eventNumber)
 }
 
+// stateName returns the id of the state metric for a time slice.
+func (tl *timeline) stateName(timesliceName string) string {
+	return fmt.Sprintf("%q timeslice %q state", tl.Name, timesliceName)
+}
+
 func (tl *timeline) addEventTrigger(timesliceName string, timesliceIndex, eventIndex int, event *config.Event) string {
 	id := tl.eventName(timesliceName, eventIndex)
 	if event.TimeBetween.Static.Time > 0 {
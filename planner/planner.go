@@ -0,0 +1,507 @@
+// Package planner works out what a story will do without running it.
+//
+// It walks the timelines, time slices and events of a story and works out how long each
+// part is expected to take and how many points will be sent to each connection and series.
+// Dynamic timers give a range of time, so the minimum and maximum durations are shown.
+// The plan can be printed as a Gantt style chart.
+package planner
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/silverstagtech/teller/config"
+	"github.com/silverstagtech/teller/metricCreator"
+	"github.com/silverstagtech/teller/trigger"
+)
+
+const (
+	chartWidth = 50
+)
+
+// span is a minimum and maximum length of time.
+type span struct {
+	min time.Duration
+	max time.Duration
+}
+
+func (s span) add(other span) span {
+	return span{min: s.min + other.min, max: s.max + other.max}
+}
+
+func (s span) times(n int) span {
+	return span{min: s.min * time.Duration(n), max: s.max * time.Duration(n)}
+}
+
+func (s span) average() time.Duration {
+	return (s.min + s.max) / 2
+}
+
+func (s span) String() string {
+	if s.min == s.max {
+		return s.min.String()
+	}
+	return fmt.Sprintf("%s..%s", s.min, s.max)
+}
+
+// TimeslicePlan is the expected timing of a time slice in a timeline. Duration is
+// the most time the time slice can run for, 0 if it has no limit. Schedule is the wall
+// clock schedule the time slice waits for and WaitFor is the signal it waits for, the
+// waits are not part of the timings. Anomalies are the types of anomaly laid over its events.
+type TimeslicePlan struct {
+	Name      string
+	Repeat    int
+	SingleUse bool
+	Duration  time.Duration
+	Schedule  string
+	WaitFor   string
+	Anomalies []string
+	start     span
+	duration  span
+}
+
+// TimelinePlan is the expected timing of a timeline. The time slices of markov and
+// weighted random timelines run in a random order so each is planned as a single run
+// from the start. StartAfter is how long the timeline waits before it starts and is
+// part of the timings. Loops is how many times the timeline plays out, 0 for forever,
+// in which case the first pass is planned.
+type TimelinePlan struct {
+	Name       string
+	Selection  string
+	StartAfter time.Duration
+	Loops      int
+	Timeslices []*TimeslicePlan
+	duration   span
+}
+
+// random is true when the time slices of the timeline run in a random order.
+func (tp *TimelinePlan) random() bool {
+	return tp.Selection == trigger.SelectMarkov || tp.Selection == trigger.SelectWeightedRandom
+}
+
+// SeriesPlan is the number of points expected for a series on a connection.
+type SeriesPlan struct {
+	ConnectionType string
+	ConnectionID   string
+	Series         string
+	Points         int
+}
+
+// Plan is the expected timings and point counts for a story.
+// For continuous stories the plan covers the first pass of each timeline.
+// MaxDuration is when the story is stopped, 0 if it has no limit. Points that would be
+// sent after it are left out.
+type Plan struct {
+	StoryName   string
+	Continuous  bool
+	MaxDuration time.Duration
+	Timelines   []*TimelinePlan
+	Series      []*SeriesPlan
+	duration    span
+}
+
+// New creates a Plan for the story given.
+func New(story *config.Story) (*Plan, error) {
+	p := &Plan{
+		StoryName:   story.StoryName,
+		Continuous:  story.Continuous,
+		MaxDuration: story.ParsedMaxDuration(),
+	}
+	points := make(map[string]*SeriesPlan)
+	addPoints := func(connectionType, connectionID, series string, count int) {
+		key := strings.Join([]string{connectionType, connectionID, series}, "\x00")
+		if _, ok := points[key]; !ok {
+			points[key] = &SeriesPlan{
+				ConnectionType: connectionType,
+				ConnectionID:   connectionID,
+				Series:         series,
+			}
+		}
+		points[key].Points += count
+	}
+
+	for _, timeline := range story.TimeLines {
+		tp := &TimelinePlan{Name: timeline.Name, Selection: timeline.Selection, StartAfter: timeline.ParsedStartAfter()}
+		offset := span{min: tp.StartAfter, max: tp.StartAfter}
+		tp.duration = offset
+		loops, err := timeline.Loops(story.Continuous)
+		if err != nil {
+			return nil, err
+		}
+		tp.Loops = loops
+		// again is the time it takes to play out the time slices that run on every loop.
+		again := span{}
+		// The points are added once the timeline has been timed so that they can be cut
+		// short by the maximum duration of the story.
+		pending := []*plannedFires{}
+		for _, timeslice := range timeline.Timeslices {
+			tsp := &TimeslicePlan{
+				Name:      timeslice.Name,
+				Repeat:    timeslice.Repeat,
+				SingleUse: timeslice.SingleUse,
+				Schedule:  timeslice.Schedule,
+				WaitFor:   timeslice.WaitFor,
+				start:     tp.duration,
+			}
+			if timeslice.At != "" {
+				tsp.Schedule = "at " + timeslice.At
+			}
+			for _, anomaly := range timeslice.Anomalies {
+				tsp.Anomalies = append(tsp.Anomalies, anomaly.Type)
+			}
+			if tp.random() {
+				tsp.start = offset
+			}
+			// Single use time slices only run in the first loop.
+			passes := 1
+			if tp.Loops > 1 && !timeslice.SingleUse && !tp.random() {
+				passes = tp.Loops
+			}
+			if stateEvent := timeline.StateEvent(timeslice.Name); stateEvent != nil {
+				pending = append(pending, &plannedFires{
+					event:      stateEvent,
+					fires:      1,
+					passes:     passes,
+					start:      tsp.start,
+					againStart: again,
+				})
+			}
+			// play is how long it takes for the events to play out once.
+			play := span{}
+			for _, event := range timeslice.Events {
+				if eventSpan, ok := eventTiming(event, story.DiurnalProfiles); ok {
+					play = play.add(eventSpan)
+				}
+			}
+			plays := timeslice.Repeat
+			tsp.duration = play.times(timeslice.Repeat)
+			if limit := timeslice.ParsedDuration(); limit > 0 {
+				tsp.Duration = limit
+				plays, tsp.duration = limitedPlays(play, limit, timeslice.Repeat)
+			}
+			for _, event := range timeslice.Events {
+				if _, ok := eventTiming(event, story.DiurnalProfiles); !ok {
+					continue
+				}
+				// Gap anomalies stop some fires of each pass sending anything.
+				pending = append(pending, &plannedFires{
+					event:      event,
+					fires:      (event.Repeat - timeslice.GappedFires(event)) * plays,
+					passes:     passes,
+					start:      tsp.start,
+					againStart: again,
+					duration:   tsp.duration,
+				})
+			}
+			if !tp.random() {
+				tp.duration = tp.duration.add(tsp.duration)
+				if passes > 1 {
+					again = again.add(tsp.duration)
+				}
+			} else {
+				end := offset.add(tsp.duration)
+				if end.min > tp.duration.min {
+					tp.duration.min = end.min
+				}
+				if end.max > tp.duration.max {
+					tp.duration.max = end.max
+				}
+			}
+			tp.Timeslices = append(tp.Timeslices, tsp)
+		}
+		firstPass := tp.duration
+		if tp.Loops > 1 {
+			tp.duration = tp.duration.add(again.times(tp.Loops - 1))
+		}
+		for _, pf := range pending {
+			fires := 0
+			for pass := 0; pass < pf.passes; pass++ {
+				// Later passes only play the time slices that run on every loop.
+				start := pf.start
+				if pass > 0 {
+					start = firstPass.add(again.times(pass - 1)).add(pf.againStart)
+				}
+				fires += capFires(pf.fires, start, pf.duration, p.MaxDuration)
+			}
+			if err := eventPoints(pf.event, fires, addPoints); err != nil {
+				return nil, err
+			}
+		}
+		if tp.duration.min > p.duration.min {
+			p.duration.min = tp.duration.min
+		}
+		if tp.duration.max > p.duration.max {
+			p.duration.max = tp.duration.max
+		}
+		p.Timelines = append(p.Timelines, tp)
+	}
+
+	for _, sp := range points {
+		p.Series = append(p.Series, sp)
+	}
+	sort.Slice(p.Series, func(i, j int) bool {
+		a, b := p.Series[i], p.Series[j]
+		if a.ConnectionType != b.ConnectionType {
+			return a.ConnectionType < b.ConnectionType
+		}
+		if a.ConnectionID != b.ConnectionID {
+			return a.ConnectionID < b.ConnectionID
+		}
+		return a.Series < b.Series
+	})
+	return p, nil
+}
+
+// plannedFires is how many times an event fires in each pass of its timeline and when
+// its time slice plays. start is when the time slice starts in the first pass and
+// againStart is when it starts in the later passes, after the end of the one before.
+type plannedFires struct {
+	event      *config.Event
+	fires      int
+	passes     int
+	start      span
+	againStart span
+	duration   span
+}
+
+// capFires returns how many of the fires of a time slice that starts at start and lasts
+// for duration happen before the story stops at maxDuration. The fires are taken to be
+// spread evenly over the average duration of the time slice.
+func capFires(fires int, start, duration span, maxDuration time.Duration) int {
+	if maxDuration == 0 {
+		return fires
+	}
+	from := start.average()
+	switch {
+	case from >= maxDuration:
+		return 0
+	case from+duration.average() <= maxDuration:
+		return fires
+	}
+	return int(float64(fires) * float64(maxDuration-from) / float64(duration.average()))
+}
+
+// eventTiming returns how long all the repeats of an event will take. Events
+// without a usable timer are never added to the trigger so they return false.
+// Events whose rate follows a diurnal profile take from the time at the busiest
+// point of the profile to the time at the quietest.
+func eventTiming(event *config.Event, profiles map[string]*config.DiurnalProfile) (span, bool) {
+	tb := event.TimeBetween
+	var each span
+	switch {
+	case tb.Static.Time > 0:
+		each = span{
+			min: time.Duration(tb.Static.Time) * time.Millisecond,
+			max: time.Duration(tb.Static.Time) * time.Millisecond,
+		}
+	case tb.Dynamic.MinimumTime > 0 && tb.Dynamic.Vary > 0:
+		each = span{
+			min: time.Duration(tb.Dynamic.MinimumTime) * time.Millisecond,
+			max: time.Duration(tb.Dynamic.MinimumTime+tb.Dynamic.Vary-1) * time.Millisecond,
+		}
+	default:
+		return span{}, false
+	}
+	if profile, ok := profiles[tb.Diurnal]; ok {
+		if rate, err := profile.Profile(); err == nil {
+			low, high := rate.Range()
+			each = span{
+				min: time.Duration(float64(each.min) / math.Max(high, trigger.MinRateFactor)),
+				max: time.Duration(float64(each.max) / math.Max(low, trigger.MinRateFactor)),
+			}
+		}
+	}
+	return each.times(event.Repeat), true
+}
+
+func eventPoints(event *config.Event, fires int, addPoints func(string, string, string, int)) error {
+	switch event.Type {
+	case "sleeper":
+		return nil
+	case "annotation":
+		if event.Annotation == nil {
+			return nil
+		}
+		if event.ConnectionID != "" {
+			metric, err := metricCreator.NewAnnotation(event.MetricName, event.Annotation.Title, event.Annotation.Text, event.Annotation.Tags, event.Tags)
+			if err != nil {
+				return err
+			}
+			addPoints("influx", event.ConnectionID, metric.Series(), fires)
+		}
+		if event.Annotation.GrafanaConnectionID != "" {
+			addPoints("grafana", event.Annotation.GrafanaConnectionID, event.MetricName, fires)
+		}
+	case "http":
+		addPoints(event.Type, event.ConnectionID, event.MetricName, fires)
+	default:
+		if event.Rendered() {
+			return renderedPoints(event, fires, addPoints)
+		}
+		metric, err := metricCreator.NewMetric(event.MetricName, event.Tags, event.Fields)
+		if err != nil {
+			return err
+		}
+		addPoints(event.Type, event.ConnectionID, metric.Series(), fires)
+	}
+	return nil
+}
+
+// limitedPlays estimates how many times the events of a time slice with a duration play
+// out, using the average time of a play, and how long the time slice takes. The time
+// slice stops early if it has a repeat that is reached before the duration.
+func limitedPlays(play span, limit time.Duration, repeat int) (int, span) {
+	duration := span{min: limit, max: limit}
+	if repeat > 0 {
+		if byRepeat := play.times(repeat); byRepeat.min < limit {
+			duration.min = byRepeat.min
+		}
+		if byRepeat := play.times(repeat); byRepeat.max < limit {
+			duration.max = byRepeat.max
+		}
+	}
+	average := (play.min + play.max) / 2
+	if average <= 0 {
+		return 0, duration
+	}
+	plays := int(limit / average)
+	if repeat > 0 && repeat < plays {
+		plays = repeat
+	}
+	return plays, duration
+}
+
+// renderedPoints adds the points of an event with a fan out or random tags as a single
+// row so that large fan outs don't fill the plan.
+func renderedPoints(event *config.Event, fires int, addPoints func(string, string, string, int)) error {
+	template, err := event.MetricTemplate()
+	if err != nil {
+		return err
+	}
+	fanOut, err := metricCreator.NewFanOut(template, event.FanOut, event.FanOutMode, event.FanOutSize)
+	if err != nil {
+		return err
+	}
+	metric, err := metricCreator.NewMetric(event.MetricName, event.Tags, event.Fields)
+	if err != nil {
+		return err
+	}
+	series := metric.Series()
+	if len(event.FanOut) > 0 {
+		series = fmt.Sprintf("%s fanned out to %d series", series, fanOut.Len())
+	}
+	if randomTags := template.RandomTags(); len(randomTags) > 0 {
+		series = fmt.Sprintf("%s with random %s", series, strings.Join(randomTags, ","))
+	}
+	addPoints(event.Type, event.ConnectionID, series, fires*fanOut.PerFire())
+	return nil
+}
+
+// bar draws the span of time from start to end scaled to the width of the chart.
+// The minimum time is drawn with # and the extra time that dynamic timers could take with =.
+func bar(start, duration span, total time.Duration) string {
+	if total == 0 {
+		return strings.Repeat(" ", chartWidth)
+	}
+	scale := func(d time.Duration) int {
+		return int(int64(d) * chartWidth / int64(total))
+	}
+	from := scale(start.min)
+	minEnd := scale(start.min + duration.min)
+	maxEnd := scale(start.max + duration.max)
+	if minEnd == from && duration.min > 0 {
+		minEnd++
+	}
+	if maxEnd < minEnd {
+		maxEnd = minEnd
+	}
+	if maxEnd > chartWidth {
+		maxEnd = chartWidth
+	}
+	if minEnd > chartWidth {
+		minEnd = chartWidth
+	}
+	return strings.Repeat(" ", from) +
+		strings.Repeat("#", minEnd-from) +
+		strings.Repeat("=", maxEnd-minEnd) +
+		strings.Repeat(" ", chartWidth-maxEnd)
+}
+
+// String returns the plan as a Gantt style chart followed by the expected points.
+func (p *Plan) String() string {
+	buf := &bytes.Buffer{}
+	runType := "single run"
+	if p.Continuous {
+		runType = "continuous, showing the first pass"
+	}
+	fmt.Fprintf(buf, "Story %q (%s)\n", p.StoryName, runType)
+	fmt.Fprintf(buf, "Expected duration: %s\n", p.duration)
+	if p.MaxDuration > 0 {
+		note := ""
+		if p.MaxDuration < p.duration.max {
+			note = " (before the timelines can finish, the points below stop there)"
+		}
+		fmt.Fprintf(buf, "Stops after: %s%s\n", p.MaxDuration, note)
+	}
+	fmt.Fprintln(buf)
+
+	tw := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	for _, tl := range p.Timelines {
+		name := fmt.Sprintf("Timeline %q", tl.Name)
+		if tl.random() {
+			name += fmt.Sprintf(" (%s, one run of each time slice)", tl.Selection)
+		}
+		switch {
+		case tl.Loops > 1 && !tl.random():
+			name += fmt.Sprintf(" (loops %d times)", tl.Loops)
+		case tl.Loops == 0 && !p.Continuous:
+			name += " (loops forever, showing the first pass)"
+		}
+		if tl.StartAfter > 0 {
+			name += fmt.Sprintf(" (starts after %s)", tl.StartAfter)
+		}
+		fmt.Fprintf(tw, "%s\t\t%s\n", name, tl.duration)
+		for _, ts := range tl.Timeslices {
+			label := fmt.Sprintf("  %s x%d", ts.Name, ts.Repeat)
+			if ts.Duration > 0 {
+				label = fmt.Sprintf("  %s for %s", ts.Name, ts.Duration)
+				if ts.Repeat > 0 {
+					label += fmt.Sprintf(" or x%d", ts.Repeat)
+				}
+			}
+			if ts.SingleUse {
+				label += " (single use)"
+			}
+			if ts.Schedule != "" {
+				label += fmt.Sprintf(" (waits for %s)", ts.Schedule)
+			}
+			if ts.WaitFor != "" {
+				label += fmt.Sprintf(" (waits for signal %s)", ts.WaitFor)
+			}
+			if len(ts.Anomalies) > 0 {
+				label += fmt.Sprintf(" (anomalies: %s)", strings.Join(ts.Anomalies, ", "))
+			}
+			fmt.Fprintf(tw, "%s\t|%s|\t%s\n", label, bar(ts.start, ts.duration, p.duration.max), ts.duration)
+		}
+	}
+	tw.Flush()
+
+	fmt.Fprintln(buf)
+	if len(p.Series) == 0 {
+		fmt.Fprintln(buf, "No points are expected.")
+		return buf.String()
+	}
+	fmt.Fprintln(buf, "Expected points")
+	tw = tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  CONNECTION\tSERIES\tPOINTS")
+	for _, sp := range p.Series {
+		fmt.Fprintf(tw, "  %s %s\t%s\t%d\n", sp.ConnectionType, sp.ConnectionID, sp.Series, sp.Points)
+	}
+	tw.Flush()
+	return buf.String()
+}
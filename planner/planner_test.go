@@ -0,0 +1,304 @@
+package planner
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/silverstagtech/teller/config"
+)
+
+func staticEvent(name, eventType, connectionID string, repeat, ms int) *config.Event {
+	event := &config.Event{
+		MetricName:   name,
+		Type:         eventType,
+		ConnectionID: connectionID,
+		Repeat:       repeat,
+		Tags:         map[string]string{"host": "a"},
+		Fields:       map[string]interface{}{"value": 1},
+	}
+	event.TimeBetween.Static.Time = ms
+	return event
+}
+
+func dynamicEvent(name, eventType, connectionID string, repeat, min, vary int) *config.Event {
+	event := staticEvent(name, eventType, connectionID, repeat, 0)
+	event.TimeBetween.Dynamic.MinimumTime = min
+	event.TimeBetween.Dynamic.Vary = vary
+	return event
+}
+
+func testStory() *config.Story {
+	return &config.Story{
+		StoryName: "plan",
+		TimeLines: []*config.TimeLine{
+			{
+				Name: "first",
+				Timeslices: []*config.Timeslice{
+					{
+						Name:      "startup",
+						Repeat:    1,
+						SingleUse: true,
+						Events:    []*config.Event{staticEvent("cpu", "influx", "influx1", 10, 100)},
+					},
+					{
+						Name:   "running",
+						Repeat: 2,
+						Events: []*config.Event{
+							dynamicEvent("cpu", "influx", "influx1", 5, 100, 101),
+							staticEvent("nap", "sleeper", "", 1, 1000),
+						},
+					},
+				},
+			},
+			{
+				Name: "second",
+				Timeslices: []*config.Timeslice{
+					{
+						Name:   "only",
+						Repeat: 3,
+						Events: []*config.Event{staticEvent("requests", "statsd", "statsd1", 2, 50)},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestPlanTimings(t *testing.T) {
+	p, err := New(testStory())
+	if err != nil {
+		t.Logf("Failed to create a plan. Error: %s", err)
+		t.FailNow()
+	}
+
+	// startup: 10 * 100ms. running: 2 * (5 * 100..200ms + 1s).
+	expectedMin := time.Second + 2*(500*time.Millisecond+time.Second)
+	expectedMax := time.Second + 2*(time.Second+time.Second)
+	if p.duration.min != expectedMin || p.duration.max != expectedMax {
+		t.Logf("Unexpected story duration. Expected: %s..%s Got: %s", expectedMin, expectedMax, p.duration)
+		t.Fail()
+	}
+	if second := p.Timelines[1].duration; second.min != 300*time.Millisecond || second.max != 300*time.Millisecond {
+		t.Logf("Unexpected timeline duration. Expected: 300ms Got: %s", second)
+		t.Fail()
+	}
+	if running := p.Timelines[0].Timeslices[1]; running.start.min != time.Second {
+		t.Logf("Expected the running slice to start after 1s. Got: %s", running.start)
+		t.Fail()
+	}
+}
+
+func TestPlanPoints(t *testing.T) {
+	p, err := New(testStory())
+	if err != nil {
+		t.Logf("Failed to create a plan. Error: %s", err)
+		t.FailNow()
+	}
+	expected := map[string]int{
+		"influx influx1 cpu,host=a":      20,
+		"statsd statsd1 requests,host=a": 6,
+	}
+	if len(p.Series) != len(expected) {
+		t.Logf("Expected %d series. Got: %d", len(expected), len(p.Series))
+		t.Fail()
+	}
+	for _, sp := range p.Series {
+		key := strings.Join([]string{sp.ConnectionType, sp.ConnectionID, sp.Series}, " ")
+		if expected[key] != sp.Points {
+			t.Logf("Series %s expected %d points. Got: %d", key, expected[key], sp.Points)
+			t.Fail()
+		}
+	}
+}
+
+func TestPlanString(t *testing.T) {
+	p, _ := New(testStory())
+	out := p.String()
+	for _, expected := range []string{`Story "plan" (single run)`, `Timeline "first"`, "startup x1 (single use)", "|#", "cpu,host=a"} {
+		if !strings.Contains(out, expected) {
+			t.Logf("Plan output is missing %q.\nGot:\n%s", expected, out)
+			t.Fail()
+		}
+	}
+}
+
+func TestPlanFanOut(t *testing.T) {
+	story := testStory()
+	fanOut := story.TimeLines[1].Timeslices[0].Events[0]
+	fanOut.FanOut = map[string]interface{}{"host": "web-{1..50}", "region": []interface{}{"eu", "us"}}
+	p, err := New(story)
+	if err != nil {
+		t.Logf("Failed to create a plan. Error: %s", err)
+		t.FailNow()
+	}
+	found := false
+	for _, sp := range p.Series {
+		if sp.Series == "requests,host=a fanned out to 100 series" {
+			found = true
+			if sp.Points != 600 {
+				t.Logf("Expected 600 points for the fan out. Got: %d", sp.Points)
+				t.Fail()
+			}
+		}
+	}
+	if !found {
+		t.Logf("Expected a single row for the fan out.")
+		t.Fail()
+	}
+}
+
+func TestPlanDurationAndSchedule(t *testing.T) {
+	story := testStory()
+	only := story.TimeLines[1].Timeslices[0]
+	only.Repeat = 0
+	only.Duration = "1s"
+	only.At = "02:00"
+	p, err := New(story)
+	if err != nil {
+		t.Logf("Failed to create a plan. Error: %s", err)
+		t.FailNow()
+	}
+	if p.Timelines[1].duration.max != time.Second {
+		t.Logf("Expected the time slice to take 1s. Got: %s", p.Timelines[1].duration)
+		t.Fail()
+	}
+	for _, sp := range p.Series {
+		// Each play takes 100ms so 10 plays of 2 fires fit in 1s.
+		if sp.ConnectionType == "statsd" && sp.Points != 20 {
+			t.Logf("Expected 20 points in 1s. Got: %d", sp.Points)
+			t.Fail()
+		}
+	}
+	out := p.String()
+	if !strings.Contains(out, "only for 1s (waits for at 02:00)") {
+		t.Logf("Plan output is missing the duration and schedule.\nGot:\n%s", out)
+		t.Fail()
+	}
+}
+
+func TestPlanStartAfterAndSignals(t *testing.T) {
+	story := testStory()
+	story.TimeLines[1].StartAfter = "2s"
+	story.TimeLines[1].Timeslices[0].WaitFor = "ready"
+	story.TimeLines[1].Timeslices[0].Anomalies = []*config.Anomaly{{Type: "spike"}, {Type: "gap"}}
+	p, err := New(story)
+	if err != nil {
+		t.Logf("Failed to create a plan. Error: %s", err)
+		t.FailNow()
+	}
+	// The only time slice takes 3 x 100ms after the 2s delay.
+	if p.Timelines[1].duration.max != 2300*time.Millisecond {
+		t.Logf("Expected the second timeline to take 2.3s. Got: %s", p.Timelines[1].duration)
+		t.Fail()
+	}
+	out := p.String()
+	for _, expected := range []string{`Timeline "second" (starts after 2s)`, "only x3 (waits for signal ready) (anomalies: spike, gap)"} {
+		if !strings.Contains(out, expected) {
+			t.Logf("Plan output is missing %q.\nGot:\n%s", expected, out)
+			t.Fail()
+		}
+	}
+}
+
+func TestPlanGaps(t *testing.T) {
+	story := testStory()
+	story.TimeLines[1].Timeslices[0].Anomalies = []*config.Anomaly{
+		{Type: "gap", StartFire: 1},
+		{Type: "gap", Events: []string{"other"}},
+	}
+	p, err := New(story)
+	if err != nil {
+		t.Logf("Failed to create a plan. Error: %s", err)
+		t.FailNow()
+	}
+	// Only the second of the 2 fires in each of the 3 plays is a gap.
+	for _, sp := range p.Series {
+		if sp.Series == "requests,host=a" && sp.Points != 3 {
+			t.Logf("Expected the gaps to leave 3 points. Got: %d", sp.Points)
+			t.Fail()
+		}
+	}
+}
+
+func TestPlanLoops(t *testing.T) {
+	story := testStory()
+	story.TimeLines[0].Loop = float64(2)
+	story.TimeLines[1].Loop = config.LoopForever
+	p, err := New(story)
+	if err != nil {
+		t.Logf("Failed to create a plan. Error: %s", err)
+		t.FailNow()
+	}
+	// startup is single use so only running is played again.
+	first := p.Timelines[0]
+	expected := first.Timeslices[0].duration.add(first.Timeslices[1].duration.times(2))
+	if first.duration != expected {
+		t.Logf("Expected the first timeline to take %s. Got: %s", expected, first.duration)
+		t.Fail()
+	}
+	out := p.String()
+	for _, expected := range []string{`Timeline "first" (loops 2 times)`, `Timeline "second" (loops forever, showing the first pass)`} {
+		if !strings.Contains(out, expected) {
+			t.Logf("Plan output is missing %q.\nGot:\n%s", expected, out)
+			t.Fail()
+		}
+	}
+}
+
+func TestPlanMaxDuration(t *testing.T) {
+	story := testStory()
+	story.MaxDuration = "1s"
+	p, err := New(story)
+	if err != nil {
+		t.Logf("Failed to create a plan. Error: %s", err)
+		t.FailNow()
+	}
+	if out := p.String(); !strings.Contains(out, "Stops after: 1s (before the timelines can finish") {
+		t.Logf("Plan output is missing the maximum duration.\nGot:\n%s", out)
+		t.Fail()
+	}
+
+	// startup takes 1s and running starts after it. The second timeline takes 300ms.
+	tests := map[string]map[string]int{
+		"1s":    {"influx influx1 cpu,host=a": 10, "statsd statsd1 requests,host=a": 6},
+		"150ms": {"influx influx1 cpu,host=a": 1, "statsd statsd1 requests,host=a": 3},
+	}
+	for maxDuration, expected := range tests {
+		story.MaxDuration = maxDuration
+		p, err := New(story)
+		if err != nil {
+			t.Logf("Failed to create a plan. Error: %s", err)
+			t.FailNow()
+		}
+		for _, sp := range p.Series {
+			key := strings.Join([]string{sp.ConnectionType, sp.ConnectionID, sp.Series}, " ")
+			if expected[key] != sp.Points {
+				t.Logf("Stopping after %s series %s expected %d points. Got: %d", maxDuration, key, expected[key], sp.Points)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestPlanDiurnalRate(t *testing.T) {
+	story := testStory()
+	hourly := make([]float64, 24)
+	for hour := range hourly {
+		hourly[hour] = 1
+	}
+	hourly[3], hourly[15] = 0.5, 2
+	story.DiurnalProfiles = map[string]*config.DiurnalProfile{"web": {Hourly: hourly}}
+	story.TimeLines[1].Timeslices[0].Events[0].TimeBetween.Diurnal = "web"
+	p, err := New(story)
+	if err != nil {
+		t.Logf("Failed to create a plan. Error: %s", err)
+		t.FailNow()
+	}
+	// 3 plays of 2 x 50ms run twice as fast at the busiest hour and half as fast at the quietest.
+	expected := span{min: 150 * time.Millisecond, max: 600 * time.Millisecond}
+	if p.Timelines[1].duration != expected {
+		t.Logf("Expected the second timeline to take %s. Got: %s", expected, p.Timelines[1].duration)
+		t.Fail()
+	}
+}
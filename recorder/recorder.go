@@ -0,0 +1,237 @@
+// Package recorder keeps a summary of the metrics that a story would have sent.
+//
+// It is used in place of the real connections when doing a dry run. Every metric is
+// recorded against the connection it would have been sent to and the series it belongs
+// to. The summary has the number of points, the first and last time the series fired
+// and the range of values seen for each field.
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	timeFormat = "15:04:05.000"
+)
+
+type fieldRange struct {
+	numeric    bool
+	min        float64
+	max        float64
+	nonNumbers int
+}
+
+func (fr *fieldRange) add(value interface{}) {
+	number, ok := toFloat(value)
+	if !ok {
+		fr.nonNumbers++
+		return
+	}
+	if !fr.numeric {
+		fr.numeric = true
+		fr.min = number
+		fr.max = number
+		return
+	}
+	if number < fr.min {
+		fr.min = number
+	}
+	if number > fr.max {
+		fr.max = number
+	}
+}
+
+func (fr *fieldRange) String() string {
+	if !fr.numeric {
+		return "not a number"
+	}
+	min := strconv.FormatFloat(fr.min, 'g', -1, 64)
+	max := strconv.FormatFloat(fr.max, 'g', -1, 64)
+	if min == max {
+		return min
+	}
+	return fmt.Sprintf("%s..%s", min, max)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case string:
+		number, err := strconv.ParseFloat(v, 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+type series struct {
+	points    int
+	firstFire time.Time
+	lastFire  time.Time
+	fields    map[string]*fieldRange
+}
+
+type connection struct {
+	connectionType string
+	id             string
+	series         map[string]*series
+}
+
+// Recorder records metrics against the connection and series they belong to.
+// It is safe to use from many go routines.
+type Recorder struct {
+	sync.Mutex
+	connections map[string]*connection
+}
+
+// New returns a empty Recorder.
+func New() *Recorder {
+	return &Recorder{
+		connections: make(map[string]*connection),
+	}
+}
+
+// Record adds a point to the series of the connection given.
+func (r *Recorder) Record(connectionType, connectionID, seriesName string, fields map[string]interface{}, fired time.Time) {
+	r.Lock()
+	defer r.Unlock()
+
+	key := fmt.Sprintf("%s %s", connectionType, connectionID)
+	c, ok := r.connections[key]
+	if !ok {
+		c = &connection{
+			connectionType: connectionType,
+			id:             connectionID,
+			series:         make(map[string]*series),
+		}
+		r.connections[key] = c
+	}
+	s, ok := c.series[seriesName]
+	if !ok {
+		s = &series{
+			firstFire: fired,
+			lastFire:  fired,
+			fields:    make(map[string]*fieldRange),
+		}
+		c.series[seriesName] = s
+	}
+	s.points++
+	// Points are not always recorded in time order as they can be delayed or backdated.
+	if fired.Before(s.firstFire) {
+		s.firstFire = fired
+	}
+	if fired.After(s.lastFire) {
+		s.lastFire = fired
+	}
+	for name, value := range fields {
+		if _, ok := s.fields[name]; !ok {
+			s.fields[name] = &fieldRange{}
+		}
+		s.fields[name].add(value)
+	}
+}
+
+// Points returns the number of points recorded for a series on a connection.
+func (r *Recorder) Points(connectionType, connectionID, seriesName string) int {
+	r.Lock()
+	defer r.Unlock()
+	c, ok := r.connections[fmt.Sprintf("%s %s", connectionType, connectionID)]
+	if !ok {
+		return 0
+	}
+	s, ok := c.series[seriesName]
+	if !ok {
+		return 0
+	}
+	return s.points
+}
+
+// Times returns the earliest and latest times of the points recorded for a series on a
+// connection. They are zero if nothing was recorded.
+func (r *Recorder) Times(connectionType, connectionID, seriesName string) (time.Time, time.Time) {
+	r.Lock()
+	defer r.Unlock()
+	c, ok := r.connections[fmt.Sprintf("%s %s", connectionType, connectionID)]
+	if !ok {
+		return time.Time{}, time.Time{}
+	}
+	s, ok := c.series[seriesName]
+	if !ok {
+		return time.Time{}, time.Time{}
+	}
+	return s.firstFire, s.lastFire
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Summary returns a table per connection showing each series that was recorded.
+func (r *Recorder) Summary() string {
+	r.Lock()
+	defer r.Unlock()
+
+	buf := &bytes.Buffer{}
+	if len(r.connections) == 0 {
+		fmt.Fprintln(buf, "No metrics were recorded.")
+		return buf.String()
+	}
+
+	connectionKeys := make(map[string]bool)
+	for key := range r.connections {
+		connectionKeys[key] = true
+	}
+	for _, key := range sortedKeys(connectionKeys) {
+		c := r.connections[key]
+		total := 0
+		for _, s := range c.series {
+			total += s.points
+		}
+		fmt.Fprintf(buf, "%s connection %q: %d points in %d series\n", c.connectionType, c.id, total, len(c.series))
+
+		tw := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  SERIES\tPOINTS\tFIRST FIRE\tLAST FIRE\tVALUES")
+		seriesNames := make(map[string]bool)
+		for name := range c.series {
+			seriesNames[name] = true
+		}
+		for _, name := range sortedKeys(seriesNames) {
+			s := c.series[name]
+			fieldNames := make(map[string]bool)
+			for field := range s.fields {
+				fieldNames[field] = true
+			}
+			values := []string{}
+			for _, field := range sortedKeys(fieldNames) {
+				values = append(values, fmt.Sprintf("%s=%s", field, s.fields[field]))
+			}
+			fmt.Fprintf(tw, "  %s\t%d\t%s\t%s\t%s\n",
+				name,
+				s.points,
+				s.firstFire.Format(timeFormat),
+				s.lastFire.Format(timeFormat),
+				strings.Join(values, " "),
+			)
+		}
+		tw.Flush()
+	}
+	return buf.String()
+}
@@ -0,0 +1,58 @@
+package recorder
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecord(t *testing.T) {
+	r := New()
+	start := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	r.Record("influx", "influx1", "cpu,host=a", map[string]interface{}{"usage": 10.0, "state": "ok"}, start)
+	r.Record("influx", "influx1", "cpu,host=a", map[string]interface{}{"usage": 50.0, "state": "ok"}, start.Add(time.Second))
+	r.Record("influx", "influx1", "cpu,host=b", map[string]interface{}{"usage": 5}, start)
+	r.Record("statsd", "statsd1", "requests", map[string]interface{}{"count": "+5"}, start)
+
+	if points := r.Points("influx", "influx1", "cpu,host=a"); points != 2 {
+		t.Logf("Expected 2 points for cpu,host=a. Got: %d", points)
+		t.Fail()
+	}
+	if points := r.Points("statsd", "influx1", "cpu,host=a"); points != 0 {
+		t.Logf("Expected 0 points for a connection that was not used. Got: %d", points)
+		t.Fail()
+	}
+	if first, last := r.Times("influx", "influx1", "cpu,host=a"); !first.Equal(start) || !last.Equal(start.Add(time.Second)) {
+		t.Logf("Expected the first and last times of cpu,host=a. Got: %s and %s", first, last)
+		t.Fail()
+	}
+
+	backdated := New()
+	backdated.Record("influx", "influx1", "cpu", nil, start)
+	backdated.Record("influx", "influx1", "cpu", nil, start.Add(-time.Minute))
+	if first, last := backdated.Times("influx", "influx1", "cpu"); !first.Equal(start.Add(-time.Minute)) || !last.Equal(start) {
+		t.Logf("Expected the earliest and latest times of a backdated series. Got: %s and %s", first, last)
+		t.Fail()
+	}
+
+	summary := r.Summary()
+	expectedLines := []string{
+		`influx connection "influx1": 3 points in 2 series`,
+		`statsd connection "statsd1": 1 points in 1 series`,
+		`cpu,host=a  2       12:00:00.000  12:00:01.000  state=not a number usage=10..50`,
+		`requests  1       12:00:00.000  12:00:00.000  count=5`,
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(summary, line) {
+			t.Logf("Summary is missing %q.\nGot:\n%s", line, summary)
+			t.Fail()
+		}
+	}
+}
+
+func TestEmptySummary(t *testing.T) {
+	if summary := New().Summary(); !strings.Contains(summary, "No metrics") {
+		t.Logf("Expected the empty summary to say no metrics were recorded. Got: %s", summary)
+		t.Fail()
+	}
+}
@@ -3,6 +3,7 @@ package statsdShipper
 import (
 	"fmt"
 	"net"
+	"strconv"
 
 	"github.com/silverstagtech/loggos"
 )
@@ -111,7 +112,7 @@ func (sd *StatsDShipper) Connect() error {
 	jm.Add("transport", sd.transport)
 	loggos.SendJSON(jm)
 
-	conn, err := net.Dial(sd.transport, fmt.Sprintf("%s:%d", sd.host, sd.port))
+	conn, err := net.Dial(sd.transport, net.JoinHostPort(sd.host, strconv.Itoa(int(sd.port))))
 	if err != nil {
 		return err
 	}
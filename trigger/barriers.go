@@ -0,0 +1,65 @@
+package trigger
+
+import (
+	"sync"
+)
+
+// barrier counts how many times a signal has been sent. changed is closed and replaced
+// each time the signal is sent to wake up anything waiting.
+type barrier struct {
+	count   int
+	changed chan struct{}
+}
+
+// Barriers are named signals that are shared between triggers. A time slice in one
+// trigger can wait for a signal that a time slice in another trigger sends, which keeps
+// the order of events across timelines even when their timers vary.
+type Barriers struct {
+	lock     sync.Mutex
+	barriers map[string]*barrier
+}
+
+// NewBarriers creates an empty set of Barriers.
+func NewBarriers() *Barriers {
+	return &Barriers{
+		barriers: make(map[string]*barrier),
+	}
+}
+
+func (b *Barriers) get(name string) *barrier {
+	br, ok := b.barriers[name]
+	if !ok {
+		br = &barrier{changed: make(chan struct{})}
+		b.barriers[name] = br
+	}
+	return br
+}
+
+// Signal sends the named signal, releasing anything that is waiting for it.
+func (b *Barriers) Signal(name string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	br := b.get(name)
+	br.count++
+	close(br.changed)
+	br.changed = make(chan struct{})
+}
+
+// wait blocks until the named signal has been sent more than seen times, then returns
+// the number of times it has been sent. It returns false if stop is closed first.
+func (b *Barriers) wait(name string, seen int, stop chan bool) (int, bool) {
+	for {
+		b.lock.Lock()
+		br := b.get(name)
+		count, changed := br.count, br.changed
+		b.lock.Unlock()
+		if count > seen {
+			return count, true
+		}
+		select {
+		case <-changed:
+		case <-stop:
+			return seen, false
+		}
+	}
+}
@@ -3,6 +3,7 @@ package trigger
 import (
 	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/silverstagtech/loggos"
@@ -12,7 +13,41 @@ func init() {
 	rand.Seed(time.Now().UTC().UnixNano())
 }
 
-type timer func() chan string
+const (
+	// SelectSequential runs the time slices in the order that they were added.
+	SelectSequential = "sequential"
+	// SelectMarkov treats the time slices as states. The first time slice is the starting
+	// state and the next time slice is picked from the transitions of the current one.
+	SelectMarkov = "markov"
+	// SelectWeightedRandom picks each time slice at random using the weights of the time
+	// slices. A pass picks as many time slices as the timeline has.
+	SelectWeightedRandom = "weighted_random"
+)
+
+// timer sends its id down the returned chan each time it fires and closes the chan when
+// it is finished. Closing stop makes the timer finish early.
+type timer func(stop chan struct{}) chan string
+
+// Schedule gives the wall clock times that a time slice starts at.
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// Rate changes how often an event fires over time. Factor is how many times faster than
+// normal the event fires at a point in time.
+type Rate interface {
+	Factor(at time.Time) float64
+}
+
+// MinRateFactor is the slowest a Rate can make an event fire. It stops a rate of 0 from
+// making an event wait forever.
+const MinRateFactor = 0.01
+
+// transition is the chance, as a percentage, of moving to another time slice.
+type transition struct {
+	to     int
+	weight float64
+}
 
 type timeslice struct {
 	repeat       int
@@ -20,6 +55,14 @@ type timeslice struct {
 	singleUse    bool
 	allowedToRun bool
 	timers       []timer
+	startIDs     []string
+	transitions  []transition
+	weight       float64
+	duration     time.Duration
+	schedule     Schedule
+	waitFor      string
+	waitSeen     int
+	signals      []string
 }
 
 func (tl *timeslice) String() string {
@@ -33,8 +76,20 @@ type Trigger struct {
 	Ready      chan string
 	timeslices []*timeslice
 	shutdown   chan bool
+	stopOnce   sync.Once
+	readyOnce  sync.Once
+	running    bool
+	finished   chan struct{}
 	continuous bool
-	stopped    bool
+	selection  string
+	passSteps  int
+	startDelay time.Duration
+	barriers   *Barriers
+	loops      int
+	passes     int
+	rates      map[string]Rate
+	clock      func() time.Time
+	speed      float64
 }
 
 // New creates a new Trigger and returns it. You will need to populate it with triggers,
@@ -43,10 +98,26 @@ func New(name string, continuous bool) *Trigger {
 	return &Trigger{
 		Ready:      make(chan string, 5000),
 		shutdown:   make(chan bool, 1),
+		finished:   make(chan struct{}),
 		timeslices: make([]*timeslice, 0),
 		continuous: continuous,
 		name:       name,
+		selection:  SelectSequential,
+		rates:      make(map[string]Rate),
+		clock:      time.Now,
+		speed:      1,
+	}
+}
+
+// SetSelection changes how the next time slice to run is picked. It must be one of
+// SelectSequential, SelectMarkov or SelectWeightedRandom and be called before Start.
+func (tr *Trigger) SetSelection(selection string) error {
+	switch selection {
+	case SelectSequential, SelectMarkov, SelectWeightedRandom:
+		tr.selection = selection
+		return nil
 	}
+	return fmt.Errorf("time slice selection %s is not valid", selection)
 }
 
 // Start will cycle through the added triggers and fill the Ready channel with the ids as they
@@ -59,6 +130,7 @@ func (tr *Trigger) Start() error {
 	if len(tr.timeslices) == 0 {
 		return fmt.Errorf("There are no timers to run")
 	}
+	tr.running = true
 	go tr.pullTriggers()
 	return nil
 }
@@ -74,22 +146,44 @@ func (tr *Trigger) Stop() chan bool {
 	c := make(chan bool)
 	go func() {
 		tr.teardown()
+		// Ready is only closed once nothing can send to it, which is after the triggers
+		// are no longer being pulled.
+		if tr.running {
+			<-tr.finished
+		}
+		tr.closeReady()
 		c <- true
 		close(c)
 	}()
 	return c
 }
 
+// teardown tells everything that the trigger is running to stop. It is safe to call more
+// than once.
 func (tr *Trigger) teardown() {
-	if !tr.stopped {
-		tr.stopped = true
+	tr.stopOnce.Do(func() {
 		close(tr.shutdown)
+	})
+}
+
+func (tr *Trigger) closeReady() {
+	tr.readyOnce.Do(func() {
 		close(tr.Ready)
+	})
+}
+
+// stopped returns true once the trigger has been told to stop.
+func (tr *Trigger) stopped() bool {
+	select {
+	case <-tr.shutdown:
+		return true
+	default:
+		return false
 	}
 }
 
 func (tr *Trigger) hasNothingToDo() bool {
-	if tr.stopped {
+	if tr.stopped() {
 		return true
 	}
 
@@ -104,9 +198,25 @@ func (tr *Trigger) hasNothingToDo() bool {
 	return true
 }
 
-// pullTriggets will go though each of the triggers and execute the function which will
-// then feed the Ready chan. If the stopChan is closed then will exit out.
+// pullTriggets will pick each time slice in turn and run it. If the stopChan is closed
+// then will exit out.
 func (tr *Trigger) pullTriggers() {
+	defer func() {
+		tr.closeReady()
+		close(tr.finished)
+	}()
+	if tr.startDelay > 0 {
+		jm := loggos.JSONDebugln("Waiting to start timeline")
+		jm.Add("name", tr.name)
+		jm.Add("start_after", tr.startDelay.String())
+		loggos.SendJSON(jm)
+
+		startTimer := time.NewTimer(tr.scale(tr.startDelay))
+		tr.waitFor(startTimer.C)
+		startTimer.Stop()
+	}
+
+	current := -1
 	for {
 		// Is there still work to do?
 		if tr.hasNothingToDo() {
@@ -118,77 +228,302 @@ func (tr *Trigger) pullTriggers() {
 			return
 		}
 
-		// Go through the timeslices and check for the ones that have events to send.
-	Top:
-		for _, timeslice := range tr.timeslices {
-			if timeslice.allowedToRun {
-				for i := 0; i < timeslice.repeat; i++ {
-					for _, timer := range timeslice.timers {
-						if tr.stopped {
-							break Top
-						}
-						jm := loggos.JSONDebugln("Starting next timer for time slice")
-						jm.Add("name", tr.name)
-						jm.Add("timeslice_name", timeslice.name)
-						loggos.SendJSON(jm)
-
-						tc := timer()
-						tr.consumeFromTimer(tc)
-					}
-				}
-			}
+		next, ok := tr.nextTimeslice(current)
+		if !ok {
+			// A pass through the timeline is finished.
+			tr.passes++
+			if tr.loops > 0 && tr.passes >= tr.loops {
+				jm := loggos.JSONInfoln("Timeline has finished its loops")
+				jm.Add("name", tr.name)
+				jm.Add("loops", tr.loops)
+				loggos.SendJSON(jm)
 
-			// If a timeslice is single mark it as used.
-			if timeslice.singleUse && timeslice.allowedToRun {
-				jm := loggos.JSONDebugln("Time slice is marked as single use. Stopping future runs")
+				tr.teardown()
+				return
+			}
+			if !tr.continuous || current == -1 {
+				jm := loggos.JSONInfoln("Timeline is a single run and is now finished")
 				jm.Add("name", tr.name)
-				jm.Add("timeslice_name", timeslice.name)
 				loggos.SendJSON(jm)
 
-				timeslice.allowedToRun = false
+				tr.teardown()
+				return
+			}
+			current = -1
+			continue
+		}
+		tr.runTimeslice(tr.timeslices[next])
+		current = next
+	}
+}
+
+// nextTimeslice returns the index of the time slice to run after current. current is -1
+// at the start of a pass. false is returned when the pass is finished.
+func (tr *Trigger) nextTimeslice(current int) (int, bool) {
+	switch tr.selection {
+	case SelectMarkov:
+		return tr.nextState(current)
+	case SelectWeightedRandom:
+		return tr.nextWeighted(current)
+	default:
+		for index := current + 1; index < len(tr.timeslices); index++ {
+			if tr.timeslices[index].allowedToRun {
+				return index, true
 			}
 		}
+		return -1, false
+	}
+}
 
-		if !tr.continuous {
-			jm := loggos.JSONInfoln("Timeline is a single run and is now finished")
+// nextState picks the next state using the transitions of the current state. Any chance
+// left over after the transitions keeps the current state. A state without transitions
+// finishes the pass.
+func (tr *Trigger) nextState(current int) (int, bool) {
+	if current < 0 {
+		return 0, tr.timeslices[0].allowedToRun
+	}
+	transitions := tr.timeslices[current].transitions
+	if len(transitions) == 0 {
+		return -1, false
+	}
+	total := 0.0
+	for _, t := range transitions {
+		total += t.weight
+	}
+	if total < 100 {
+		total = 100
+	}
+	pick := rand.Float64() * total
+	for _, t := range transitions {
+		if pick < t.weight {
+			return t.to, true
+		}
+		pick -= t.weight
+	}
+	return current, true
+}
+
+// nextWeighted picks a time slice that is allowed to run at random using the weights.
+func (tr *Trigger) nextWeighted(current int) (int, bool) {
+	if current < 0 {
+		tr.passSteps = 0
+	}
+	if tr.passSteps >= len(tr.timeslices) {
+		return -1, false
+	}
+	total := 0.0
+	for _, timeslice := range tr.timeslices {
+		if timeslice.allowedToRun {
+			total += timeslice.weight
+		}
+	}
+	if total <= 0 {
+		return -1, false
+	}
+	tr.passSteps++
+	pick := rand.Float64() * total
+	last := -1
+	for index, timeslice := range tr.timeslices {
+		if !timeslice.allowedToRun {
+			continue
+		}
+		last = index
+		if pick < timeslice.weight {
+			return index, true
+		}
+		pick -= timeslice.weight
+	}
+	return last, true
+}
+
+// runTimeslice runs the timers of a time slice for each of its repeats. A time slice with
+// a duration plays its timers over and over until the duration has passed, or it has
+// played repeat times if repeat is set, and stops the running timer at the deadline.
+func (tr *Trigger) runTimeslice(timeslice *timeslice) {
+	if timeslice.waitFor != "" && !tr.waitForSignal(timeslice) {
+		return
+	}
+	if timeslice.schedule != nil && !tr.waitForSchedule(timeslice) {
+		return
+	}
+	for _, signal := range timeslice.signals {
+		jm := loggos.JSONDebugln("Sending signal")
+		jm.Add("name", tr.name)
+		jm.Add("timeslice_name", timeslice.name)
+		jm.Add("signal", signal)
+		loggos.SendJSON(jm)
+
+		tr.barriers.Signal(signal)
+	}
+	for _, id := range timeslice.startIDs {
+		select {
+		case tr.Ready <- id:
+		case <-tr.shutdown:
+			return
+		}
+	}
+
+	var deadline <-chan time.Time
+	if timeslice.duration > 0 {
+		deadlineTimer := time.NewTimer(tr.scale(timeslice.duration))
+		defer deadlineTimer.Stop()
+		deadline = deadlineTimer.C
+		if len(timeslice.timers) == 0 {
+			tr.waitFor(deadline)
+			tr.markUsed(timeslice)
+			return
+		}
+	}
+
+Plays:
+	for i := 0; i < timeslice.repeat || (timeslice.duration > 0 && timeslice.repeat == 0); i++ {
+		if tr.stopped() {
+			return
+		}
+		for _, timer := range timeslice.timers {
+			if tr.stopped() {
+				return
+			}
+			jm := loggos.JSONDebugln("Starting next timer for time slice")
 			jm.Add("name", tr.name)
+			jm.Add("timeslice_name", timeslice.name)
 			loggos.SendJSON(jm)
 
-			tr.teardown()
-			return
+			stop := make(chan struct{})
+			tc := timer(stop)
+			finished := tr.consumeFromTimer(tc, deadline)
+			close(stop)
+			if !finished {
+				if !tr.stopped() {
+					jm := loggos.JSONDebugln("Time slice duration has passed.")
+					jm.Add("name", tr.name)
+					jm.Add("timeslice_name", timeslice.name)
+					loggos.SendJSON(jm)
+				}
+				break Plays
+			}
 		}
 	}
+
+	tr.markUsed(timeslice)
 }
 
-func (tr *Trigger) consumeFromTimer(tc chan string) {
-	if tr.stopped {
-		return
+// markUsed stops a single use time slice from running again.
+func (tr *Trigger) markUsed(timeslice *timeslice) {
+	if timeslice.singleUse {
+		jm := loggos.JSONDebugln("Time slice is marked as single use. Stopping future runs")
+		jm.Add("name", tr.name)
+		jm.Add("timeslice_name", timeslice.name)
+		loggos.SendJSON(jm)
+
+		timeslice.allowedToRun = false
+	}
+}
+
+// waitForSignal blocks until the signal the time slice waits for has been sent since it
+// last ran. It returns false if the trigger was stopped.
+func (tr *Trigger) waitForSignal(timeslice *timeslice) bool {
+	jm := loggos.JSONDebugln("Waiting for signal")
+	jm.Add("name", tr.name)
+	jm.Add("timeslice_name", timeslice.name)
+	jm.Add("signal", timeslice.waitFor)
+	loggos.SendJSON(jm)
+
+	seen, ok := tr.barriers.wait(timeslice.waitFor, timeslice.waitSeen, tr.shutdown)
+	timeslice.waitSeen = seen
+	return ok && !tr.stopped()
+}
+
+// waitForSchedule blocks until the next time the time slice is scheduled to start. It
+// returns false if the trigger was stopped or the schedule never starts again.
+func (tr *Trigger) waitForSchedule(timeslice *timeslice) bool {
+	start := timeslice.schedule.Next(tr.clock())
+	if start.IsZero() {
+		jm := loggos.JSONInfoln("Time slice schedule has no more start times. Skipping it.")
+		jm.Add("name", tr.name)
+		jm.Add("timeslice_name", timeslice.name)
+		loggos.SendJSON(jm)
+
+		timeslice.allowedToRun = false
+		return false
+	}
+
+	jm := loggos.JSONDebugln("Waiting for the time slice schedule")
+	jm.Add("name", tr.name)
+	jm.Add("timeslice_name", timeslice.name)
+	jm.Add("start_time", start.Format(time.RFC3339))
+	loggos.SendJSON(jm)
+
+	startTimer := time.NewTimer(tr.scale(start.Sub(tr.clock())))
+	defer startTimer.Stop()
+	tr.waitFor(startTimer.C)
+	return !tr.stopped()
+}
+
+// waitFor blocks until the deadline or the trigger is stopped.
+func (tr *Trigger) waitFor(deadline <-chan time.Time) {
+	select {
+	case <-deadline:
+	case <-tr.shutdown:
+	}
+}
+
+// consumeFromTimer passes the ids from a timer to the Ready chan until the timer is finished.
+// It returns false if the deadline passed or the trigger was stopped before the timer finished.
+func (tr *Trigger) consumeFromTimer(tc chan string, deadline <-chan time.Time) bool {
+	if tr.stopped() {
+		return false
 	}
 
 	for {
 		select {
-		case _, ok := <-tr.shutdown:
-			if !ok {
-				jm := loggos.JSONDebugln("Timeline reader is finished and about to stop.")
-				jm.Add("name", tr.name)
-				loggos.SendJSON(jm)
+		case <-tr.shutdown:
+			jm := loggos.JSONDebugln("Timeline reader is finished and about to stop.")
+			jm.Add("name", tr.name)
+			loggos.SendJSON(jm)
 
-				tr.teardown()
-				return
-			}
+			return false
+		case <-deadline:
+			return false
 		case id, ok := <-tc:
 			if !ok {
 				jm := loggos.JSONDebugln("Finished with trigger.")
 				jm.Add("name", tr.name)
 				loggos.SendJSON(jm)
 
-				return
+				return true
+			}
+			select {
+			case tr.Ready <- id:
+			case <-tr.shutdown:
+				return false
 			}
-			tr.Ready <- id
 		}
 	}
 }
 
+// sleep waits for d. It returns false if stop is closed first.
+func sleep(d time.Duration, stop chan struct{}) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// fire sends the id down c. It returns false if stop is closed first.
+func fire(c chan string, id string, stop chan struct{}) bool {
+	select {
+	case c <- id:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
 // AddStaticTrigger will created a trigger that always fires at the same time in milliseconds.
 func (tr *Trigger) AddStaticTrigger(timesliceIndex int, id string, ms, repeat int) {
 	jm := loggos.JSONDebugln("Adding static trigger to the queue")
@@ -197,19 +532,19 @@ func (tr *Trigger) AddStaticTrigger(timesliceIndex int, id string, ms, repeat in
 	jm.Add("milliseconds", ms)
 	loggos.SendJSON(jm)
 
-	f := func() chan string {
+	f := func(stop chan struct{}) chan string {
 		c := make(chan string, 1)
 		go func() {
+			defer close(c)
 			for i := 0; i < repeat; i++ {
-				time.Sleep(time.Millisecond * time.Duration(ms))
-				c <- id
+				if !sleep(tr.modulate(id, time.Millisecond*time.Duration(ms)), stop) || !fire(c, id, stop) {
+					return
+				}
 			}
 			jm := loggos.JSONDebugln("Trigger is finished.")
 			jm.Add("id", id)
 			jm.Add("name", tr.name)
 			loggos.SendJSON(jm)
-
-			close(c)
 		}()
 		return c
 	}
@@ -227,21 +562,22 @@ func (tr *Trigger) AddDynamicTrigger(timesliceIndex int, id string, minMS, varyM
 	jm.Add("maximum_time", minMS+varyMS)
 	loggos.SendJSON(jm)
 
-	f := func() chan string {
+	f := func(stop chan struct{}) chan string {
 		c := make(chan string, 1)
 		go func() {
+			defer close(c)
 			sleeperTime := func() time.Duration {
 				return time.Millisecond * time.Duration(minMS+rand.Intn(varyMS))
 			}
 			for i := 0; i < repeat; i++ {
-				time.Sleep(sleeperTime())
-				c <- id
+				if !sleep(tr.modulate(id, sleeperTime()), stop) || !fire(c, id, stop) {
+					return
+				}
 			}
 			jm := loggos.JSONDebugln("Trigger is finished.")
 			jm.Add("id", id)
 			jm.Add("name", tr.name)
 			loggos.SendJSON(jm)
-			close(c)
 		}()
 		return c
 	}
@@ -249,6 +585,111 @@ func (tr *Trigger) AddDynamicTrigger(timesliceIndex int, id string, minMS, varyM
 	timeslice.timers = append(timeslice.timers, f)
 }
 
+// SetRate makes the time between the fires of the trigger with the id given follow rate.
+// It must be called before Start.
+func (tr *Trigger) SetRate(id string, rate Rate) {
+	tr.rates[id] = rate
+}
+
+// SetClock sets where the trigger gets the time that rates and schedules are worked out
+// for. It defaults to the wall clock.
+func (tr *Trigger) SetClock(clock func() time.Time) {
+	tr.clock = clock
+}
+
+// SetSpeed makes the trigger run speed times faster than the wall clock by dividing every
+// wait by it. It is used with a clock that runs at the same speed to backfill a story.
+func (tr *Trigger) SetSpeed(speed float64) {
+	tr.speed = speed
+}
+
+// scale turns a time on the clock of the trigger into a wall clock time.
+func (tr *Trigger) scale(d time.Duration) time.Duration {
+	return Scale(d, tr.speed)
+}
+
+// Scale turns a time on a clock that runs speed times faster than the wall clock into a
+// wall clock time.
+func Scale(d time.Duration, speed float64) time.Duration {
+	return time.Duration(float64(d) / speed)
+}
+
+// modulate divides the time before the next fire of id by its rate and the speed of the
+// trigger.
+func (tr *Trigger) modulate(id string, d time.Duration) time.Duration {
+	rate, ok := tr.rates[id]
+	if !ok {
+		return tr.scale(d)
+	}
+	factor := rate.Factor(tr.clock())
+	if factor < MinRateFactor {
+		factor = MinRateFactor
+	}
+	return tr.scale(time.Duration(float64(d) / factor))
+}
+
+// AddStartTrigger sends the id down the Ready channel each time the time slice starts,
+// before any of its timers run.
+func (tr *Trigger) AddStartTrigger(timesliceIndex int, id string) {
+	timeslice := tr.timeslices[timesliceIndex]
+	timeslice.startIDs = append(timeslice.startIDs, id)
+}
+
+// SetDuration makes a time slice play its timers until the duration has passed.
+// If the time slice has a repeat it stops after that many plays if that is sooner.
+func (tr *Trigger) SetDuration(timesliceIndex int, duration time.Duration) {
+	tr.timeslices[timesliceIndex].duration = duration
+}
+
+// SetSchedule makes a time slice wait for the next time on the schedule each time it
+// is picked to run.
+func (tr *Trigger) SetSchedule(timesliceIndex int, schedule Schedule) {
+	tr.timeslices[timesliceIndex].schedule = schedule
+}
+
+// SetLoops sets how many times the trigger plays out its time slices before it stops.
+// 0 makes it play them out until it is stopped.
+func (tr *Trigger) SetLoops(loops int) {
+	tr.loops = loops
+	tr.continuous = loops != 1
+}
+
+// SetStartDelay makes the trigger wait before it runs its first time slice.
+func (tr *Trigger) SetStartDelay(delay time.Duration) {
+	tr.startDelay = delay
+}
+
+// SetBarriers gives the trigger the signals that it shares with other triggers.
+// It must be called before WaitFor or AddSignal are used.
+func (tr *Trigger) SetBarriers(barriers *Barriers) {
+	tr.barriers = barriers
+}
+
+// WaitFor makes a time slice wait for the named signal each time it is picked to run.
+// If the signal was sent since the time slice last ran it starts straight away.
+func (tr *Trigger) WaitFor(timesliceIndex int, signal string) {
+	tr.timeslices[timesliceIndex].waitFor = signal
+}
+
+// AddSignal makes a time slice send the named signal each time it starts.
+func (tr *Trigger) AddSignal(timesliceIndex int, signal string) {
+	timeslice := tr.timeslices[timesliceIndex]
+	timeslice.signals = append(timeslice.signals, signal)
+}
+
+// SetWeight changes how likely a time slice is to be picked when weighted random
+// selection is used. Time slices have a weight of 1 by default.
+func (tr *Trigger) SetWeight(timesliceIndex int, weight float64) {
+	tr.timeslices[timesliceIndex].weight = weight
+}
+
+// AddTransition gives a time slice a chance, as a percentage, of moving to another
+// time slice when markov selection is used.
+func (tr *Trigger) AddTransition(fromIndex, toIndex int, weight float64) {
+	timeslice := tr.timeslices[fromIndex]
+	timeslice.transitions = append(timeslice.transitions, transition{to: toIndex, weight: weight})
+}
+
 // NewTimeSlice creates a new timeslice and adds it to the list of timeslices in the trigger.
 // It will return the Index number for the timeslice. To add to this timeslice us the index
 // in the add timer functions.
@@ -259,6 +700,7 @@ func (tr *Trigger) NewTimeSlice(name string, repeat int, singleUse bool) int {
 			repeat:       repeat,
 			singleUse:    singleUse,
 			allowedToRun: true,
+			weight:       1,
 		},
 	)
 	return len(tr.timeslices) - 1
@@ -1,16 +1,22 @@
 package trigger
 
 import (
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/silverstagtech/loggos"
 )
 
+var loggerOnce sync.Once
+
+// setupLogger configures the logger once as changing it while it prints is a race.
 func setupLogger() {
-	loggos.JSONLoggerEnableDebugLogging(true)
-	loggos.JSONLoggerEnablePrettyPrint(true)
-	loggos.JSONLoggerEnableHumanTimestamps(true)
+	loggerOnce.Do(func() {
+		loggos.JSONLoggerEnableDebugLogging(true)
+		loggos.JSONLoggerEnablePrettyPrint(true)
+		loggos.JSONLoggerEnableHumanTimestamps(true)
+	})
 }
 
 func TestTriggerStatic(t *testing.T) {
@@ -116,3 +122,288 @@ func TestNoTriggers(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// readAll reads ids from the trigger until it stops.
+func readAll(t *testing.T, trigger *Trigger) []string {
+	ids := []string{}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case id, ok := <-trigger.Ready:
+			if !ok {
+				return ids
+			}
+			ids = append(ids, id)
+		case <-timeout:
+			t.Logf("The trigger did not finish. Got: %v", ids)
+			t.FailNow()
+		}
+	}
+}
+
+func TestMarkovSelection(t *testing.T) {
+	setupLogger()
+
+	trigger := New("tester", false)
+	if err := trigger.SetSelection(SelectMarkov); err != nil {
+		t.Logf("Failed to set markov selection. Error: %s", err)
+		t.FailNow()
+	}
+	healthy := trigger.NewTimeSlice("healthy", 1, false)
+	degraded := trigger.NewTimeSlice("degraded", 1, false)
+	outage := trigger.NewTimeSlice("outage", 1, false)
+	trigger.AddStaticTrigger(healthy, "healthy", 1, 1)
+	trigger.AddStaticTrigger(degraded, "degraded", 1, 1)
+	trigger.AddStaticTrigger(outage, "outage", 1, 1)
+	trigger.AddStartTrigger(outage, "outage started")
+	trigger.AddTransition(healthy, degraded, 100)
+	trigger.AddTransition(degraded, outage, 50)
+	trigger.Start()
+
+	ids := readAll(t, trigger)
+	if len(ids) < 4 || ids[0] != "healthy" || ids[len(ids)-2] != "outage started" || ids[len(ids)-1] != "outage" {
+		t.Logf("Expected healthy, one or more degraded then the outage. Got: %v", ids)
+		t.Fail()
+	}
+	for _, id := range ids[1 : len(ids)-2] {
+		if id != "degraded" {
+			t.Logf("Expected only degraded between healthy and the outage. Got: %v", ids)
+			t.Fail()
+		}
+	}
+
+	if err := New("tester", false).SetSelection("sideways"); err == nil {
+		t.Logf("Expected an error for an unknown selection.")
+		t.Fail()
+	}
+}
+
+func TestWeightedRandomSelection(t *testing.T) {
+	setupLogger()
+
+	trigger := New("tester", false)
+	if err := trigger.SetSelection(SelectWeightedRandom); err != nil {
+		t.Logf("Failed to set weighted random selection. Error: %s", err)
+		t.FailNow()
+	}
+	once := trigger.NewTimeSlice("once", 1, true)
+	often := trigger.NewTimeSlice("often", 1, false)
+	never := trigger.NewTimeSlice("never", 1, false)
+	trigger.AddStaticTrigger(once, "once", 1, 1)
+	trigger.AddStaticTrigger(often, "often", 1, 1)
+	trigger.AddStaticTrigger(never, "never", 1, 1)
+	trigger.SetWeight(once, 1000000000)
+	trigger.SetWeight(never, 0)
+	trigger.Start()
+
+	// A pass picks as many time slices as there are. The single use time slice can only
+	// be picked once so the rest of the pass must pick the other one.
+	ids := readAll(t, trigger)
+	if len(ids) != 3 || ids[0] != "once" || ids[1] != "often" || ids[2] != "often" {
+		t.Logf("Expected once then often twice. Got: %v", ids)
+		t.Fail()
+	}
+}
+
+func TestTimesliceDuration(t *testing.T) {
+	setupLogger()
+
+	trigger := New("tester", false)
+	index := trigger.NewTimeSlice("bounded", 0, false)
+	trigger.AddStaticTrigger(index, "tick", 10, 1000)
+	trigger.SetDuration(index, 100*time.Millisecond)
+	starttime := time.Now()
+	trigger.Start()
+
+	// The upper bounds are generous so that a loaded computer doesn't fail the test. The
+	// 1000 ticks would take 10s if the duration didn't stop them.
+	ids := readAll(t, trigger)
+	stoptime := time.Since(starttime)
+	if stoptime < 100*time.Millisecond || stoptime > 5*time.Second {
+		t.Logf("TestTimesliceDuration took %s, expected about 100ms.", stoptime)
+		t.Fail()
+	}
+	if len(ids) < 1 || len(ids) > 10 {
+		t.Logf("Expected at most 10 ticks before the duration passed. Got %d", len(ids))
+		t.Fail()
+	}
+}
+
+func TestEmptyTimesliceDuration(t *testing.T) {
+	setupLogger()
+
+	trigger := New("tester", false)
+	quiet := trigger.NewTimeSlice("quiet", 0, false)
+	trigger.SetDuration(quiet, 20*time.Millisecond)
+	after := trigger.NewTimeSlice("after", 1, false)
+	trigger.AddStaticTrigger(after, "after", 1, 1)
+	trigger.Start()
+
+	if ids := readAll(t, trigger); len(ids) != 1 || ids[0] != "after" {
+		t.Logf("Expected the quiet time slice to pass and the next one to run. Got: %v", ids)
+		t.Fail()
+	}
+}
+
+// scheduleFunc lets a func be used as a Schedule.
+type scheduleFunc func(time.Time) time.Time
+
+func (f scheduleFunc) Next(after time.Time) time.Time {
+	return f(after)
+}
+
+func TestScheduledTimeslice(t *testing.T) {
+	setupLogger()
+
+	trigger := New("tester", false)
+	index := trigger.NewTimeSlice("scheduled", 1, false)
+	trigger.AddStaticTrigger(index, "batch", 1, 1)
+	trigger.SetSchedule(index, scheduleFunc(func(after time.Time) time.Time {
+		return after.Add(50 * time.Millisecond)
+	}))
+	never := trigger.NewTimeSlice("never", 1, false)
+	trigger.AddStaticTrigger(never, "never", 1, 1)
+	trigger.SetSchedule(never, scheduleFunc(func(time.Time) time.Time {
+		return time.Time{}
+	}))
+	starttime := time.Now()
+	trigger.Start()
+
+	ids := readAll(t, trigger)
+	stoptime := time.Since(starttime)
+	if len(ids) != 1 || ids[0] != "batch" {
+		t.Logf("Expected only the scheduled time slice to run. Got: %v", ids)
+		t.Fail()
+	}
+	// The time slice can't start before its schedule. The upper bound is generous so that
+	// a loaded computer doesn't fail the test.
+	if stoptime < 50*time.Millisecond || stoptime > 2*time.Second {
+		t.Logf("TestScheduledTimeslice took %s, expected about 50ms.", stoptime)
+		t.Fail()
+	}
+}
+
+func TestStartDelayAndBarriers(t *testing.T) {
+	setupLogger()
+
+	barriers := NewBarriers()
+	database := New("database", false)
+	database.SetBarriers(barriers)
+	database.SetStartDelay(50 * time.Millisecond)
+	outage := database.NewTimeSlice("outage", 1, false)
+	database.AddStaticTrigger(outage, "database down", 1, 1)
+	database.AddSignal(outage, "db_outage_started")
+
+	api := New("api", false)
+	api.SetBarriers(barriers)
+	latency := api.NewTimeSlice("latency", 1, false)
+	api.AddStaticTrigger(latency, "api slow", 1, 1)
+	api.WaitFor(latency, "db_outage_started")
+
+	starttime := time.Now()
+	api.Start()
+	database.Start()
+
+	apiIDs := readAll(t, api)
+	apiTime := time.Since(starttime)
+	databaseIDs := readAll(t, database)
+	if len(apiIDs) != 1 || len(databaseIDs) != 1 {
+		t.Logf("Expected each timeline to fire once. Got: %v and %v", apiIDs, databaseIDs)
+		t.Fail()
+	}
+	// The api can't finish before the database signal is sent after its start delay. The
+	// upper bound is generous so that a loaded computer doesn't fail the test.
+	if apiTime < 50*time.Millisecond || apiTime > 2*time.Second {
+		t.Logf("The api timeline should wait for the database signal after about 50ms. It took %s", apiTime)
+		t.Fail()
+	}
+
+	// A signal that was sent before a time slice gets to it doesn't make it wait.
+	late := New("late", false)
+	late.SetBarriers(barriers)
+	afterwards := late.NewTimeSlice("afterwards", 1, false)
+	late.AddStaticTrigger(afterwards, "afterwards", 1, 1)
+	late.WaitFor(afterwards, "db_outage_started")
+	late.Start()
+	if ids := readAll(t, late); len(ids) != 1 {
+		t.Logf("Expected the late timeline to fire once. Got: %v", ids)
+		t.Fail()
+	}
+}
+
+func TestLoops(t *testing.T) {
+	setupLogger()
+
+	trigger := New("tester", false)
+	trigger.SetLoops(3)
+	index := trigger.NewTimeSlice("looped", 1, false)
+	trigger.AddStaticTrigger(index, "loop", 1, 2)
+	trigger.Start()
+
+	if ids := readAll(t, trigger); len(ids) != 6 {
+		t.Logf("Expected 3 loops of 2 ids. Got: %v", ids)
+		t.Fail()
+	}
+}
+
+type rateFunc func(time.Time) float64
+
+func (f rateFunc) Factor(at time.Time) float64 {
+	return f(at)
+}
+
+func TestRate(t *testing.T) {
+	setupLogger()
+
+	clock := time.Date(2023, 1, 2, 12, 0, 0, 0, time.UTC)
+	trigger := New("tester", false)
+	index := trigger.NewTimeSlice("busy", 1, false)
+	trigger.AddStaticTrigger(index, "fast", 200, 5)
+	trigger.SetRate("fast", rateFunc(func(at time.Time) float64 {
+		if !at.Equal(clock) {
+			t.Logf("The rate should be worked out for the trigger clock. Got: %s", at)
+			t.Fail()
+		}
+		return 20
+	}))
+	trigger.SetClock(func() time.Time { return clock })
+
+	start := time.Now()
+	trigger.Start()
+	ids := readAll(t, trigger)
+	// 5 fires 200ms apart take 1s, 20 times faster is 50ms.
+	if elapsed := time.Since(start); len(ids) != 5 || elapsed > 500*time.Millisecond {
+		t.Logf("Expected 5 fast ids well before 1s. Got: %v in %s", ids, elapsed)
+		t.Fail()
+	}
+
+	// A trigger that runs 10 times faster waits a tenth of the time and works out its
+	// schedules on its own clock.
+	fast := New("tester", false)
+	fast.SetSpeed(10)
+	fast.SetClock(func() time.Time { return clock })
+	scheduled := fast.NewTimeSlice("scheduled", 1, false)
+	fast.AddStaticTrigger(scheduled, "scheduled", 100, 5)
+	fast.SetSchedule(scheduled, scheduleFunc(func(after time.Time) time.Time {
+		if !after.Equal(clock) {
+			t.Logf("The schedule should be worked out for the trigger clock. Got: %s", after)
+			t.Fail()
+		}
+		return after.Add(time.Second)
+	}))
+	start = time.Now()
+	fast.Start()
+	// 1s of waiting for the schedule and 5 fires 100ms apart take 150ms at 10 times faster.
+	ids = readAll(t, fast)
+	if elapsed := time.Since(start); len(ids) != 5 || elapsed > time.Second {
+		t.Logf("Expected 5 ids well before 1.5s. Got: %v in %s", ids, elapsed)
+		t.Fail()
+	}
+
+	slow := New("tester", false)
+	slow.SetRate("stopped", rateFunc(func(time.Time) float64 { return 0 }))
+	if d := slow.modulate("stopped", time.Millisecond); d != 100*time.Millisecond {
+		t.Logf("A rate of 0 should be slowed to the minimum rate. Got: %s", d)
+		t.Fail()
+	}
+}